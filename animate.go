@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	// Height of the bar under each frame with the frame counter and party legend
+	LegendHeight = 40
+	// Opacity of the party colors drawn over owned tiles
+	TerritoryAlpha = 110
+	// Longest delay between frames in milliseconds. GIF stores delays in hundredths of a
+	// second in 16 bits.
+	MaxFrameDelay = 65535 * 10
+)
+
+var (
+	AnimationBackground = [3]int{32, 32, 32}
+	pngSignature        = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// drawTerritory tints every tile owned by a party with the party color
+func drawTerritory(dc *gg.Context, mapData *MapData) {
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			party := mapData.At(x, z).Party
+			if party < 0 || party >= len(PartyColors) {
+				continue
+			}
+			color := PartyColors[party]
+			fillHex(dc, x, z, color[0], color[1], color[2], TerritoryAlpha)
+		}
+	}
+}
+
+// renderReplayFrame draws a map state with its territory, and a bar underneath with
+// the frame counter, the frame label and the number of tiles owned by each party
+func renderReplayFrame(he3Map *fileio.HE3Map, frame int, frameCount int, label string) image.Image {
+	mapData := newMapData(he3Map)
+	mapImage := renderMap(mapData, func(dc *gg.Context) {
+		drawTerritory(dc, mapData)
+	}).Image()
+
+	width := mapImage.Bounds().Dx()
+	height := mapImage.Bounds().Dy()
+	dc := gg.NewContext(width, height+LegendHeight)
+	dc.SetRGB255(AnimationBackground[0], AnimationBackground[1], AnimationBackground[2])
+	dc.Clear()
+	dc.DrawImage(mapImage, 0, 0)
+
+	dc.SetRGB255(255, 255, 255)
+	counter := fmt.Sprintf("Frame %d/%d", frame+1, frameCount)
+	if label != "" {
+		counter += "  " + label
+	}
+	dc.DrawString(counter, 10, float64(height)+LegendHeight/2+4)
+
+	tiles := [len(PartyColors)]int{}
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			party := mapData.At(x, z).Party
+			if party >= 0 && party < len(PartyColors) {
+				tiles[party]++
+			}
+		}
+	}
+	legendX := float64(width) - 10
+	for party := len(PartyColors) - 1; party >= 0; party-- {
+		if tiles[party] == 0 {
+			continue
+		}
+		text := fmt.Sprintf("%d: %d", party, tiles[party])
+		textWidth, _ := dc.MeasureString(text)
+		legendX -= textWidth
+		dc.SetRGB255(255, 255, 255)
+		dc.DrawString(text, legendX, float64(height)+LegendHeight/2+4)
+		legendX -= 16
+		color := PartyColors[party]
+		dc.DrawRectangle(legendX, float64(height)+LegendHeight/2-6, 12, 12)
+		dc.SetRGB255(color[0], color[1], color[2])
+		dc.Fill()
+		legendX -= 14
+	}
+	return dc.Image()
+}
+
+// animationPalette contains the colors the frames are drawn with, including the terrain
+// colors tinted by each party, and fills the rest with Plan 9 colors for antialiased edges
+func animationPalette() color.Palette {
+	base := [][3]int{
+		AnimationBackground,
+		{255, 255, 255},
+		{0, 0, 0},
+		{78, 53, 36},
+		{89, 90, 86},
+		{234, 244, 253},
+	}
+	for _, tile := range []*fileio.MapTile{
+		{IsSea: true},
+		{TileType: fileio.Grass},
+		{TileType: fileio.Sand},
+		{TileType: fileio.Farmland},
+		{TileType: fileio.Forest},
+		{TileType: fileio.Snow},
+		{TileType: fileio.Factory},
+		{TileType: fileio.Town},
+		{TileType: fileio.City},
+		{TileType: fileio.Capital},
+	} {
+		mapData := singleTileMapData(tile)
+		r, g, b := getTileColor(tile, mapData, 0, 0)
+		base = append(base, [3]int{r, g, b})
+	}
+	// Ports are only colored next to the sea
+	base = append(base, [3]int{75, 113, 224})
+
+	colors := color.Palette{}
+	seen := map[color.RGBA]bool{}
+	add := func(r, g, b int) {
+		c := color.RGBA{uint8(r), uint8(g), uint8(b), 255}
+		if !seen[c] && len(colors) < 256 {
+			seen[c] = true
+			colors = append(colors, c)
+		}
+	}
+	for _, partyColor := range PartyColors {
+		add(partyColor[0], partyColor[1], partyColor[2])
+	}
+	for _, baseColor := range base {
+		add(baseColor[0], baseColor[1], baseColor[2])
+		for _, partyColor := range PartyColors {
+			blend := func(i int) int {
+				return (baseColor[i]*(255-TerritoryAlpha) + partyColor[i]*TerritoryAlpha) / 255
+			}
+			add(blend(0), blend(1), blend(2))
+		}
+	}
+	for _, c := range palette.Plan9 {
+		r, g, b, _ := c.RGBA()
+		add(int(r>>8), int(g>>8), int(b>>8))
+	}
+	return colors
+}
+
+func checkFrameDelay(delayMs int) error {
+	if delayMs < 0 || delayMs > MaxFrameDelay {
+		return fmt.Errorf("delay must be between 0 and %d ms, got %d", MaxFrameDelay, delayMs)
+	}
+	return nil
+}
+
+// writeGIF encodes frameCount frames from renderFrame as a looping GIF with delayMs between frames
+func writeGIF(w io.Writer, frameCount int, renderFrame func(i int) image.Image, delayMs int) error {
+	if err := checkFrameDelay(delayMs); err != nil {
+		return err
+	}
+	animation := &gif.GIF{}
+	colors := animationPalette()
+	indexCache := map[color.Color]uint8{}
+	for i := 0; i < frameCount; i++ {
+		frame := renderFrame(i)
+		paletted := image.NewPaletted(frame.Bounds(), colors)
+		bounds := frame.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				// Frames only use a few colors, so remember the closest palette entry for each
+				c := frame.At(x, y)
+				index, ok := indexCache[c]
+				if !ok {
+					index = uint8(colors.Index(c))
+					indexCache[c] = index
+				}
+				paletted.SetColorIndex(x, y, index)
+			}
+		}
+		animation.Image = append(animation.Image, paletted)
+		animation.Delay = append(animation.Delay, delayMs/10)
+	}
+	return gif.EncodeAll(w, animation)
+}
+
+type pngChunk struct {
+	chunkType string
+	data      []byte
+}
+
+func readPNGChunks(content []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(content, pngSignature) {
+		return nil, fmt.Errorf("missing PNG signature")
+	}
+	chunks := []pngChunk{}
+	for offset := len(pngSignature); offset+12 <= len(content); {
+		length := int(binary.BigEndian.Uint32(content[offset:]))
+		if offset+12+length > len(content) {
+			return nil, fmt.Errorf("PNG chunk is longer than the file")
+		}
+		chunks = append(chunks, pngChunk{
+			chunkType: string(content[offset+4 : offset+8]),
+			data:      content[offset+8 : offset+8+length],
+		})
+		offset += 12 + length
+	}
+	return chunks, nil
+}
+
+func writePNGChunk(w io.Writer, chunkType string, data []byte) error {
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	copy(header[4:], chunkType)
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+	footer := binary.BigEndian.AppendUint32(nil, crc.Sum32())
+	for _, part := range [][]byte{header, data, footer} {
+		if _, err := w.Write(part); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apngDelay returns the delay as the numerator and denominator of a fraction of a second.
+// Delays that don't fit in milliseconds are stored in hundredths of a second.
+func apngDelay(delayMs int) (uint16, uint16, error) {
+	if err := checkFrameDelay(delayMs); err != nil {
+		return 0, 0, err
+	}
+	if delayMs <= math.MaxUint16 {
+		return uint16(delayMs), 1000, nil
+	}
+	return uint16((delayMs + 5) / 10), 100, nil
+}
+
+// writeAPNG encodes frameCount frames from renderFrame as a looping animated PNG.
+// Each frame is encoded with image/png and its image data is moved into APNG frame chunks.
+// All frames must have the same size and PNG color type, because they share the header.
+func writeAPNG(w io.Writer, frameCount int, renderFrame func(i int) image.Image, delayMs int) error {
+	if frameCount == 0 {
+		return fmt.Errorf("no frames to write")
+	}
+	delayNumerator, delayDenominator, err := apngDelay(delayMs)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(pngSignature); err != nil {
+		return err
+	}
+
+	sequence := uint32(0)
+	var header []byte
+	for i := 0; i < frameCount; i++ {
+		frame := renderFrame(i)
+		encoded := new(bytes.Buffer)
+		if err := png.Encode(encoded, frame); err != nil {
+			return err
+		}
+		chunks, err := readPNGChunks(encoded.Bytes())
+		if err != nil {
+			return err
+		}
+
+		if len(chunks) == 0 || chunks[0].chunkType != "IHDR" {
+			return fmt.Errorf("PNG does not start with IHDR")
+		}
+		if i > 0 && !bytes.Equal(chunks[0].data, header) {
+			return fmt.Errorf("frame %d has a different size or color type than the first frame", i+1)
+		}
+		if i == 0 {
+			header = chunks[0].data
+			if err := writePNGChunk(w, "IHDR", header); err != nil {
+				return err
+			}
+			// Frame count, then 0 to loop forever
+			animationControl := binary.BigEndian.AppendUint32(nil, uint32(frameCount))
+			animationControl = binary.BigEndian.AppendUint32(animationControl, 0)
+			if err := writePNGChunk(w, "acTL", animationControl); err != nil {
+				return err
+			}
+		}
+
+		bounds := frame.Bounds()
+		frameControl := binary.BigEndian.AppendUint32(nil, sequence)
+		frameControl = binary.BigEndian.AppendUint32(frameControl, uint32(bounds.Dx()))
+		frameControl = binary.BigEndian.AppendUint32(frameControl, uint32(bounds.Dy()))
+		// x and y offset
+		frameControl = binary.BigEndian.AppendUint32(frameControl, 0)
+		frameControl = binary.BigEndian.AppendUint32(frameControl, 0)
+		// Delay as a fraction in seconds
+		frameControl = binary.BigEndian.AppendUint16(frameControl, delayNumerator)
+		frameControl = binary.BigEndian.AppendUint16(frameControl, delayDenominator)
+		// Dispose and blend operations: none, source
+		frameControl = append(frameControl, 0, 0)
+		if err := writePNGChunk(w, "fcTL", frameControl); err != nil {
+			return err
+		}
+		sequence++
+
+		for _, chunk := range chunks {
+			if chunk.chunkType != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				err = writePNGChunk(w, "IDAT", chunk.data)
+			} else {
+				frameData := binary.BigEndian.AppendUint32(nil, sequence)
+				err = writePNGChunk(w, "fdAT", append(frameData, chunk.data...))
+				sequence++
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return writePNGChunk(w, "IEND", nil)
+}
+
+// runAnimate renders every state of a replay into a GIF, or an APNG when the output ends in .png or .apng
+func runAnimate(inputFilename, outputFilename string, delayMs int) error {
+	if err := checkFrameDelay(delayMs); err != nil {
+		return err
+	}
+	replay, err := fileio.ReadReplayFile(inputFilename)
+	if err != nil {
+		return err
+	}
+	states := replay.States()
+	renderFrame := func(i int) image.Image {
+		label := "Start"
+		if i > 0 {
+			label = replay.Frames[i-1].Label
+		}
+		return renderReplayFrame(states[i], i, len(states), label)
+	}
+
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	extension := strings.ToLower(filepath.Ext(outputFilename))
+	if extension == ".png" || extension == ".apng" {
+		err = writeAPNG(outputFile, len(states), renderFrame, delayMs)
+	} else {
+		err = writeGIF(outputFile, len(states), renderFrame, delayMs)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Saved", len(states), "frames to", outputFilename)
+	return outputFile.Close()
+}
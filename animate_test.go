@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestAPNGDelay(t *testing.T) {
+	tests := []struct {
+		delayMs     int
+		numerator   uint16
+		denominator uint16
+	}{
+		{0, 0, 1000},
+		{500, 500, 1000},
+		{65535, 65535, 1000},
+		{65536, 6554, 100},
+		{MaxFrameDelay, 65535, 100},
+	}
+	for _, test := range tests {
+		numerator, denominator, err := apngDelay(test.delayMs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if numerator != test.numerator || denominator != test.denominator {
+			t.Errorf("delay %d ms: got %d/%d, expected %d/%d",
+				test.delayMs, numerator, denominator, test.numerator, test.denominator)
+		}
+	}
+	for _, delayMs := range []int{-1, MaxFrameDelay + 1} {
+		if _, _, err := apngDelay(delayMs); err == nil {
+			t.Errorf("expected an error for delay %d ms", delayMs)
+		}
+	}
+}
+
+func TestWriteAPNGRejectsMismatchedFrames(t *testing.T) {
+	opaque := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for i := range opaque.Pix {
+		opaque.Pix[i] = 255
+	}
+	// A transparent frame is encoded with an alpha channel, unlike the opaque frame
+	transparent := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	larger := image.NewRGBA(image.Rect(0, 0, 5, 4))
+	larger.Set(0, 0, color.White)
+
+	for _, second := range []image.Image{transparent, larger} {
+		frames := []image.Image{opaque, second}
+		err := writeAPNG(&bytes.Buffer{}, len(frames), func(i int) image.Image { return frames[i] }, 100)
+		if err == nil {
+			t.Errorf("expected an error for a %v frame after an opaque %v frame", second.Bounds(), opaque.Bounds())
+		}
+	}
+
+	frames := []image.Image{opaque, opaque}
+	var output bytes.Buffer
+	if err := writeAPNG(&output, len(frames), func(i int) image.Image { return frames[i] }, 100); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := readPNGChunks(output.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, chunk := range chunks {
+		counts[chunk.chunkType]++
+	}
+	if counts["IHDR"] != 1 || counts["acTL"] != 1 || counts["fcTL"] != 2 || counts["fdAT"] == 0 || counts["IEND"] != 1 {
+		t.Errorf("unexpected chunks %v", counts)
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// BatchStepOptions are the keys each batch operation accepts besides op
+var BatchStepOptions = map[string][]string{
+	"transform": {"transforms", "rect", "pad", "width", "depth"},
+	"validate":  {"output"},
+	"visualize": {"output"},
+	"export":    {"format", "output"},
+}
+
+// BatchListOptions are the comma separated options, which can also be given as a list
+var BatchListOptions = []string{"transforms", "rect", "pad"}
+
+// BatchExportExtensions are the export formats and the extensions of their default output
+var BatchExportExtensions = map[string]string{
+	"he3":      ".he3",
+	"text":     ".txt",
+	"textconv": ".textconv.txt",
+	"geojson":  ".geojson",
+	"html":     ".html",
+	"svg":      ".svg",
+	"metadata": ".json",
+}
+
+type BatchStep struct {
+	Op      string
+	Options map[string]string
+}
+
+type BatchManifest struct {
+	// Directory the manifest is in. Inputs and outputs are relative to it.
+	Dir string
+	// Directory outputs are written to
+	Output   string
+	Workers  int
+	Inputs   []string
+	Pipeline []BatchStep
+}
+
+type BatchResult struct {
+	Input   string   `json:"input"`
+	OK      bool     `json:"ok"`
+	Error   string   `json:"error,omitempty"`
+	Outputs []string `json:"outputs"`
+}
+
+type BatchReport struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Results   []BatchResult `json:"results"`
+}
+
+func yamlString(value any, name string) (string, error) {
+	text, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s must be a single value", name)
+	}
+	return text, nil
+}
+
+// yamlStrings accepts a list of values or a single value
+func yamlStrings(value any, name string) ([]string, error) {
+	if text, ok := value.(string); ok {
+		return []string{text}, nil
+	}
+	items, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%s must be a list", name)
+	}
+	texts := []string{}
+	for i, item := range items {
+		text, err := yamlString(item, fmt.Sprintf("%s item %d", name, i+1))
+		if err != nil {
+			return nil, err
+		}
+		texts = append(texts, text)
+	}
+	return texts, nil
+}
+
+func parseBatchStep(value any, number int) (BatchStep, error) {
+	mapping, ok := value.(map[string]any)
+	if !ok {
+		return BatchStep{}, fmt.Errorf("pipeline step %d must be a mapping with op", number)
+	}
+	step := BatchStep{Options: map[string]string{}}
+	for key, value := range mapping {
+		name := fmt.Sprintf("pipeline step %d %s", number, key)
+		var text string
+		var err error
+		if slices.Contains(BatchListOptions, key) {
+			var items []string
+			items, err = yamlStrings(value, name)
+			text = strings.Join(items, ",")
+		} else {
+			text, err = yamlString(value, name)
+		}
+		if err != nil {
+			return BatchStep{}, err
+		}
+		if key == "op" {
+			step.Op = text
+		} else {
+			step.Options[key] = text
+		}
+	}
+	allowed, ok := BatchStepOptions[step.Op]
+	if !ok {
+		return BatchStep{}, fmt.Errorf("pipeline step %d has unknown op %q, expected validate, visualize, export or transform", number, step.Op)
+	}
+	for key := range step.Options {
+		if !slices.Contains(allowed, key) {
+			return BatchStep{}, fmt.Errorf("pipeline step %d: %s doesn't take %s", number, step.Op, key)
+		}
+	}
+	if step.Op == "export" {
+		if _, ok := BatchExportExtensions[step.Options["format"]]; !ok {
+			return BatchStep{}, fmt.Errorf("pipeline step %d exports unknown format %q", number, step.Options["format"])
+		}
+	}
+	if step.Op == "transform" && step.Options["transforms"] == "" {
+		return BatchStep{}, fmt.Errorf("pipeline step %d: transform needs transforms", number)
+	}
+	return step, nil
+}
+
+// parseBatchManifest reads a manifest such as
+//
+//	workers: 4
+//	output: build
+//	inputs:
+//	  - maps/*.he3
+//	pipeline:
+//	  - op: validate
+//	  - op: visualize
+//	    output: "{name}.png"
+func parseBatchManifest(text string, dir string, defaultWorkers int) (*BatchManifest, error) {
+	root, err := parseYAML(text)
+	if err != nil {
+		return nil, err
+	}
+	mapping, ok := root.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("manifest must be a mapping with inputs and pipeline")
+	}
+	manifest := &BatchManifest{Dir: dir, Output: ".", Workers: defaultWorkers}
+	for key, value := range mapping {
+		switch key {
+		case "workers":
+			text, err := yamlString(value, key)
+			if err != nil {
+				return nil, err
+			}
+			manifest.Workers, err = strconv.Atoi(text)
+			if err != nil || manifest.Workers <= 0 {
+				return nil, fmt.Errorf("workers must be a positive number, got %q", text)
+			}
+		case "output":
+			if manifest.Output, err = yamlString(value, key); err != nil {
+				return nil, err
+			}
+		case "inputs":
+			if manifest.Inputs, err = yamlStrings(value, key); err != nil {
+				return nil, err
+			}
+		case "pipeline":
+			steps, ok := value.([]any)
+			if !ok {
+				return nil, fmt.Errorf("pipeline must be a list of steps")
+			}
+			for i, value := range steps {
+				step, err := parseBatchStep(value, i+1)
+				if err != nil {
+					return nil, err
+				}
+				manifest.Pipeline = append(manifest.Pipeline, step)
+			}
+		default:
+			return nil, fmt.Errorf("unknown manifest key %q, expected workers, output, inputs or pipeline", key)
+		}
+	}
+	if len(manifest.Inputs) == 0 {
+		return nil, fmt.Errorf("manifest has no inputs")
+	}
+	if len(manifest.Pipeline) == 0 {
+		return nil, fmt.Errorf("manifest has no pipeline")
+	}
+	return manifest, nil
+}
+
+// resolve returns a path from the manifest relative to the manifest's directory
+func (manifest *BatchManifest) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(manifest.Dir, path)
+}
+
+// expandInputs returns the files matching the input globs, failing if two of them
+// would write to the same outputs
+func (manifest *BatchManifest) expandInputs() ([]string, error) {
+	inputs := []string{}
+	seen := map[string]bool{}
+	names := map[string]string{}
+	for _, pattern := range manifest.Inputs {
+		matches, err := filepath.Glob(manifest.resolve(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid input pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("input pattern %q matches no files", pattern)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+			name := batchName(match)
+			if other, ok := names[name]; ok {
+				return nil, fmt.Errorf("inputs %s and %s have the same name, so their outputs would clash", other, match)
+			}
+			names[name] = match
+			inputs = append(inputs, match)
+		}
+	}
+	return inputs, nil
+}
+
+// batchName is the input filename without its directory and extension, used for {name}
+func batchName(input string) string {
+	return strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+}
+
+// outputPath returns where a step writes its output for an input, creating the directory
+func (manifest *BatchManifest) outputPath(step BatchStep, input string, defaultTemplate string) (string, error) {
+	template := step.Options["output"]
+	if template == "" {
+		template = defaultTemplate
+	}
+	path := filepath.Join(manifest.resolve(manifest.Output), strings.ReplaceAll(template, "{name}", batchName(input)))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeBatchFile writes an output file, removing it if writing fails
+func writeBatchFile(filename string, write func(w io.Writer) error) error {
+	outputFile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(outputFile); err != nil {
+		outputFile.Close()
+		os.Remove(filename)
+		return err
+	}
+	return outputFile.Close()
+}
+
+func exportBatchMap(w io.Writer, mapData *fileio.HE3Map, format string) error {
+	switch format {
+	case "he3":
+		content, err := fileio.Serialize(mapData)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, content)
+		return err
+	case "text":
+		return fileio.WriteTextMap(w, mapData)
+	case "textconv":
+		return writeCanonicalText(w, mapData)
+	case "geojson":
+		return writeJSON(w, exportGeoJSON(mapData, GeoReference{HexSize: 1}))
+	case "html":
+		return writeViewer(w, mapData)
+	case "svg":
+		return writeSVG(w, newMapData(mapData))
+	default:
+		return writeJSON(w, newMapMetadata(mapData))
+	}
+}
+
+// runBatchStep runs one step of the pipeline and returns the map for the next step and
+// the files written
+func (manifest *BatchManifest) runBatchStep(step BatchStep, input string, mapData *fileio.HE3Map) (*fileio.HE3Map, []string, error) {
+	switch step.Op {
+	case "transform":
+		settings := TransformSettings{Rect: step.Options["rect"], Pad: step.Options["pad"]}
+		for _, option := range []struct {
+			name  string
+			value *int32
+		}{{"width", &settings.Width}, {"depth", &settings.Depth}} {
+			if text, ok := step.Options[option.name]; ok {
+				value, err := strconv.ParseInt(text, 10, 32)
+				if err != nil {
+					return nil, nil, fmt.Errorf("%s must be a number, got %q", option.name, text)
+				}
+				*option.value = int32(value)
+			}
+		}
+		for _, operation := range strings.Split(step.Options["transforms"], ",") {
+			operation = strings.TrimSpace(operation)
+			transformed, err := transformMap(mapData, operation, settings)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", operation, err)
+			}
+			mapData = transformed
+		}
+		return mapData, nil, nil
+	case "validate":
+		report := validateMap(mapData)
+		outputs := []string{}
+		if step.Options["output"] != "" {
+			filename, err := manifest.outputPath(step, input, "")
+			if err != nil {
+				return nil, nil, err
+			}
+			err = writeBatchFile(filename, func(w io.Writer) error {
+				if filepath.Ext(filename) == ".json" {
+					return writeJSON(w, report)
+				}
+				return writeValidationText(w, report)
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+			outputs = append(outputs, filename)
+		}
+		if !report.Valid {
+			errors := 0
+			for _, issue := range report.Issues {
+				if issue.Severity == SeverityError {
+					errors++
+				}
+			}
+			return nil, outputs, fmt.Errorf("map has %d validation errors", errors)
+		}
+		return mapData, outputs, nil
+	case "visualize":
+		filename, err := manifest.outputPath(step, input, "{name}.png")
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := renderMap(newMapData(mapData), nil).SavePNG(filename); err != nil {
+			return nil, nil, err
+		}
+		return mapData, []string{filename}, nil
+	default:
+		format := step.Options["format"]
+		filename, err := manifest.outputPath(step, input, "{name}"+BatchExportExtensions[format])
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := writeBatchFile(filename, func(w io.Writer) error { return exportBatchMap(w, mapData, format) }); err != nil {
+			return nil, nil, err
+		}
+		return mapData, []string{filename}, nil
+	}
+}
+
+// runBatchJob runs the pipeline on one input. A failing step stops the pipeline for this
+// input only, and a panic is reported as a failure instead of stopping the batch.
+func (manifest *BatchManifest) runBatchJob(input string) (result BatchResult) {
+	result = BatchResult{Input: input, Outputs: []string{}}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			result.OK = false
+			result.Error = fmt.Sprint("panic: ", recovered)
+		}
+	}()
+
+	content, err := os.ReadFile(input)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	mapData, err := fileio.Deserialize(content)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for i, step := range manifest.Pipeline {
+		var outputs []string
+		mapData, outputs, err = manifest.runBatchStep(step, input, mapData)
+		result.Outputs = append(result.Outputs, outputs...)
+		if err != nil {
+			result.Error = fmt.Sprintf("step %d (%s): %v", i+1, step.Op, err)
+			return result
+		}
+	}
+	result.OK = true
+	return result
+}
+
+// runBatch runs the manifest's pipeline on every input in a pool of workers and prints
+// progress to stderr. Returns false if any input failed.
+func runBatch(manifestFilename string, workers int, format, reportFilename string) (bool, error) {
+	text, err := os.ReadFile(manifestFilename)
+	if err != nil {
+		return false, err
+	}
+	manifest, err := parseBatchManifest(string(text), filepath.Dir(manifestFilename), workers)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", manifestFilename, err)
+	}
+	inputs, err := manifest.expandInputs()
+	if err != nil {
+		return false, err
+	}
+
+	results := make([]BatchResult, len(inputs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	var progress sync.Mutex
+	done := 0
+	for worker := 0; worker < max(1, min(manifest.Workers, len(inputs))); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = manifest.runBatchJob(inputs[i])
+				progress.Lock()
+				done++
+				if results[i].OK {
+					fmt.Fprintf(os.Stderr, "[%d/%d] ok    %s\n", done, len(inputs), inputs[i])
+				} else {
+					fmt.Fprintf(os.Stderr, "[%d/%d] FAIL  %s: %s\n", done, len(inputs), inputs[i], results[i].Error)
+				}
+				progress.Unlock()
+			}
+		}()
+	}
+	for i := range inputs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	report := &BatchReport{Results: results}
+	for _, result := range results {
+		if result.OK {
+			report.Succeeded++
+		} else {
+			report.Failed++
+		}
+	}
+	err = writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, report)
+		}
+		return writeBatchText(w, report)
+	})
+	return report.Failed == 0, err
+}
+
+func writeBatchText(w io.Writer, report *BatchReport) error {
+	for _, result := range report.Results {
+		if !result.OK {
+			fmt.Fprintf(w, "FAIL  %s: %s\n", result.Input, result.Error)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d succeeded, %d failed\n", report.Succeeded, report.Failed)
+	return err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBatchManifestLists(t *testing.T) {
+	text := `inputs: [a.he3, b.he3]
+pipeline:
+  - op: transform
+    transforms: [pad, mirror-h]
+    pad: [0, 1, 0, 0]
+  - op: transform
+    transforms: rotate180
+`
+	manifest, err := parseBatchManifest(text, ".", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []BatchStep{
+		{Op: "transform", Options: map[string]string{"transforms": "pad,mirror-h", "pad": "0,1,0,0"}},
+		{Op: "transform", Options: map[string]string{"transforms": "rotate180"}},
+	}
+	if !reflect.DeepEqual(manifest.Pipeline, expected) {
+		t.Errorf("got pipeline %+v, expected %+v", manifest.Pipeline, expected)
+	}
+
+	_, err = parseBatchManifest("inputs: [a.he3]\npipeline:\n  - op: export\n    format: [he3, text]\n", ".", 2)
+	if expected := "pipeline step 1 format must be a single value"; err == nil || err.Error() != expected {
+		t.Errorf("got error %v, expected %q", err, expected)
+	}
+}
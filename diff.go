@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/fogleman/gg"
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// FieldChange is a single value that differs between two maps
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type TileDiff struct {
+	X       int           `json:"x"`
+	Z       int           `json:"z"`
+	Changes []FieldChange `json:"changes"`
+}
+
+type MapDiff struct {
+	Metadata []FieldChange `json:"metadata"`
+	Tiles    []TileDiff    `json:"tiles"`
+	// Summary counts how many tiles changed for each field
+	Summary map[string]int `json:"summary"`
+}
+
+func formatHeight(height float32) string {
+	return strconv.FormatFloat(float64(height), 'g', -1, 32)
+}
+
+func formatArmy(army *fileio.Army) string {
+	if army == nil {
+		return "none"
+	}
+	return fmt.Sprintf("inf=%d art=%d morale=%s at (%d,%d)",
+		army.UnitInfantry, army.UnitArtillery, formatHeight(army.Morale), army.X, army.Y)
+}
+
+func armiesEqual(a, b *fileio.Army) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// diffTiles lists the fields that differ between two tiles at the same position
+func diffTiles(oldTile, newTile *fileio.MapTile) []FieldChange {
+	changes := []FieldChange{}
+	if oldTile.TileType != newTile.TileType {
+		changes = append(changes, FieldChange{"type", oldTile.TileType.String(), newTile.TileType.String()})
+	}
+	if oldTile.Height != newTile.Height {
+		changes = append(changes, FieldChange{"height", formatHeight(oldTile.Height), formatHeight(newTile.Height)})
+	}
+	if oldTile.HasRoad != newTile.HasRoad {
+		changes = append(changes, FieldChange{"road", strconv.FormatBool(oldTile.HasRoad), strconv.FormatBool(newTile.HasRoad)})
+	}
+	if oldTile.HasFlag != newTile.HasFlag {
+		changes = append(changes, FieldChange{"flag", strconv.FormatBool(oldTile.HasFlag), strconv.FormatBool(newTile.HasFlag)})
+	}
+	if oldTile.CityName != newTile.CityName {
+		changes = append(changes, FieldChange{"cityName", oldTile.CityName, newTile.CityName})
+	}
+	if oldTile.Party != newTile.Party {
+		changes = append(changes, FieldChange{"party", strconv.Itoa(oldTile.Party), strconv.Itoa(newTile.Party)})
+	}
+	if !armiesEqual(oldTile.Infantry, newTile.Infantry) {
+		changes = append(changes, FieldChange{"infantry", formatArmy(oldTile.Infantry), formatArmy(newTile.Infantry)})
+	}
+	if !armiesEqual(oldTile.Artillery, newTile.Artillery) {
+		changes = append(changes, FieldChange{"artillery", formatArmy(oldTile.Artillery), formatArmy(newTile.Artillery)})
+	}
+	return changes
+}
+
+func diffMetadata(oldMap, newMap *fileio.HE3Map) []FieldChange {
+	changes := []FieldChange{}
+	if oldMap.MapTitle != newMap.MapTitle {
+		changes = append(changes, FieldChange{"title", oldMap.MapTitle, newMap.MapTitle})
+	}
+	if oldMap.MapAuthor != newMap.MapAuthor {
+		changes = append(changes, FieldChange{"author", oldMap.MapAuthor, newMap.MapAuthor})
+	}
+	if oldMap.Width != newMap.Width {
+		changes = append(changes, FieldChange{"width", strconv.Itoa(int(oldMap.Width)), strconv.Itoa(int(newMap.Width))})
+	}
+	if oldMap.Depth != newMap.Depth {
+		changes = append(changes, FieldChange{"depth", strconv.Itoa(int(oldMap.Depth)), strconv.Itoa(int(newMap.Depth))})
+	}
+	if oldMap.MapStyle != newMap.MapStyle {
+		changes = append(changes, FieldChange{"style", fmt.Sprint(oldMap.MapStyle), fmt.Sprint(newMap.MapStyle)})
+	}
+	return changes
+}
+
+// diffMaps compares two maps tile by tile. If the dimensions differ, only the
+// overlapping area is compared and the size change is reported in the metadata.
+func diffMaps(oldMap, newMap *fileio.HE3Map) *MapDiff {
+	mapDiff := &MapDiff{
+		Metadata: diffMetadata(oldMap, newMap),
+		Tiles:    []TileDiff{},
+		Summary:  map[string]int{},
+	}
+
+	width := min(int(oldMap.Width), int(newMap.Width))
+	depth := min(int(oldMap.Depth), int(newMap.Depth))
+	for x := 0; x < width; x++ {
+		for z := 0; z < depth; z++ {
+			changes := diffTiles(oldMap.At(x, z), newMap.At(x, z))
+			if len(changes) == 0 {
+				continue
+			}
+			mapDiff.Tiles = append(mapDiff.Tiles, TileDiff{X: x, Z: z, Changes: changes})
+			for _, change := range changes {
+				mapDiff.Summary[change.Field]++
+			}
+		}
+	}
+	return mapDiff
+}
+
+func writeDiffText(w io.Writer, mapDiff *MapDiff) error {
+	if len(mapDiff.Metadata) == 0 && len(mapDiff.Tiles) == 0 {
+		_, err := fmt.Fprintln(w, "Maps are identical")
+		return err
+	}
+
+	if len(mapDiff.Metadata) > 0 {
+		fmt.Fprintln(w, "Metadata:")
+		for _, change := range mapDiff.Metadata {
+			fmt.Fprintf(w, "  %s: %q -> %q\n", change.Field, change.Old, change.New)
+		}
+	}
+
+	fmt.Fprintln(w, "Tiles changed:", len(mapDiff.Tiles))
+	for _, tileDiff := range mapDiff.Tiles {
+		fmt.Fprintf(w, "  (%d,%d)\n", tileDiff.X, tileDiff.Z)
+		for _, change := range tileDiff.Changes {
+			fmt.Fprintf(w, "    %s: %q -> %q\n", change.Field, change.Old, change.New)
+		}
+	}
+
+	fields := make([]string, 0, len(mapDiff.Summary))
+	for field := range mapDiff.Summary {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	fmt.Fprintln(w, "Summary:")
+	for _, field := range fields {
+		fmt.Fprintf(w, "  %s: %d\n", field, mapDiff.Summary[field])
+	}
+	return nil
+}
+
+// drawDiff renders the new map with every changed hex outlined
+func drawDiff(newMap *fileio.HE3Map, mapDiff *MapDiff, outputFilename string) error {
+	dc := renderMap(newMapData(newMap), func(dc *gg.Context) {
+		for _, tileDiff := range mapDiff.Tiles {
+			drawHexOutline(dc, tileDiff.X, tileDiff.Z, 255, 230, 0)
+		}
+	})
+	return dc.SavePNG(outputFilename)
+}
+
+func runDiff(oldFilename, newFilename, format, reportFilename, highlightFilename string) error {
+	oldMap := fileio.ReadHE3Map(oldFilename)
+	newMap := fileio.ReadHE3Map(newFilename)
+	mapDiff := diffMaps(oldMap, newMap)
+
+	err := writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, mapDiff)
+		}
+		return writeDiffText(w, mapDiff)
+	})
+	if err != nil {
+		return err
+	}
+
+	if highlightFilename != "" {
+		return drawDiff(newMap, mapDiff, highlightFilename)
+	}
+	return nil
+}
@@ -1,5 +1,10 @@
 package fileio
 
+import (
+	"errors"
+	"fmt"
+)
+
 const (
 	HLOG  = 14
 	HSIZE = 1 << HLOG
@@ -8,15 +13,21 @@ const (
 	MAX_OFF = 1 << 13
 	// The maximum back-reference length (264).
 	MAX_REF = (1 << 8) + (1 << 3)
+	// The largest output Decompress will produce (64 MB)
+	MAX_DECOMPRESSED_SIZE = 64 << 20
 )
 
 var (
-	HashTable = make([]uint64, HSIZE)
+	// ErrOutputTooSmall is returned by LzfDecompress when the output can't hold the data
+	ErrOutputTooSmall = errors.New("output is too small")
 )
 
 // This function will compress the file correctly such that the decompress will generate a map,
 // but it does not generate the same file that the game does
 func Compress(inputBytes []byte) []byte {
+	if len(inputBytes) == 0 {
+		return []byte{}
+	}
 	length := len(inputBytes) * 2
 	output := make([]byte, length)
 	count := LzfCompress(inputBytes, output)
@@ -36,27 +47,28 @@ func Compress(inputBytes []byte) []byte {
 	return dst
 }
 
-func Decompress(inputBytes []byte) []byte {
+// Decompress returns the decompressed data, or an error if the input is not valid LZF data
+// or would decompress to more than MAX_DECOMPRESSED_SIZE bytes
+func Decompress(inputBytes []byte) ([]byte, error) {
+	if len(inputBytes) == 0 {
+		return []byte{}, nil
+	}
 	length := len(inputBytes) * 2
-	output := make([]byte, length)
-	count := LzfDecompress(inputBytes, output)
-
 	for {
-		// Decompress succeeded
-		if count != 0 {
-			break
+		output := make([]byte, length)
+		count, err := LzfDecompress(inputBytes, output)
+		if err == nil {
+			return output[:count], nil
+		}
+		if err != ErrOutputTooSmall {
+			return nil, err
+		}
+		if length >= MAX_DECOMPRESSED_SIZE {
+			return nil, fmt.Errorf("decompressed data is larger than %d bytes", MAX_DECOMPRESSED_SIZE)
 		}
-
 		// If initial array is too small, double size and try again
-		length *= 2
-		output = make([]byte, length)
-		count = LzfDecompress(inputBytes, output)
+		length = min(length*2, MAX_DECOMPRESSED_SIZE)
 	}
-	dst := make([]byte, count)
-	for i := 0; i < count; i++ {
-		dst[i] = output[i]
-	}
-	return dst
 }
 
 func getHashSlot(hashValue uint64) uint64 {
@@ -68,21 +80,22 @@ func LzfCompress(input []byte, output []byte) int {
 
 	inputLength := len(input)
 	outputLength := len(output)
-	for i := 0; i < HSIZE; i++ {
-		HashTable[i] = 0
-	}
+	// Each call has its own hash table so that maps can be compressed concurrently
+	hashTable := make([]uint64, HSIZE)
 	inputIndex := 0
 	outputIndex := 0
 	lit := 0
 
-	hval = (uint64(input[inputIndex]) << 8) | uint64(input[inputIndex+1])
+	if inputLength > 1 {
+		hval = (uint64(input[inputIndex]) << 8) | uint64(input[inputIndex+1])
+	}
 
 	for {
 		if inputIndex < inputLength-2 {
 			hval = (hval << 8) | uint64(input[inputIndex+2])
 			hashSlot = getHashSlot(hval)
-			reference = HashTable[hashSlot]
-			HashTable[hashSlot] = uint64(inputIndex)
+			reference = hashTable[hashSlot]
+			hashTable[hashSlot] = uint64(inputIndex)
 			offset = uint64(inputIndex) - reference - 1
 
 			if offset < MAX_OFF &&
@@ -131,12 +144,12 @@ func LzfCompress(input []byte, output []byte) int {
 				hval = (uint64(input[inputIndex]) << 8) | uint64(input[inputIndex+1])
 				hval = (hval << 8) | uint64(input[inputIndex+2])
 				hashSlot = getHashSlot(hval)
-				HashTable[hashSlot] = uint64(inputIndex)
+				hashTable[hashSlot] = uint64(inputIndex)
 				inputIndex++
 
 				hval = (hval << 8) | uint64(input[inputIndex+2])
 				hashSlot = getHashSlot(hval)
-				HashTable[hashSlot] = uint64(inputIndex)
+				hashTable[hashSlot] = uint64(inputIndex)
 				inputIndex++
 				continue
 			}
@@ -175,55 +188,58 @@ func LzfCompress(input []byte, output []byte) int {
 	return int(outputIndex)
 }
 
-func LzfDecompress(input []byte, output []byte) int {
+// LzfDecompress decompresses input into output and returns the number of bytes written.
+// It returns ErrOutputTooSmall if output can't hold the data, and other errors if the
+// input is not valid LZF data.
+func LzfDecompress(input []byte, output []byte) (int, error) {
 	inputLength := len(input)
 	outputLength := len(output)
-	inputIndex := uint(0)
-	outputIndex := uint(0)
-	for {
-		inputByte := uint(input[inputIndex])
+	inputIndex := 0
+	outputIndex := 0
+	for inputIndex < inputLength {
+		inputByte := int(input[inputIndex])
 		inputIndex++
 		if inputByte < 32 {
-			dataLength := uint(inputByte) + 1
+			dataLength := inputByte + 1
+			if inputIndex+dataLength > inputLength {
+				return 0, fmt.Errorf("literal run of %d bytes at %d is past the end of the input", dataLength, inputIndex-1)
+			}
 			// Make sure output array bounds have enough space
-			if int64(outputIndex+dataLength) > int64(outputLength) {
-				// Not enough space
-				return 0
+			if outputIndex+dataLength > outputLength {
+				return 0, ErrOutputTooSmall
 			}
 
 			// Copy the data from input array into output array
-			for i := 0; i < int(dataLength); i++ {
-				output[int(outputIndex)+i] = input[int(inputIndex)+i]
-			}
+			copy(output[outputIndex:], input[inputIndex:inputIndex+dataLength])
 			outputIndex += dataLength
 			inputIndex += dataLength
 		} else {
-			dataLength := uint(inputByte >> 5)
-			newIndex := int(outputIndex) - ((int(inputByte) & 31) << 8) - 1
+			dataLength := inputByte >> 5
 			if dataLength == 7 {
-				dataLength += uint(input[inputIndex])
+				if inputIndex >= inputLength {
+					return 0, fmt.Errorf("back reference at %d is past the end of the input", inputIndex-1)
+				}
+				dataLength += int(input[inputIndex])
 				inputIndex++
 			}
-			reference := newIndex - int(input[inputIndex])
-			inputIndex++
-			if int64(uint(int(outputIndex)+int(dataLength)+2)) > int64(outputLength) {
-				// Array out of bounds
-				return 0
+			if inputIndex >= inputLength {
+				return 0, fmt.Errorf("back reference at %d is past the end of the input", inputIndex-1)
 			}
-
+			reference := outputIndex - ((inputByte & 31) << 8) - 1 - int(input[inputIndex])
+			inputIndex++
 			if reference < 0 {
-				return 0
+				return 0, fmt.Errorf("back reference at %d points before the start of the output", inputIndex-1)
 			}
-			// Copy data
-			for i := 0; i < int(dataLength)+2; i++ {
-				output[int(outputIndex)+i] = output[reference+i]
+			if outputIndex+dataLength+2 > outputLength {
+				return 0, ErrOutputTooSmall
+			}
+
+			// Copy data one byte at a time, because the reference may overlap the new data
+			for i := 0; i < dataLength+2; i++ {
+				output[outputIndex+i] = output[reference+i]
 			}
 			outputIndex += dataLength + 2
 		}
-		// Done processing input array
-		if int64(inputIndex) >= int64(inputLength) {
-			break
-		}
 	}
-	return int(outputIndex)
+	return outputIndex, nil
 }
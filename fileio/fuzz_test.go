@@ -0,0 +1,139 @@
+package fileio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// bundledMaps returns the contents of the maps in the repository, used as seed inputs
+func bundledMaps(t testing.TB) [][]byte {
+	filenames, err := filepath.Glob(filepath.Join("..", "maps", "*.he3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := [][]byte{}
+	for _, filename := range filenames {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, content)
+	}
+	return contents
+}
+
+func FuzzDecompress(f *testing.F) {
+	for _, content := range bundledMaps(f) {
+		compressed, err := base64.StdEncoding.DecodeString(string(content))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(compressed)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0x1f})
+	f.Add([]byte{0xe0})
+	f.Add([]byte{0x00, 0x41, 0x20, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Decompress(data)
+	})
+}
+
+func FuzzCompressRoundTrip(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{1})
+	f.Add([]byte("hexmap hexmap hexmap hexmap"))
+	f.Add(bytes.Repeat([]byte{0, 1, 2}, 200))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decompressed, err := Decompress(Compress(data))
+		if err != nil {
+			t.Fatalf("failed to decompress compressed data: %v", err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Fatalf("round trip changed %d bytes into %d bytes", len(data), len(decompressed))
+		}
+	})
+}
+
+// FuzzDeserialize checks that any upload either fails with an error or reads as a map
+// that can be saved and read again
+func FuzzDeserialize(f *testing.F) {
+	for _, content := range bundledMaps(f) {
+		f.Add(content)
+	}
+	f.Add([]byte(""))
+	f.Add([]byte("aGV4bWFw"))
+	f.Fuzz(func(t *testing.T, content []byte) {
+		mapData, err := Deserialize(content)
+		if err != nil {
+			return
+		}
+		saved, err := Serialize(mapData)
+		if err != nil {
+			t.Fatalf("failed to save map: %v", err)
+		}
+		reread, err := Deserialize([]byte(saved))
+		if err != nil {
+			t.Fatalf("failed to read saved map: %v", err)
+		}
+		if savedAgain, err := Serialize(reread); err != nil || savedAgain != saved {
+			t.Fatal("saved map changed after reading it again")
+		}
+	})
+}
+
+// FuzzDeserializeData skips the base64 and LZF layers so that the fuzzer reaches the
+// tile decoding sooner
+func FuzzDeserializeData(f *testing.F) {
+	for _, content := range bundledMaps(f) {
+		mapData, err := Deserialize(content)
+		if err != nil {
+			f.Fatal(err)
+		}
+		cropped, err := Crop(mapData, 0, 0, 3, 2)
+		if err != nil {
+			f.Fatal(err)
+		}
+		data, err := SerializeData(cropped)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		DeserializeData(data)
+	})
+}
+
+func FuzzReadReplay(f *testing.F) {
+	for _, content := range bundledMaps(f) {
+		mapData, err := Deserialize(content)
+		if err != nil {
+			f.Fatal(err)
+		}
+		initial, err := Crop(mapData, 0, 0, 4, 4)
+		if err != nil {
+			f.Fatal(err)
+		}
+		recorder := NewReplayRecorder(initial)
+		changed := initial.Clone()
+		changed.MapTiles[1][1].Party = 2
+		if err := recorder.Record("frame", changed); err != nil {
+			f.Fatal(err)
+		}
+		buffer := new(bytes.Buffer)
+		if err := WriteReplay(buffer, recorder.Replay()); err != nil {
+			f.Fatal(err)
+		}
+		f.Add(buffer.Bytes())
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		replay, err := ReadReplay(bytes.NewReader(data))
+		if err == nil {
+			replay.States()
+		}
+	})
+}
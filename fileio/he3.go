@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"strings"
 )
 
 type MapStyle struct {
@@ -34,12 +36,42 @@ const (
 
 const (
 	ELEVATION_MOUNTAIN = 0.6
+	// Map version written by Serialize
+	MAP_VERSION = 7
+	// The largest width or depth that can be loaded and saved
+	MAX_MAP_DIMENSION = 1024
+	// Strings are saved with a one byte length
+	MAX_STRING_LENGTH = 255
+	// Number of parties in the game. Owned tiles have a party from 0 to MAX_PARTIES - 1.
+	MAX_PARTIES = 6
+	// Every tile takes at least this many bytes: height, flags, party and two army flags
+	MIN_TILE_SIZE = 4 + 1 + 4 + 1 + 1
 )
 
 var (
 	SERIALIZATION_TYPE_CONV = [10]int{0, 1, 2, 3, 4, 9, 5, 6, 7, 8}
+	FIELD_TYPE_NAMES        = [10]string{"Grass", "Sand", "Farmland", "Forest", "Snow", "Airport", "Factory", "Town", "City", "Capital"}
+	// Map style used by the bundled maps
+	DEFAULT_MAP_STYLE = MapStyle{Grass: 141, Mountains: 3, Desert: 196, Sea: 71, Light: 37}
 )
 
+func (fieldType FieldType) String() string {
+	if int(fieldType) < len(FIELD_TYPE_NAMES) {
+		return FIELD_TYPE_NAMES[fieldType]
+	}
+	return "Unknown"
+}
+
+// ParseFieldType returns the tile type with the given name, ignoring case
+func ParseFieldType(name string) (FieldType, bool) {
+	for i := 0; i < len(FIELD_TYPE_NAMES); i++ {
+		if strings.EqualFold(FIELD_TYPE_NAMES[i], name) {
+			return FieldType(i), true
+		}
+	}
+	return Grass, false
+}
+
 type MapTile struct {
 	Height       float32
 	IsSea        bool
@@ -53,6 +85,11 @@ type MapTile struct {
 	HasArtillery bool
 	Infantry     *Army
 	Artillery    *Army
+	// Bytes read from the file that the fields above can't hold, which are written back
+	// unchanged: a type code that isn't a known type, and army flags other than 0 and 1
+	rawTypeCode      byte
+	rawInfantryFlag  byte
+	rawArtilleryFlag byte
 }
 
 type Army struct {
@@ -63,13 +100,86 @@ type Army struct {
 	Morale        float32
 }
 
+// SetHeight changes the tile height and updates the sea and mountain flags that depend on it
+func (mapTile *MapTile) SetHeight(height float32) {
+	mapTile.Height = height
+	mapTile.IsSea = height <= 0.0
+	mapTile.IsMountain = height >= ELEVATION_MOUNTAIN
+}
+
+// Clone returns a deep copy of the tile, including its armies
+func (mapTile *MapTile) Clone() *MapTile {
+	clone := *mapTile
+	if mapTile.Infantry != nil {
+		infantry := *mapTile.Infantry
+		clone.Infantry = &infantry
+	}
+	if mapTile.Artillery != nil {
+		artillery := *mapTile.Artillery
+		clone.Artillery = &artillery
+	}
+	return &clone
+}
+
 type HE3Map struct {
+	// MapTiles holds the tiles as [x][z]. It is nil for compact maps, so code that can get
+	// either kind of map should use At and Set.
 	MapTiles  [][]*MapTile
 	MapTitle  string
 	MapAuthor string
 	MapStyle  MapStyle
 	Width     int32
 	Depth     int32
+	// Version of the file the map was read from. Serialize writes the map in the same
+	// version, or in MAP_VERSION if this is 0.
+	Version int32
+	// GameState is the flag after the tiles, and Trailing holds the bytes after it, such
+	// as a saved game. Both are written back unchanged.
+	GameState byte
+	Trailing  []byte
+	// tiles holds the tiles of a compact map, indexed by x*Depth+z
+	tiles []MapTile
+}
+
+// NewHE3Map creates a map filled with neutral grass tiles at height 0, which is sea
+func NewHE3Map(width int32, depth int32) *HE3Map {
+	tileMap := make([][]*MapTile, int(width))
+	for x := 0; x < int(width); x++ {
+		tileMap[x] = make([]*MapTile, int(depth))
+		for z := 0; z < int(depth); z++ {
+			tile := &MapTile{Party: -1}
+			tile.SetHeight(0)
+			tileMap[x][z] = tile
+		}
+	}
+	return &HE3Map{
+		MapTiles: tileMap,
+		MapStyle: DEFAULT_MAP_STYLE,
+		Width:    width,
+		Depth:    depth,
+		Version:  MAP_VERSION,
+	}
+}
+
+// Clone returns a deep copy of the map
+func (mapData *HE3Map) Clone() *HE3Map {
+	clone := *mapData
+	if mapData.Trailing != nil {
+		clone.Trailing = append([]byte{}, mapData.Trailing...)
+	}
+	if mapData.tiles != nil {
+		clone.tiles = append([]MapTile{}, mapData.tiles...)
+		packArmies(clone.tiles)
+		return &clone
+	}
+	clone.MapTiles = make([][]*MapTile, len(mapData.MapTiles))
+	for x := range mapData.MapTiles {
+		clone.MapTiles[x] = make([]*MapTile, len(mapData.MapTiles[x]))
+		for z := range mapData.MapTiles[x] {
+			clone.MapTiles[x][z] = mapData.MapTiles[x][z].Clone()
+		}
+	}
+	return &clone
 }
 
 func readString(streamReader *io.SectionReader) (string, error) {
@@ -84,11 +194,20 @@ func readString(streamReader *io.SectionReader) (string, error) {
 	return string(byteData), nil
 }
 
-func writeString(buffer *bytes.Buffer, str string) {
+func currentOffset(streamReader *io.SectionReader) int64 {
+	offset, _ := streamReader.Seek(0, io.SeekCurrent)
+	return offset
+}
+
+func writeString(buffer *bytes.Buffer, str string) error {
 	// Need to append string length to the file
 	// Strings can't be over 255 bytes
+	if len(str) > MAX_STRING_LENGTH {
+		return fmt.Errorf("string is %d bytes, longer than %d", len(str), MAX_STRING_LENGTH)
+	}
 	buffer.WriteByte(byte(len(str)))
 	buffer.WriteString(str)
+	return nil
 }
 
 func writeFloat32(buffer *bytes.Buffer, f float32) {
@@ -105,63 +224,111 @@ func writeInteger(buffer *bytes.Buffer, num int32) {
 	}
 }
 
-func serializeArmy(buffer *bytes.Buffer, army *Army) {
+func serializeArmy(buffer *bytes.Buffer, army *Army, version int) {
 	writeInteger(buffer, army.X)
 	writeInteger(buffer, army.Y)
 	writeInteger(buffer, army.UnitInfantry)
-	writeInteger(buffer, army.UnitArtillery)
+	if version > 1 {
+		writeInteger(buffer, army.UnitArtillery)
+	}
 	writeFloat32(buffer, army.Morale)
 }
 
-func Serialize(mapData *HE3Map) string {
+// serializeTile writes the tile in the given map version, the reverse of deserializeTile
+func serializeTile(buffer *bytes.Buffer, field *MapTile, version int) error {
+	if int(field.TileType) >= len(SERIALIZATION_TYPE_CONV) {
+		return fmt.Errorf("unknown tile type %d", field.TileType)
+	}
+	writeFloat32(buffer, field.Height)
+	flags := byte(SERIALIZATION_TYPE_CONV[int(field.TileType)])
+	if field.TileType == Grass && field.rawTypeCode != 0 {
+		flags = field.rawTypeCode
+	}
+	if field.HasRoad {
+		flags += 64
+	}
+	if field.HasFlag {
+		flags += 128
+	}
+	buffer.WriteByte(flags)
+	if field.TileType >= Airport {
+		if err := writeString(buffer, field.CityName); err != nil {
+			return fmt.Errorf("city name: %w", err)
+		}
+	}
+	writeInteger(buffer, int32(field.Party))
+	if field.Infantry != nil {
+		buffer.WriteByte(1) // true
+		serializeArmy(buffer, field.Infantry, version)
+	} else {
+		buffer.WriteByte(field.rawInfantryFlag) // false
+	}
+	if version < 3 {
+		// Older versions only have a flag for artillery
+		if field.HasArtillery || field.Artillery != nil {
+			buffer.WriteByte(1) // true
+		} else {
+			buffer.WriteByte(field.rawArtilleryFlag) // false
+		}
+	} else if field.Artillery != nil {
+		buffer.WriteByte(1) // true
+		serializeArmy(buffer, field.Artillery, version)
+	} else {
+		buffer.WriteByte(field.rawArtilleryFlag) // false
+	}
+	return nil
+}
+
+// Serialize returns the map as it is saved in .he3 files
+func Serialize(mapData *HE3Map) (string, error) {
+	data, err := SerializeData(mapData)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(Compress(data)), nil
+}
+
+// SerializeData returns the map data before compression. It fails if a tile has a type
+// that can't be saved, or if a string is longer than MAX_STRING_LENGTH.
+func SerializeData(mapData *HE3Map) ([]byte, error) {
+	if mapData.Width <= 0 || mapData.Depth <= 0 || mapData.Width > MAX_MAP_DIMENSION || mapData.Depth > MAX_MAP_DIMENSION {
+		return nil, fmt.Errorf("invalid map size %dx%d, width and depth must be between 1 and %d", mapData.Width, mapData.Depth, MAX_MAP_DIMENSION)
+	}
+	version := mapData.Version
+	if version == 0 {
+		version = MAP_VERSION
+	}
 	buffer := new(bytes.Buffer)
 	writeString(buffer, "hexmap")
-	writeInteger(buffer, int32(7))
-	writeString(buffer, mapData.MapTitle)
-	writeString(buffer, mapData.MapAuthor)
+	writeInteger(buffer, version)
+	if err := writeString(buffer, mapData.MapTitle); err != nil {
+		return nil, fmt.Errorf("title: %w", err)
+	}
+	if err := writeString(buffer, mapData.MapAuthor); err != nil {
+		return nil, fmt.Errorf("author: %w", err)
+	}
 	writeInteger(buffer, mapData.Width)
 	writeInteger(buffer, mapData.Depth)
-	buffer.WriteByte(mapData.MapStyle.Grass)
-	buffer.WriteByte(mapData.MapStyle.Mountains)
-	buffer.WriteByte(mapData.MapStyle.Desert)
-	buffer.WriteByte(mapData.MapStyle.Sea)
-	buffer.WriteByte(mapData.MapStyle.Light)
+	if version >= 5 {
+		buffer.WriteByte(mapData.MapStyle.Grass)
+		buffer.WriteByte(mapData.MapStyle.Mountains)
+		buffer.WriteByte(mapData.MapStyle.Desert)
+		buffer.WriteByte(mapData.MapStyle.Sea)
+		buffer.WriteByte(mapData.MapStyle.Light)
+	}
 	for x := 0; x < int(mapData.Width); x++ {
 		for y := 0; y < int(mapData.Depth); y++ {
-			field := mapData.MapTiles[x][y]
-			writeFloat32(buffer, field.Height)
-			flags := byte(SERIALIZATION_TYPE_CONV[int(field.TileType)])
-			if field.HasRoad {
-				flags += 64
-			}
-			if field.HasFlag {
-				flags += 128
-			}
-			buffer.WriteByte(flags)
-			if field.TileType >= Airport {
-				writeString(buffer, field.CityName)
-			}
-			writeInteger(buffer, int32(field.Party))
-			if field.Infantry != nil {
-				buffer.WriteByte(1) // true
-				serializeArmy(buffer, field.Infantry)
-			} else {
-				buffer.WriteByte(0) // false
-			}
-			if field.Artillery != nil {
-				buffer.WriteByte(1) // true
-				serializeArmy(buffer, field.Artillery)
-			} else {
-				buffer.WriteByte(0) // false
+			if err := serializeTile(buffer, mapData.At(x, y), int(version)); err != nil {
+				return nil, fmt.Errorf("tile (%d,%d): %w", x, y, err)
 			}
 		}
 	}
 
 	// Game state
-	buffer.WriteByte(0) // false
+	buffer.WriteByte(mapData.GameState)
+	buffer.Write(mapData.Trailing)
 
-	compressed := Compress(buffer.Bytes())
-	return base64.StdEncoding.EncodeToString(compressed)
+	return buffer.Bytes(), nil
 }
 
 func DeserializeArmy(
@@ -170,32 +337,32 @@ func DeserializeArmy(
 	gameState bool,
 	version int,
 	thumb bool,
-) *Army {
+) (*Army, error) {
 	x := int32(0)
 	if err := binary.Read(streamReader, binary.LittleEndian, &x); err != nil {
-		log.Fatal("Error reading x: ", err)
+		return nil, fmt.Errorf("error reading x: %w", err)
 	}
 
 	y := int32(0)
 	if err := binary.Read(streamReader, binary.LittleEndian, &y); err != nil {
-		log.Fatal("Error reading y: ", err)
+		return nil, fmt.Errorf("error reading y: %w", err)
 	}
 
 	unitInfantry := int32(0)
 	if err := binary.Read(streamReader, binary.LittleEndian, &unitInfantry); err != nil {
-		log.Fatal("Error reading unitInfantry: ", err)
+		return nil, fmt.Errorf("error reading unitInfantry: %w", err)
 	}
 
 	unitArtillery := int32(0)
 	if version > 1 {
 		if err := binary.Read(streamReader, binary.LittleEndian, &unitArtillery); err != nil {
-			log.Fatal("Error reading unitArtillery: ", err)
+			return nil, fmt.Errorf("error reading unitArtillery: %w", err)
 		}
 	}
 
 	morale := float32(0)
 	if err := binary.Read(streamReader, binary.LittleEndian, &morale); err != nil {
-		log.Fatal("Error reading morale: ", err)
+		return nil, fmt.Errorf("error reading morale: %w", err)
 	}
 
 	return &Army{
@@ -204,173 +371,262 @@ func DeserializeArmy(
 		UnitInfantry:  unitInfantry,
 		UnitArtillery: unitArtillery,
 		Morale:        morale,
+	}, nil
+}
+
+func deserializeTile(streamReader *io.SectionReader, version int, thumb bool) (*MapTile, error) {
+	tile := &MapTile{}
+	if err := readTile(streamReader, tile, version, thumb); err != nil {
+		return nil, err
 	}
+	return tile, nil
 }
 
-func Deserialize(content []byte) *HE3Map {
+// readTile reads the next tile into an existing tile, so that scans can reuse one tile
+func readTile(streamReader *io.SectionReader, tile *MapTile, version int, thumb bool) error {
+	*tile = MapTile{}
+
+	height := float32(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &height); err != nil {
+		return fmt.Errorf("error reading height: %w", err)
+	}
+	tile.SetHeight(height)
+
+	num := byte(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &num); err != nil {
+		return fmt.Errorf("error reading num: %w", err)
+	}
+	tile.HasRoad = false
+	if (int(num) & 64) == 64 {
+		tile.HasRoad = true
+		num -= byte(64)
+	}
+	tile.HasFlag = false
+	if (int(num) & 128) == 128 {
+		tile.HasFlag = true
+		num -= byte(128)
+	}
+	tile.TileType = Grass
+	tile.rawTypeCode = num
+	for i := 0; i < len(SERIALIZATION_TYPE_CONV); i++ {
+		if SERIALIZATION_TYPE_CONV[i] == int(num) {
+			tile.TileType = FieldType(i)
+			tile.rawTypeCode = 0
+		}
+	}
+	if tile.TileType >= Airport {
+		cityName, err := readString(streamReader)
+		tile.CityName = cityName
+		if err != nil {
+			return fmt.Errorf("error reading city name: %w", err)
+		}
+	}
+	party := int32(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &party); err != nil {
+		return fmt.Errorf("error reading party: %w", err)
+	}
+	tile.Party = int(party)
+
+	if tile.HasFlag {
+		// TODO: set party flag
+	}
+	boolArmy := byte(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &boolArmy); err != nil {
+		return fmt.Errorf("error reading boolArmy: %w", err)
+	}
+	if boolArmy == 1 {
+		army, err := DeserializeArmy(streamReader, int(party), false, version, thumb)
+		if err != nil {
+			return err
+		}
+		tile.HasInfantry = true
+		tile.Infantry = army
+	} else {
+		tile.HasInfantry = false
+		tile.Infantry = nil
+		tile.rawInfantryFlag = boolArmy
+	}
+
+	boolArtillery := byte(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &boolArtillery); err != nil {
+		return fmt.Errorf("error reading boolArtillery: %w", err)
+	}
+	if boolArtillery == 1 {
+		tile.HasArtillery = true
+	} else {
+		tile.HasArtillery = false
+		tile.rawArtilleryFlag = boolArtillery
+	}
+	if version >= 3 && boolArtillery == 1 {
+		artillery, err := DeserializeArmy(streamReader, int(party), false, version, thumb)
+		if err != nil {
+			return err
+		}
+		tile.Artillery = artillery
+	} else {
+		tile.Artillery = nil
+	}
+
+	return nil
+}
+
+func Deserialize(content []byte) (*HE3Map, error) {
+	inputData, err := DecodeData(content)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeData(inputData)
+}
+
+// DecodeData decodes the base64 text of a .he3 file and decompresses it
+func DecodeData(content []byte) ([]byte, error) {
 	rawDecodedText, err := base64.StdEncoding.DecodeString(string(content))
 	if err != nil {
-		log.Fatal("Failed to decode string: ", err)
+		return nil, fmt.Errorf("failed to decode string: %w", err)
 	}
-	inputData := Decompress(rawDecodedText)
+	inputData, err := Decompress(rawDecodedText)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+	return inputData, nil
+}
+
+// DeserializeData reads the map data after decompression
+func DeserializeData(inputData []byte) (*HE3Map, error) {
+	return deserializeData(inputData, false)
+}
 
+func deserializeData(inputData []byte, compact bool) (*HE3Map, error) {
 	streamReader := io.NewSectionReader(bytes.NewReader(inputData), int64(0), int64(len(inputData)))
+	mapData, err := deserializeHeader(streamReader)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: Figure out correct value
+	thumb := false
+
+	if compact {
+		tiles := make([]MapTile, int(mapData.Width)*int(mapData.Depth))
+		for i := range tiles {
+			if err := readTile(streamReader, &tiles[i], int(mapData.Version), thumb); err != nil {
+				return nil, err
+			}
+		}
+		packArmies(tiles)
+		mapData.tiles = tiles
+	} else {
+		tileMap := make([][]*MapTile, int(mapData.Width))
+		for x := 0; x < int(mapData.Width); x++ {
+			tileMap[x] = make([]*MapTile, int(mapData.Depth))
+			for z := 0; z < int(mapData.Depth); z++ {
+				tile, err := deserializeTile(streamReader, int(mapData.Version), thumb)
+				if err != nil {
+					return nil, err
+				}
+				tileMap[x][z] = tile
+			}
+		}
+		mapData.MapTiles = tileMap
+	}
 
+	boolGameState := byte(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &boolGameState); err != nil {
+		return nil, fmt.Errorf("error reading boolGameState: %w", err)
+	}
+	trailing, err := io.ReadAll(streamReader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading game state: %w", err)
+	}
+	mapData.GameState = boolGameState
+	mapData.Trailing = trailing
+	return mapData, nil
+}
+
+// deserializeHeader reads everything before the tiles and returns a map without tiles
+func deserializeHeader(streamReader *io.SectionReader) (*HE3Map, error) {
 	version1, err := readString(streamReader)
-	if err != nil || version1 != "hexmap" {
-		log.Fatal("The header string "+version1+" is the wrong string", err)
+	if err != nil {
+		return nil, fmt.Errorf("error reading header string: %w", err)
+	}
+	if version1 != "hexmap" {
+		return nil, fmt.Errorf("the header string %q is the wrong string", version1)
 	}
 
 	version2 := int32(0)
 	if err := binary.Read(streamReader, binary.LittleEndian, &version2); err != nil {
-		log.Fatal("Error reading map version2: ", err)
+		return nil, fmt.Errorf("error reading map version2: %w", err)
 	}
 
 	mapTitle, err := readString(streamReader)
 	if err != nil {
-		log.Fatal("Error reading map title: ", err)
+		return nil, fmt.Errorf("error reading map title: %w", err)
 	}
 
 	mapAuthor, err := readString(streamReader)
 	if err != nil {
-		log.Fatal("Error reading map author: ", err)
+		return nil, fmt.Errorf("error reading map author: %w", err)
 	}
 
 	width := int32(0)
 	if err := binary.Read(streamReader, binary.LittleEndian, &width); err != nil {
-		log.Fatal("Error reading width: ", err)
+		return nil, fmt.Errorf("error reading width: %w", err)
 	}
 
 	depth := int32(0)
 	if err := binary.Read(streamReader, binary.LittleEndian, &depth); err != nil {
-		log.Fatal("Error reading depth: ", err)
+		return nil, fmt.Errorf("error reading depth: %w", err)
+	}
+
+	if width <= 0 || depth <= 0 || width > MAX_MAP_DIMENSION || depth > MAX_MAP_DIMENSION {
+		return nil, fmt.Errorf("invalid map size %dx%d, width and depth must be between 1 and %d", width, depth, MAX_MAP_DIMENSION)
+	}
+	// Check the size against the data before allocating the tiles
+	remaining := streamReader.Size() - currentOffset(streamReader)
+	if int64(width)*int64(depth)*MIN_TILE_SIZE > remaining {
+		return nil, fmt.Errorf("map size %dx%d needs more data than the %d bytes left", width, depth, remaining)
 	}
 
 	style := MapStyle{}
 	if version2 >= 5 {
 		if err := binary.Read(streamReader, binary.LittleEndian, &style); err != nil {
-			log.Fatal("Error reading style: ", err)
+			return nil, fmt.Errorf("error reading style: %w", err)
 		}
 	}
 
-	// TODO: Figure out correct value
-	thumb := false
-
-	tileMap := make([][]*MapTile, int(width))
-	for x := 0; x < int(width); x++ {
-		tileMap[x] = make([]*MapTile, int(depth))
-		for z := 0; z < int(depth); z++ {
-			tile := MapTile{}
-
-			height := float32(0)
-			if err := binary.Read(streamReader, binary.LittleEndian, &height); err != nil {
-				log.Fatal("Error reading height: ", err)
-			}
-			tile.Height = height
-			if tile.Height <= 0.0 {
-				tile.IsSea = true
-			} else {
-				tile.IsSea = false
-			}
-			if tile.Height >= ELEVATION_MOUNTAIN {
-				tile.IsMountain = true
-			} else {
-				tile.IsMountain = false
-			}
-
-			num := byte(0)
-			if err := binary.Read(streamReader, binary.LittleEndian, &num); err != nil {
-				log.Fatal("Error reading num: ", err)
-			}
-			tile.HasRoad = false
-			if (int(num) & 64) == 64 {
-				tile.HasRoad = true
-				num -= byte(64)
-			}
-			tile.HasFlag = false
-			if (int(num) & 128) == 128 {
-				tile.HasFlag = true
-				num -= byte(128)
-			}
-			tile.TileType = Grass
-			for i := 0; i < len(SERIALIZATION_TYPE_CONV); i++ {
-				if SERIALIZATION_TYPE_CONV[i] == int(num) {
-					tile.TileType = FieldType(i)
-				}
-			}
-			if tile.TileType >= Airport {
-				cityName, err := readString(streamReader)
-				tile.CityName = cityName
-				if err != nil {
-					log.Fatal("Error reading city name: ", err)
-				}
-			}
-			party := int32(0)
-			if err := binary.Read(streamReader, binary.LittleEndian, &party); err != nil {
-				log.Fatal("Error reading party: ", err)
-			}
-			tile.Party = int(party)
-
-			if tile.HasFlag {
-				// TODO: set party flag
-			}
-			boolArmy := byte(0)
-			if err := binary.Read(streamReader, binary.LittleEndian, &boolArmy); err != nil {
-				log.Fatal("Error reading boolArmy: ", err)
-			}
-			if boolArmy == 1 {
-				army := DeserializeArmy(streamReader, int(party), false, int(version2), thumb)
-				tile.HasInfantry = true
-				tile.Infantry = army
-			} else {
-				tile.HasInfantry = false
-				tile.Infantry = nil
-			}
-
-			boolArtillery := byte(0)
-			if err := binary.Read(streamReader, binary.LittleEndian, &boolArtillery); err != nil {
-				log.Fatal("Error reading boolArtillery: ", err)
-			}
-			if boolArtillery == 1 {
-				tile.HasArtillery = true
-			} else {
-				tile.HasArtillery = false
-			}
-			if version2 >= 3 && boolArtillery == 1 {
-				artillery := DeserializeArmy(streamReader, int(party), false, int(version2), thumb)
-				tile.Artillery = artillery
-			} else {
-				tile.Artillery = nil
-			}
-
-			tileMap[x][z] = &tile
-		}
-	}
-
-	boolGameState := byte(0)
-	if err := binary.Read(streamReader, binary.LittleEndian, &boolGameState); err != nil {
-		log.Fatal("Error reading boolGameState: ", err)
-	}
-
 	return &HE3Map{
-		MapTiles:  tileMap,
 		MapTitle:  mapTitle,
 		MapAuthor: mapAuthor,
 		MapStyle:  style,
 		Width:     width,
 		Depth:     depth,
-	}
+		Version:   version2,
+	}, nil
 }
 
 func ReadHE3File(filename string) [][]*MapTile {
+	return ReadHE3Map(filename).MapTiles
+}
+
+func ReadHE3Map(filename string) *HE3Map {
 	content, err := ioutil.ReadFile(filename)
 	if err != nil {
 		log.Fatal("Failed to load map: ", err)
 	}
 
-	mapData := Deserialize(content)
+	mapData, err := Deserialize(content)
+	if err != nil {
+		log.Fatal("Failed to read map: ", err)
+	}
+	return mapData
+}
 
-	return mapData.MapTiles
+func WriteHE3File(filename string, mapData *HE3Map) error {
+	content, err := Serialize(mapData)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, []byte(content), 0644)
 }
 
 func DecompressHE3File(filename string) []byte {
@@ -382,5 +638,9 @@ func DecompressHE3File(filename string) []byte {
 	if err != nil {
 		log.Fatal("Failed to decode string: ", err)
 	}
-	return Decompress(rawDecodedText)
+	decompressed, err := Decompress(rawDecodedText)
+	if err != nil {
+		log.Fatal("Failed to decompress map: ", err)
+	}
+	return decompressed
 }
@@ -0,0 +1,116 @@
+package fileio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSerializeRejectsUnknownTileType(t *testing.T) {
+	mapData := NewHE3Map(2, 2)
+	mapData.At(1, 0).TileType = FieldType(len(SERIALIZATION_TYPE_CONV))
+
+	if _, err := SerializeData(mapData); err == nil || err.Error() != "tile (1,0): unknown tile type 10" {
+		t.Errorf("got error %v from SerializeData", err)
+	}
+	if _, err := Serialize(mapData); err == nil {
+		t.Error("expected an error from Serialize")
+	}
+
+	recorder := NewReplayRecorder(NewHE3Map(2, 2))
+	if err := recorder.Record("broken", mapData); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteReplay(&bytes.Buffer{}, recorder.Replay()); err == nil {
+		t.Error("expected an error from WriteReplay")
+	}
+}
+
+func TestSerializeRejectsInvalidSize(t *testing.T) {
+	for _, size := range [][2]int32{{MAX_MAP_DIMENSION + 1, 1}, {1, MAX_MAP_DIMENSION + 1}, {0, 1}} {
+		if _, err := SerializeData(NewHE3Map(size[0], size[1])); err == nil {
+			t.Errorf("expected an error for a %dx%d map", size[0], size[1])
+		}
+	}
+	if _, err := SerializeData(NewHE3Map(MAX_MAP_DIMENSION, 1)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSerializeRejectsLongStrings(t *testing.T) {
+	long := strings.Repeat("a", MAX_STRING_LENGTH+1)
+	tests := []struct {
+		name  string
+		edit  func(mapData *HE3Map)
+		error string
+	}{
+		{"title", func(mapData *HE3Map) { mapData.MapTitle = long }, "title: string is 256 bytes, longer than 255"},
+		{"author", func(mapData *HE3Map) { mapData.MapAuthor = long }, "author: string is 256 bytes, longer than 255"},
+		{"city name", func(mapData *HE3Map) {
+			mapData.Set(1, 0, MapTile{TileType: City, CityName: long, Party: -1})
+		}, "tile (1,0): city name: string is 256 bytes, longer than 255"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mapData := NewHE3Map(2, 2)
+			test.edit(mapData)
+			if _, err := SerializeData(mapData); err == nil || err.Error() != test.error {
+				t.Errorf("got error %v, expected %q", err, test.error)
+			}
+		})
+	}
+
+	mapData := NewHE3Map(2, 2)
+	mapData.Set(1, 0, MapTile{TileType: City, CityName: long[:MAX_STRING_LENGTH], Party: -1})
+	data, err := SerializeData(mapData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := DeserializeData(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := saved.At(1, 0).CityName; name != long[:MAX_STRING_LENGTH] {
+		t.Errorf("got a %d byte city name back, expected %d bytes", len(name), MAX_STRING_LENGTH)
+	}
+}
+
+func TestSerializeKeepsUnknownTileBytes(t *testing.T) {
+	data, err := SerializeData(NewHE3Map(1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tile starts after the header: name, version, empty title and author, size and style
+	tileOffset := 7 + 4 + 1 + 1 + 4 + 4 + 5
+	flagsOffset := tileOffset + 4
+	armyOffset := flagsOffset + 1 + 4
+	tests := []struct {
+		name   string
+		offset int
+		value  byte
+	}{
+		{"unknown type code", flagsOffset, 33},
+		{"unknown type code with road and capital", flagsOffset, 33 | 64 | 128},
+		{"infantry flag", armyOffset, 2},
+		{"artillery flag", armyOffset + 1, 255},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := append([]byte{}, data...)
+			original[test.offset] = test.value
+			for _, compact := range []bool{false, true} {
+				mapData, err := deserializeData(original, compact)
+				if err != nil {
+					t.Fatal(err)
+				}
+				saved, err := SerializeData(mapData)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(original, saved) {
+					t.Errorf("compact=%v: saved %v, expected %v", compact, saved, original)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package fileio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A replay file is LZF compressed and contains:
+//
+//	"hexreplay" string, version int32
+//	length int32 and the uncompressed data of the first map
+//	frame count int32, then for each frame:
+//	  label string, title string, author string, style (5 bytes),
+//	  tile count int32, then x int32, z int32 and the tile data of each changed tile
+//
+// Tiles use the same encoding as in .he3 files.
+const (
+	REPLAY_HEADER  = "hexreplay"
+	REPLAY_VERSION = 1
+)
+
+type ReplayTile struct {
+	X    int
+	Z    int
+	Tile *MapTile
+}
+
+// ReplayFrame holds the map metadata and the tiles that changed since the previous frame
+type ReplayFrame struct {
+	Label     string
+	MapTitle  string
+	MapAuthor string
+	MapStyle  MapStyle
+	Tiles     []ReplayTile
+}
+
+type Replay struct {
+	Initial *HE3Map
+	Frames  []ReplayFrame
+}
+
+// ReplayRecorder builds a replay from a sequence of map states, only keeping the tiles that change
+type ReplayRecorder struct {
+	replay *Replay
+	last   *HE3Map
+}
+
+func NewReplayRecorder(initial *HE3Map) *ReplayRecorder {
+	return &ReplayRecorder{
+		replay: &Replay{Initial: initial.Clone()},
+		last:   initial.Clone(),
+	}
+}
+
+// Record adds a frame for the state. The state must have the same size as the first map.
+func (recorder *ReplayRecorder) Record(label string, state *HE3Map) error {
+	if state.Width != recorder.last.Width || state.Depth != recorder.last.Depth {
+		return fmt.Errorf("frame %q is %dx%d, but the replay is %dx%d", label,
+			state.Width, state.Depth, recorder.last.Width, recorder.last.Depth)
+	}
+	frame := ReplayFrame{
+		Label:     label,
+		MapTitle:  state.MapTitle,
+		MapAuthor: state.MapAuthor,
+		MapStyle:  state.MapStyle,
+	}
+	for x := 0; x < int(state.Width); x++ {
+		for z := 0; z < int(state.Depth); z++ {
+			if tilesEqual(recorder.last.At(x, z), state.At(x, z)) {
+				continue
+			}
+			tile := state.At(x, z).Clone()
+			frame.Tiles = append(frame.Tiles, ReplayTile{X: x, Z: z, Tile: tile})
+			recorder.last.Set(x, z, *tile.Clone())
+		}
+	}
+	recorder.replay.Frames = append(recorder.replay.Frames, frame)
+	return nil
+}
+
+func (recorder *ReplayRecorder) Replay() *Replay {
+	return recorder.replay
+}
+
+// tilesEqual compares tiles by their saved data. Tiles that can't be saved are never equal,
+// so that WriteReplay reports them.
+func tilesEqual(a *MapTile, b *MapTile) bool {
+	bufferA := new(bytes.Buffer)
+	bufferB := new(bytes.Buffer)
+	if serializeTile(bufferA, a, MAP_VERSION) != nil || serializeTile(bufferB, b, MAP_VERSION) != nil {
+		return false
+	}
+	return bytes.Equal(bufferA.Bytes(), bufferB.Bytes())
+}
+
+// States returns the first map followed by the map after each frame
+func (replay *Replay) States() []*HE3Map {
+	states := make([]*HE3Map, 0, len(replay.Frames)+1)
+	state := replay.Initial.Clone()
+	states = append(states, state)
+	for _, frame := range replay.Frames {
+		state = state.Clone()
+		state.MapTitle = frame.MapTitle
+		state.MapAuthor = frame.MapAuthor
+		state.MapStyle = frame.MapStyle
+		for _, replayTile := range frame.Tiles {
+			state.Set(replayTile.X, replayTile.Z, *replayTile.Tile.Clone())
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+func WriteReplay(w io.Writer, replay *Replay) error {
+	buffer := new(bytes.Buffer)
+	writeString(buffer, REPLAY_HEADER)
+	writeInteger(buffer, REPLAY_VERSION)
+	initialData, err := SerializeData(replay.Initial)
+	if err != nil {
+		return err
+	}
+	writeInteger(buffer, int32(len(initialData)))
+	buffer.Write(initialData)
+	writeInteger(buffer, int32(len(replay.Frames)))
+	for _, frame := range replay.Frames {
+		for _, text := range []string{frame.Label, frame.MapTitle, frame.MapAuthor} {
+			if err := writeString(buffer, text); err != nil {
+				return fmt.Errorf("frame %q: %w", frame.Label, err)
+			}
+		}
+		binary.Write(buffer, binary.LittleEndian, frame.MapStyle)
+		writeInteger(buffer, int32(len(frame.Tiles)))
+		for _, replayTile := range frame.Tiles {
+			writeInteger(buffer, int32(replayTile.X))
+			writeInteger(buffer, int32(replayTile.Z))
+			if err := serializeTile(buffer, replayTile.Tile, MAP_VERSION); err != nil {
+				return fmt.Errorf("frame %q tile (%d,%d): %w", frame.Label, replayTile.X, replayTile.Z, err)
+			}
+		}
+	}
+	_, err = w.Write(Compress(buffer.Bytes()))
+	return err
+}
+
+func ReadReplay(r io.Reader) (*Replay, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	inputData, err := Decompress(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress replay: %w", err)
+	}
+	streamReader := io.NewSectionReader(bytes.NewReader(inputData), int64(0), int64(len(inputData)))
+
+	header, err := readString(streamReader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading replay header: %w", err)
+	}
+	if header != REPLAY_HEADER {
+		return nil, fmt.Errorf("the header string %q is not a replay", header)
+	}
+	version := int32(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &version); err != nil {
+		return nil, fmt.Errorf("error reading replay version: %w", err)
+	}
+	if version != REPLAY_VERSION {
+		return nil, fmt.Errorf("unsupported replay version %d", version)
+	}
+
+	initialLength := int32(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &initialLength); err != nil {
+		return nil, fmt.Errorf("error reading initial map length: %w", err)
+	}
+	if initialLength < 0 || int64(initialLength) > streamReader.Size() {
+		return nil, fmt.Errorf("invalid initial map length %d", initialLength)
+	}
+	initialData := make([]byte, initialLength)
+	if _, err := io.ReadFull(streamReader, initialData); err != nil {
+		return nil, fmt.Errorf("error reading initial map: %w", err)
+	}
+	initial, err := DeserializeData(initialData)
+	if err != nil {
+		return nil, fmt.Errorf("error reading initial map: %w", err)
+	}
+	replay := &Replay{Initial: initial}
+
+	frameCount := int32(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &frameCount); err != nil {
+		return nil, fmt.Errorf("error reading frame count: %w", err)
+	}
+	for i := 0; i < int(frameCount); i++ {
+		frame, err := readReplayFrame(streamReader, initial)
+		if err != nil {
+			return nil, fmt.Errorf("error reading frame %d: %w", i, err)
+		}
+		replay.Frames = append(replay.Frames, *frame)
+	}
+	return replay, nil
+}
+
+func readReplayFrame(streamReader *io.SectionReader, initial *HE3Map) (*ReplayFrame, error) {
+	label, err := readString(streamReader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading label: %w", err)
+	}
+	frame := &ReplayFrame{Label: label}
+	if frame.MapTitle, err = readString(streamReader); err != nil {
+		return nil, fmt.Errorf("error reading map title: %w", err)
+	}
+	if frame.MapAuthor, err = readString(streamReader); err != nil {
+		return nil, fmt.Errorf("error reading map author: %w", err)
+	}
+	if err := binary.Read(streamReader, binary.LittleEndian, &frame.MapStyle); err != nil {
+		return nil, fmt.Errorf("error reading style: %w", err)
+	}
+	tileCount := int32(0)
+	if err := binary.Read(streamReader, binary.LittleEndian, &tileCount); err != nil {
+		return nil, fmt.Errorf("error reading tile count: %w", err)
+	}
+	if tileCount < 0 || tileCount > initial.Width*initial.Depth {
+		return nil, fmt.Errorf("invalid tile count %d", tileCount)
+	}
+	for i := 0; i < int(tileCount); i++ {
+		position := [2]int32{}
+		if err := binary.Read(streamReader, binary.LittleEndian, &position); err != nil {
+			return nil, fmt.Errorf("error reading tile position: %w", err)
+		}
+		x, z := int(position[0]), int(position[1])
+		if x < 0 || z < 0 || x >= int(initial.Width) || z >= int(initial.Depth) {
+			return nil, fmt.Errorf("tile (%d,%d) is outside the map", x, z)
+		}
+		tile, err := deserializeTile(streamReader, MAP_VERSION, false)
+		if err != nil {
+			return nil, err
+		}
+		frame.Tiles = append(frame.Tiles, ReplayTile{X: x, Z: z, Tile: tile})
+	}
+	return frame, nil
+}
+
+func WriteReplayFile(filename string, replay *Replay) error {
+	outputFile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	if err := WriteReplay(outputFile, replay); err != nil {
+		return err
+	}
+	return outputFile.Close()
+}
+
+func ReadReplayFile(filename string) (*Replay, error) {
+	inputFile, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer inputFile.Close()
+	return ReadReplay(inputFile)
+}
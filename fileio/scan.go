@@ -0,0 +1,58 @@
+package fileio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StopScan can be returned by a ScanTiles callback to stop reading tiles without an error
+var StopScan = errors.New("stop scan")
+
+// ScanTiles reads a .he3 file from r and calls fn for each tile in the same order as
+// MapTiles, x first and then z, without building the tile grid. The tile passed to fn is
+// reused for the next tile, so fn must Clone it to keep it.
+//
+// ScanTiles doesn't stream the file: it is decoded and decompressed into memory first,
+// like Deserialize does. Only the memory and allocations of the tile grid are saved.
+//
+// If fn returns an error, the scan stops and ScanTiles returns the error, unless it is
+// StopScan.
+func ScanTiles(r io.Reader, fn func(x, z int, tile *MapTile) error) error {
+	compressed, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, r))
+	if err != nil {
+		return fmt.Errorf("failed to decode string: %w", err)
+	}
+	inputData, err := Decompress(compressed)
+	if err != nil {
+		return fmt.Errorf("failed to decompress: %w", err)
+	}
+	return scanTileData(inputData, fn)
+}
+
+// scanTileData is ScanTiles for the data after decompression
+func scanTileData(inputData []byte, fn func(x, z int, tile *MapTile) error) error {
+	streamReader := io.NewSectionReader(bytes.NewReader(inputData), int64(0), int64(len(inputData)))
+	mapData, err := deserializeHeader(streamReader)
+	if err != nil {
+		return err
+	}
+
+	tile := &MapTile{}
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			if err := readTile(streamReader, tile, int(mapData.Version), false); err != nil {
+				return err
+			}
+			if err := fn(x, z, tile); err != nil {
+				if err == StopScan {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
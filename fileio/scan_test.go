@@ -0,0 +1,147 @@
+package fileio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type benchmarkMap struct {
+	name    string
+	content []byte
+}
+
+// benchmarkMaps returns the bundled maps and a large generated map
+func benchmarkMaps(b *testing.B) []benchmarkMap {
+	maps := []benchmarkMap{}
+	filenames, err := filepath.Glob(filepath.Join("..", "maps", "*.he3"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i, content := range bundledMaps(b) {
+		maps = append(maps, benchmarkMap{strings.TrimSuffix(filepath.Base(filenames[i]), ".he3"), content})
+	}
+
+	large := NewHE3Map(512, 512)
+	for x := 0; x < int(large.Width); x++ {
+		for z := 0; z < int(large.Depth); z++ {
+			tile := large.MapTiles[x][z]
+			tile.SetHeight(float32((x+z)%10) / 10)
+			if x%16 == 0 && z%16 == 0 {
+				tile.TileType = City
+				tile.CityName = fmt.Sprintf("City %d %d", x, z)
+				tile.Party = (x + z) % 6
+			}
+		}
+	}
+	content, err := Serialize(large)
+	if err != nil {
+		b.Fatal(err)
+	}
+	maps = append(maps, benchmarkMap{"Large", []byte(content)})
+	return maps
+}
+
+func TestScanTilesMatchesDeserialize(t *testing.T) {
+	for _, content := range bundledMaps(t) {
+		mapData, err := Deserialize(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := 0
+		err = ScanTiles(bytes.NewReader(content), func(x, z int, tile *MapTile) error {
+			expectedX, expectedZ := next/int(mapData.Depth), next%int(mapData.Depth)
+			if x != expectedX || z != expectedZ {
+				return fmt.Errorf("tile %d is (%d,%d), expected (%d,%d)", next, x, z, expectedX, expectedZ)
+			}
+			if !reflect.DeepEqual(tile, mapData.At(x, z)) {
+				return fmt.Errorf("tile (%d,%d) is %+v, expected %+v", x, z, tile, mapData.At(x, z))
+			}
+			next++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", mapData.MapTitle, err)
+		}
+		if next != int(mapData.Width*mapData.Depth) {
+			t.Errorf("%s: scanned %d tiles, expected %d", mapData.MapTitle, next, mapData.Width*mapData.Depth)
+		}
+	}
+}
+
+func TestScanTilesStops(t *testing.T) {
+	content := bundledMaps(t)[0]
+	scanned := 0
+	err := ScanTiles(bytes.NewReader(content), func(x, z int, tile *MapTile) error {
+		scanned++
+		if scanned == 5 {
+			return StopScan
+		}
+		return nil
+	})
+	if err != nil || scanned != 5 {
+		t.Errorf("got error %v after %d tiles, expected no error after 5 tiles", err, scanned)
+	}
+
+	failed := errors.New("failed")
+	err = ScanTiles(bytes.NewReader(content), func(x, z int, tile *MapTile) error {
+		return failed
+	})
+	if err != failed {
+		t.Errorf("got error %v, expected the callback's error", err)
+	}
+
+	if err := ScanTiles(strings.NewReader("not a map"), func(x, z int, tile *MapTile) error { return nil }); err == nil {
+		t.Error("expected an error for invalid input")
+	}
+}
+
+// BenchmarkDeserialize lists the city names by decoding the whole map
+func BenchmarkDeserialize(b *testing.B) {
+	for _, benchmark := range benchmarkMaps(b) {
+		content := benchmark.content
+		b.Run(benchmark.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				mapData, err := Deserialize(content)
+				if err != nil {
+					b.Fatal(err)
+				}
+				cities := []string{}
+				for x := range mapData.MapTiles {
+					for z := range mapData.MapTiles[x] {
+						if mapData.MapTiles[x][z].CityName != "" {
+							cities = append(cities, mapData.MapTiles[x][z].CityName)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkScanTiles lists the city names with ScanTiles
+func BenchmarkScanTiles(b *testing.B) {
+	for _, benchmark := range benchmarkMaps(b) {
+		content := benchmark.content
+		b.Run(benchmark.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				cities := []string{}
+				err := ScanTiles(bytes.NewReader(content), func(x, z int, tile *MapTile) error {
+					if tile.CityName != "" {
+						cities = append(cities, tile.CityName)
+					}
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package fileio
+
+import (
+	"fmt"
+)
+
+// StitchPiece is a map placed on a larger map with its first tile at (X, Z)
+type StitchPiece struct {
+	Map *HE3Map
+	X   int32
+	Z   int32
+	// Parties maps parties of this map to parties on the stitched map.
+	// Parties that aren't in the table keep their number. Parties mapped to -1 become neutral
+	// and lose their capital flags and armies.
+	Parties map[int]int
+}
+
+type stitchedCity struct {
+	piece int
+	x     int
+	z     int
+}
+
+// remapParty gives a placed tile its party on the stitched map
+func remapParty(tile *MapTile, parties map[int]int) {
+	newParty, ok := parties[tile.Party]
+	if !ok {
+		return
+	}
+	tile.Party = newParty
+	if newParty == -1 {
+		tile.HasFlag = false
+		tile.Infantry, tile.Artillery = nil, nil
+		tile.HasInfantry, tile.HasArtillery = false, false
+	}
+}
+
+// Stitch joins maps into one map that is just large enough to hold them all. Gaps are
+// filled with sea, the metadata comes from the first map as in CopyMetadata, and the
+// version is the newest version of the maps. The maps may overlap where at least one of
+// them has sea. Pieces must start on an even row so that rows keep their offset.
+//
+// Stitch fails if two maps have land on the same tile, if city names are used more than
+// once, if a party has a capital in more than one map, or if the stitched map would be
+// larger than MAX_MAP_DIMENSION.
+func Stitch(pieces []StitchPiece) (*HE3Map, error) {
+	if len(pieces) == 0 {
+		return nil, fmt.Errorf("no maps to stitch")
+	}
+	width, depth := int32(0), int32(0)
+	for i, piece := range pieces {
+		if piece.X < 0 || piece.Z < 0 {
+			return nil, fmt.Errorf("map %d has a negative offset (%d,%d)", i, piece.X, piece.Z)
+		}
+		if piece.Z%2 != 0 {
+			return nil, fmt.Errorf("map %d must start on an even row, got z=%d", i, piece.Z)
+		}
+		for party, newParty := range piece.Parties {
+			if newParty < -1 || newParty >= MAX_PARTIES {
+				return nil, fmt.Errorf("map %d: party %d can't become party %d", i, party, newParty)
+			}
+		}
+		// Compared as int64 so that large offsets can't overflow
+		if int64(piece.X)+int64(piece.Map.Width) > MAX_MAP_DIMENSION || int64(piece.Z)+int64(piece.Map.Depth) > MAX_MAP_DIMENSION {
+			return nil, fmt.Errorf("map %d at (%d,%d) doesn't fit in the largest map size of %dx%d", i, piece.X, piece.Z, MAX_MAP_DIMENSION, MAX_MAP_DIMENSION)
+		}
+		width = max(width, piece.X+piece.Map.Width)
+		depth = max(depth, piece.Z+piece.Map.Depth)
+	}
+
+	newMap := CopyMetadata(pieces[0].Map, width, depth)
+	// Older versions can't hold everything, such as artillery armies, so the newest version wins
+	for _, piece := range pieces {
+		newMap.Version = max(newMap.Version, piece.Map.Version)
+	}
+	// Map that each tile came from, or -1 for the sea that fills gaps
+	owner := make([][]int, width)
+	hasLand := make([][]bool, width)
+	for x := range owner {
+		owner[x] = make([]int, depth)
+		hasLand[x] = make([]bool, depth)
+		for z := range owner[x] {
+			owner[x][z] = -1
+		}
+	}
+	cities := map[string]stitchedCity{}
+	capitals := map[int]int{}
+	for i, piece := range pieces {
+		for x := 0; x < int(piece.Map.Width); x++ {
+			for z := 0; z < int(piece.Map.Depth); z++ {
+				tile := piece.Map.At(x, z)
+				newX, newZ := x+int(piece.X), z+int(piece.Z)
+				if tile.IsSea {
+					// Keep the sea of the first map that covers the tile
+					if owner[newX][newZ] < 0 {
+						owner[newX][newZ] = i
+						placeTile(newMap, newX, newZ, tile)
+						remapParty(newMap.At(newX, newZ), piece.Parties)
+					}
+					continue
+				}
+				if hasLand[newX][newZ] {
+					return nil, fmt.Errorf("maps %d and %d both have land at (%d,%d)", owner[newX][newZ], i, newX, newZ)
+				}
+				owner[newX][newZ] = i
+				hasLand[newX][newZ] = true
+				placeTile(newMap, newX, newZ, tile)
+				placed := newMap.At(newX, newZ)
+				remapParty(placed, piece.Parties)
+
+				if tile.CityName != "" && tile.TileType >= Airport {
+					if other, ok := cities[tile.CityName]; ok {
+						return nil, fmt.Errorf("city name %q is used at (%d,%d) in map %d and at (%d,%d) in map %d",
+							tile.CityName, other.x, other.z, other.piece, newX, newZ, i)
+					}
+					cities[tile.CityName] = stitchedCity{piece: i, x: newX, z: newZ}
+				}
+				if placed.HasFlag && placed.Party >= 0 {
+					if other, ok := capitals[placed.Party]; ok && other != i {
+						return nil, fmt.Errorf("party %d has a capital in map %d and map %d, give one of them another party",
+							placed.Party, other, i)
+					}
+					capitals[placed.Party] = i
+				}
+			}
+		}
+	}
+	return newMap, nil
+}
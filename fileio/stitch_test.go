@@ -0,0 +1,56 @@
+package fileio
+
+import "testing"
+
+func TestStitchRemapsEveryParty(t *testing.T) {
+	piece := NewHE3Map(2, 2)
+	for x := 0; x < 2; x++ {
+		for z := 0; z < 2; z++ {
+			tile := piece.At(x, z)
+			tile.Party = 1
+			tile.SetHeight(1)
+		}
+	}
+	// Sea can be owned too, for example by the party that holds the coast
+	piece.At(0, 0).SetHeight(0)
+	piece.At(1, 1).HasFlag = true
+
+	stitched, err := Stitch([]StitchPiece{{Map: piece, Parties: map[int]int{1: 4}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 2; x++ {
+		for z := 0; z < 2; z++ {
+			if party := stitched.At(x, z).Party; party != 4 {
+				t.Errorf("tile (%d,%d) has party %d, expected 4", x, z, party)
+			}
+		}
+	}
+
+	neutral, err := Stitch([]StitchPiece{{Map: piece, Parties: map[int]int{1: -1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile := neutral.At(0, 0); tile.Party != -1 {
+		t.Errorf("sea tile has party %d, expected -1", tile.Party)
+	}
+	if neutral.At(1, 1).HasFlag {
+		t.Error("neutral tile kept its capital flag")
+	}
+
+	if _, err := Stitch([]StitchPiece{{Map: piece, Parties: map[int]int{1: MAX_PARTIES}}}); err == nil {
+		t.Error("expected an error for a party the game doesn't have")
+	}
+}
+
+func TestStitchRejectsMapsBeyondTheLargestSize(t *testing.T) {
+	piece := NewHE3Map(2, 2)
+	if _, err := Stitch([]StitchPiece{{Map: piece, X: MAX_MAP_DIMENSION - 2}}); err != nil {
+		t.Error(err)
+	}
+	for _, offset := range [][2]int32{{MAX_MAP_DIMENSION - 1, 0}, {0, MAX_MAP_DIMENSION}, {1<<31 - 1, 0}} {
+		if _, err := Stitch([]StitchPiece{{Map: piece, X: offset[0], Z: offset[1]}}); err == nil {
+			t.Errorf("expected an error for a map at (%d,%d)", offset[0], offset[1])
+		}
+	}
+}
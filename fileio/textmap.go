@@ -0,0 +1,599 @@
+package fileio
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The text map format stores a map as ASCII grids with one character per tile,
+// plus tables for cities and armies. Grid rows run from z = depth - 1 at the top
+// down to z = 0, so the grid has the same orientation as the rendered PNG.
+
+const (
+	TEXT_MAP_HEADER = "hexmap-text 1"
+	// Heights that are not in the legend are written with this character
+	// and listed in exact lines. Without an exact line it means a mountain.
+	TEXT_MAP_EXACT_HEIGHT   = '^'
+	TEXT_MAP_DEFAULT_HEIGHT = float32(1.0)
+	TEXT_MAP_HEIGHT_SYMBOLS = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	// The most padding a text map may ask for, so that a small file can't allocate without limit
+	TEXT_MAP_MAX_PADDING = 1 << 20
+)
+
+var (
+	// Terrain characters indexed by FieldType
+	TEXT_MAP_TERRAIN = [10]byte{'g', 's', 'f', 'w', 'n', 'A', 'F', 'T', 'C', 'K'}
+)
+
+func partySymbol(party int) (byte, error) {
+	if party == -1 {
+		return '-', nil
+	}
+	if party < 0 || party >= MAX_PARTIES {
+		return 0, fmt.Errorf("party %d can't be written in the text format", party)
+	}
+	return byte('0' + party), nil
+}
+
+// heightLegend assigns a symbol to the most common heights
+func heightLegend(mapData *HE3Map) map[float32]byte {
+	counts := map[float32]int{}
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			counts[mapData.At(x, z).Height]++
+		}
+	}
+	heights := make([]float32, 0, len(counts))
+	for height := range counts {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool {
+		if counts[heights[i]] != counts[heights[j]] {
+			return counts[heights[i]] > counts[heights[j]]
+		}
+		return heights[i] < heights[j]
+	})
+	if len(heights) > len(TEXT_MAP_HEIGHT_SYMBOLS) {
+		heights = heights[:len(TEXT_MAP_HEIGHT_SYMBOLS)]
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	legend := map[float32]byte{}
+	for i, height := range heights {
+		legend[height] = TEXT_MAP_HEIGHT_SYMBOLS[i]
+	}
+	return legend
+}
+
+// isZeroPadding reports whether the trailing data is only zero bytes, which the game pads
+// its saves with
+func isZeroPadding(data []byte) bool {
+	for _, value := range data {
+		if value != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func formatFloat(value float32) string {
+	return strconv.FormatFloat(float64(value), 'g', -1, 32)
+}
+
+func writeGrid(writer *bufio.Writer, mapData *HE3Map, symbol func(tile *MapTile) (byte, error)) error {
+	row := make([]byte, mapData.Width)
+	for z := int(mapData.Depth) - 1; z >= 0; z-- {
+		for x := 0; x < int(mapData.Width); x++ {
+			char, err := symbol(mapData.At(x, z))
+			if err != nil {
+				return fmt.Errorf("tile (%d,%d): %w", x, z, err)
+			}
+			row[x] = char
+		}
+		writer.Write(row)
+		writer.WriteByte('\n')
+	}
+	return nil
+}
+
+func writeTextArmy(writer *bufio.Writer, x, z int, kind string, army *Army) {
+	fmt.Fprintf(writer, "%d %d %s %d %d %d %d %s\n",
+		x, z, kind, army.X, army.Y, army.UnitInfantry, army.UnitArtillery, formatFloat(army.Morale))
+}
+
+// WriteTextMap writes the map in the text map format
+func WriteTextMap(w io.Writer, mapData *HE3Map) error {
+	writer := bufio.NewWriter(w)
+	fmt.Fprintln(writer, TEXT_MAP_HEADER)
+	fmt.Fprintf(writer, "title %s\n", strconv.Quote(mapData.MapTitle))
+	fmt.Fprintf(writer, "author %s\n", strconv.Quote(mapData.MapAuthor))
+	fmt.Fprintf(writer, "size %d %d\n", mapData.Width, mapData.Depth)
+	fmt.Fprintf(writer, "style %d %d %d %d %d\n",
+		mapData.MapStyle.Grass, mapData.MapStyle.Mountains, mapData.MapStyle.Desert,
+		mapData.MapStyle.Sea, mapData.MapStyle.Light)
+	if mapData.Version != 0 {
+		fmt.Fprintf(writer, "version %d\n", mapData.Version)
+	}
+	if mapData.GameState != 0 {
+		fmt.Fprintf(writer, "gamestate %d\n", mapData.GameState)
+	}
+	if len(mapData.Trailing) > 0 {
+		if isZeroPadding(mapData.Trailing) {
+			fmt.Fprintf(writer, "padding %d\n", len(mapData.Trailing))
+		} else {
+			fmt.Fprintf(writer, "trailing %s\n", base64.StdEncoding.EncodeToString(mapData.Trailing))
+		}
+	}
+
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "[terrain]")
+	fmt.Fprintln(writer, "# g=Grass s=Sand f=Farmland w=Forest n=Snow A=Airport F=Factory T=Town C=City K=Capital")
+	err := writeGrid(writer, mapData, func(tile *MapTile) (byte, error) {
+		if int(tile.TileType) >= len(TEXT_MAP_TERRAIN) {
+			return 0, fmt.Errorf("unknown tile type %d", tile.TileType)
+		}
+		return TEXT_MAP_TERRAIN[tile.TileType], nil
+	})
+	if err != nil {
+		return err
+	}
+
+	legend := heightLegend(mapData)
+	symbols := make([]float32, 0, len(legend))
+	for height := range legend {
+		symbols = append(symbols, height)
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i] < symbols[j] })
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "[height]")
+	for _, height := range symbols {
+		fmt.Fprintf(writer, "legend %c %s\n", legend[height], formatFloat(height))
+	}
+	writeGrid(writer, mapData, func(tile *MapTile) (byte, error) {
+		if symbol, ok := legend[tile.Height]; ok {
+			return symbol, nil
+		}
+		return TEXT_MAP_EXACT_HEIGHT, nil
+	})
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			height := mapData.At(x, z).Height
+			if _, ok := legend[height]; !ok {
+				fmt.Fprintf(writer, "exact %d %d %s\n", x, z, formatFloat(height))
+			}
+		}
+	}
+
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "[party]")
+	fmt.Fprintln(writer, "# - is neutral, 0-5 is the owning party")
+	err = writeGrid(writer, mapData, func(tile *MapTile) (byte, error) {
+		return partySymbol(tile.Party)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "[roads]")
+	fmt.Fprintln(writer, "# = is a road, . is no road")
+	writeGrid(writer, mapData, func(tile *MapTile) (byte, error) {
+		if tile.HasRoad {
+			return '=', nil
+		}
+		return '.', nil
+	})
+
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "[cities]")
+	fmt.Fprintln(writer, "# x z flag name")
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			tile := mapData.At(x, z)
+			if tile.CityName == "" && !tile.HasFlag {
+				continue
+			}
+			flag := 0
+			if tile.HasFlag {
+				flag = 1
+			}
+			fmt.Fprintf(writer, "%d %d %d %s\n", x, z, flag, strconv.Quote(tile.CityName))
+		}
+	}
+
+	fmt.Fprintln(writer)
+	fmt.Fprintln(writer, "[armies]")
+	fmt.Fprintln(writer, "# x z kind armyX armyY infantry artillery morale")
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			tile := mapData.At(x, z)
+			if tile.Infantry != nil {
+				writeTextArmy(writer, x, z, "infantry", tile.Infantry)
+			}
+			if tile.Artillery != nil {
+				writeTextArmy(writer, x, z, "artillery", tile.Artillery)
+			}
+		}
+	}
+
+	// Bytes the sections above can't hold, kept so that the saved map doesn't change
+	raw := []string{}
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			tile := mapData.At(x, z)
+			if tile.TileType == Grass && tile.rawTypeCode != 0 {
+				raw = append(raw, fmt.Sprintf("%d %d type %d", x, z, tile.rawTypeCode))
+			}
+			if tile.Infantry == nil && tile.rawInfantryFlag != 0 {
+				raw = append(raw, fmt.Sprintf("%d %d infantryflag %d", x, z, tile.rawInfantryFlag))
+			}
+			// Maps before version 3 have an artillery flag without an army
+			if tile.Artillery == nil && tile.HasArtillery {
+				raw = append(raw, fmt.Sprintf("%d %d artilleryflag 1", x, z))
+			} else if tile.Artillery == nil && tile.rawArtilleryFlag != 0 {
+				raw = append(raw, fmt.Sprintf("%d %d artilleryflag %d", x, z, tile.rawArtilleryFlag))
+			}
+		}
+	}
+	if len(raw) > 0 {
+		fmt.Fprintln(writer)
+		fmt.Fprintln(writer, "[raw]")
+		fmt.Fprintln(writer, "# x z field value")
+		for _, line := range raw {
+			fmt.Fprintln(writer, line)
+		}
+	}
+	return writer.Flush()
+}
+
+type textMapParser struct {
+	mapData    *HE3Map
+	lineNumber int
+	section    string
+	// Number of grid rows read in the current section
+	gridRows     int
+	heightLegend map[byte]float32
+	// Exact heights are applied after the grid so they can be listed in any order
+	exactHeights map[*MapTile]float32
+	// Tiles with a raw type code, which are checked to be grass once every section is read
+	rawTypeTiles []*MapTile
+}
+
+func (parser *textMapParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", parser.lineNumber, fmt.Sprintf(format, args...))
+}
+
+func (parser *textMapParser) tileAt(xText, zText string) (*MapTile, error) {
+	x, errX := strconv.Atoi(xText)
+	z, errZ := strconv.Atoi(zText)
+	if errX != nil || errZ != nil {
+		return nil, parser.errorf("invalid tile position %s %s", xText, zText)
+	}
+	if parser.mapData.MapTiles == nil {
+		return nil, parser.errorf("size must be set before tiles")
+	}
+	if x < 0 || z < 0 || x >= int(parser.mapData.Width) || z >= int(parser.mapData.Depth) {
+		return nil, parser.errorf("tile position %d %d is outside the map", x, z)
+	}
+	return parser.mapData.At(x, z), nil
+}
+
+func (parser *textMapParser) parseHeader(line string) error {
+	key, value, _ := strings.Cut(line, " ")
+	mapData := parser.mapData
+	switch key {
+	case "title", "author":
+		text, err := strconv.Unquote(value)
+		if err != nil {
+			return parser.errorf("invalid %s %s", key, value)
+		}
+		if len(text) > MAX_STRING_LENGTH {
+			return parser.errorf("%s is %d bytes, longer than %d", key, len(text), MAX_STRING_LENGTH)
+		}
+		if key == "title" {
+			mapData.MapTitle = text
+		} else {
+			mapData.MapAuthor = text
+		}
+	case "size":
+		var width, depth int32
+		if _, err := fmt.Sscanf(value, "%d %d", &width, &depth); err != nil {
+			return parser.errorf("invalid size %s", value)
+		}
+		if width <= 0 || depth <= 0 || width > MAX_MAP_DIMENSION || depth > MAX_MAP_DIMENSION {
+			return parser.errorf("invalid size %s, width and depth must be between 1 and %d", value, MAX_MAP_DIMENSION)
+		}
+		newMap := NewHE3Map(width, depth)
+		mapData.MapTiles = newMap.MapTiles
+		mapData.Width = width
+		mapData.Depth = depth
+	case "style":
+		style := &mapData.MapStyle
+		_, err := fmt.Sscanf(value, "%d %d %d %d %d", &style.Grass, &style.Mountains, &style.Desert, &style.Sea, &style.Light)
+		if err != nil {
+			return parser.errorf("invalid style %s", value)
+		}
+	case "version":
+		version, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || version <= 0 {
+			return parser.errorf("invalid version %s", value)
+		}
+		mapData.Version = int32(version)
+	case "gamestate":
+		state, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			return parser.errorf("invalid gamestate %s", value)
+		}
+		mapData.GameState = byte(state)
+	case "padding":
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 0 || length > TEXT_MAP_MAX_PADDING {
+			return parser.errorf("invalid padding %s", value)
+		}
+		mapData.Trailing = make([]byte, length)
+	case "trailing":
+		trailing, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return parser.errorf("invalid trailing data: %v", err)
+		}
+		mapData.Trailing = trailing
+	default:
+		return parser.errorf("unknown header field %s", key)
+	}
+	return nil
+}
+
+func (parser *textMapParser) parseGridRow(line string) error {
+	mapData := parser.mapData
+	if mapData.MapTiles == nil {
+		return parser.errorf("size must be set before the %s grid", parser.section)
+	}
+	if parser.gridRows >= int(mapData.Depth) {
+		return parser.errorf("%s grid has more than %d rows", parser.section, mapData.Depth)
+	}
+	if len(line) != int(mapData.Width) {
+		return parser.errorf("%s grid row has %d tiles, expected %d", parser.section, len(line), mapData.Width)
+	}
+
+	z := int(mapData.Depth) - 1 - parser.gridRows
+	parser.gridRows++
+	for x := 0; x < len(line); x++ {
+		tile := mapData.At(x, z)
+		char := line[x]
+		switch parser.section {
+		case "terrain":
+			index := strings.IndexByte(string(TEXT_MAP_TERRAIN[:]), char)
+			if index < 0 {
+				return parser.errorf("unknown terrain %q", char)
+			}
+			tile.TileType = FieldType(index)
+		case "height":
+			if char == TEXT_MAP_EXACT_HEIGHT {
+				tile.SetHeight(TEXT_MAP_DEFAULT_HEIGHT)
+				continue
+			}
+			height, ok := parser.heightLegend[char]
+			if !ok {
+				return parser.errorf("height %q is not in the legend", char)
+			}
+			tile.SetHeight(height)
+		case "party":
+			if char == '-' {
+				tile.Party = -1
+			} else if char >= '0' && char < '0'+MAX_PARTIES {
+				tile.Party = int(char - '0')
+			} else {
+				return parser.errorf("unknown party %q", char)
+			}
+		case "roads":
+			if char != '=' && char != '.' {
+				return parser.errorf("unknown road %q", char)
+			}
+			tile.HasRoad = char == '='
+		}
+	}
+	return nil
+}
+
+func (parser *textMapParser) parseHeightLine(line string) error {
+	fields := strings.Fields(line)
+	switch {
+	case fields[0] == "legend" && len(fields) == 3 && len(fields[1]) == 1:
+		height, err := strconv.ParseFloat(fields[2], 32)
+		if err != nil {
+			return parser.errorf("invalid height %s", fields[2])
+		}
+		parser.heightLegend[fields[1][0]] = float32(height)
+	case fields[0] == "exact" && len(fields) == 4:
+		tile, err := parser.tileAt(fields[1], fields[2])
+		if err != nil {
+			return err
+		}
+		height, err := strconv.ParseFloat(fields[3], 32)
+		if err != nil {
+			return parser.errorf("invalid height %s", fields[3])
+		}
+		parser.exactHeights[tile] = float32(height)
+	default:
+		return parser.errorf("invalid height line %s", line)
+	}
+	return nil
+}
+
+func (parser *textMapParser) parseCity(line string) error {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) != 4 {
+		return parser.errorf("invalid city %s", line)
+	}
+	tile, err := parser.tileAt(fields[0], fields[1])
+	if err != nil {
+		return err
+	}
+	name, err := strconv.Unquote(fields[3])
+	if err != nil || (fields[2] != "0" && fields[2] != "1") {
+		return parser.errorf("invalid city %s", line)
+	}
+	if len(name) > MAX_STRING_LENGTH {
+		return parser.errorf("city name is %d bytes, longer than %d", len(name), MAX_STRING_LENGTH)
+	}
+	tile.HasFlag = fields[2] == "1"
+	tile.CityName = name
+	return nil
+}
+
+func (parser *textMapParser) parseArmy(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 8 {
+		return parser.errorf("invalid army %s", line)
+	}
+	tile, err := parser.tileAt(fields[0], fields[1])
+	if err != nil {
+		return err
+	}
+	army := &Army{}
+	_, err = fmt.Sscanf(strings.Join(fields[3:], " "), "%d %d %d %d %g",
+		&army.X, &army.Y, &army.UnitInfantry, &army.UnitArtillery, &army.Morale)
+	if err != nil {
+		return parser.errorf("invalid army %s", line)
+	}
+	switch fields[2] {
+	case "infantry":
+		tile.Infantry = army
+		tile.HasInfantry = true
+	case "artillery":
+		tile.Artillery = army
+		tile.HasArtillery = true
+	default:
+		return parser.errorf("unknown army kind %s", fields[2])
+	}
+	return nil
+}
+
+func (parser *textMapParser) parseRaw(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return parser.errorf("invalid raw line %s", line)
+	}
+	tile, err := parser.tileAt(fields[0], fields[1])
+	if err != nil {
+		return err
+	}
+	value, err := strconv.ParseUint(fields[3], 10, 8)
+	if err != nil {
+		return parser.errorf("invalid %s %s", fields[2], fields[3])
+	}
+	switch fields[2] {
+	case "type":
+		// Known codes belong in the terrain grid, and the top two bits hold the road and flag
+		if int(value) < len(SERIALIZATION_TYPE_CONV) || value >= 64 {
+			return parser.errorf("type code %d must be between %d and 63", value, len(SERIALIZATION_TYPE_CONV))
+		}
+		parser.rawTypeTiles = append(parser.rawTypeTiles, tile)
+		tile.rawTypeCode = byte(value)
+	case "infantryflag":
+		// 1 is an army, which belongs in the armies section
+		if value < 2 {
+			return parser.errorf("infantry flag %d must be between 2 and 255", value)
+		}
+		tile.rawInfantryFlag = byte(value)
+	case "artilleryflag":
+		if value == 0 {
+			return parser.errorf("artillery flag must be between 1 and 255")
+		}
+		if value == 1 {
+			tile.HasArtillery = true
+		} else {
+			tile.rawArtilleryFlag = byte(value)
+		}
+	default:
+		return parser.errorf("unknown raw field %s", fields[2])
+	}
+	return nil
+}
+
+// ReadTextMap parses a map written in the text map format
+func ReadTextMap(r io.Reader) (*HE3Map, error) {
+	parser := &textMapParser{
+		mapData:      &HE3Map{},
+		heightLegend: map[byte]float32{},
+		exactHeights: map[*MapTile]float32{},
+	}
+	gridRows := map[string]int{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	sawHeader := false
+	for scanner.Scan() {
+		parser.lineNumber++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !sawHeader {
+			if line != TEXT_MAP_HEADER {
+				return nil, parser.errorf("expected %q", TEXT_MAP_HEADER)
+			}
+			sawHeader = true
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			gridRows[parser.section] = parser.gridRows
+			parser.section = line[1 : len(line)-1]
+			parser.gridRows = 0
+			continue
+		}
+
+		var err error
+		switch parser.section {
+		case "":
+			err = parser.parseHeader(line)
+		case "terrain", "party", "roads":
+			err = parser.parseGridRow(line)
+		case "height":
+			// Grid rows never contain spaces
+			if strings.Contains(line, " ") {
+				err = parser.parseHeightLine(line)
+			} else {
+				err = parser.parseGridRow(line)
+			}
+		case "cities":
+			err = parser.parseCity(line)
+		case "armies":
+			err = parser.parseArmy(line)
+		case "raw":
+			err = parser.parseRaw(line)
+		default:
+			err = parser.errorf("unknown section %s", parser.section)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	gridRows[parser.section] = parser.gridRows
+
+	if parser.mapData.MapTiles == nil {
+		return nil, fmt.Errorf("text map has no size")
+	}
+	for _, section := range []string{"terrain", "height"} {
+		if gridRows[section] != int(parser.mapData.Depth) {
+			return nil, fmt.Errorf("%s grid has %d rows, expected %d", section, gridRows[section], parser.mapData.Depth)
+		}
+	}
+	for _, tile := range parser.rawTypeTiles {
+		if tile.TileType != Grass {
+			return nil, fmt.Errorf("tile with a raw type code must be grass, got %s", tile.TileType)
+		}
+	}
+	for tile, height := range parser.exactHeights {
+		tile.SetHeight(height)
+	}
+	return parser.mapData, nil
+}
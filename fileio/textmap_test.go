@@ -0,0 +1,132 @@
+package fileio
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTextMapRoundTrip(t *testing.T) {
+	for _, content := range bundledMaps(t) {
+		original, err := DecodeData(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mapData, err := DeserializeData(original)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var text bytes.Buffer
+		if err := WriteTextMap(&text, mapData); err != nil {
+			t.Fatalf("%s: %v", mapData.MapTitle, err)
+		}
+		parsed, err := ReadTextMap(&text)
+		if err != nil {
+			t.Fatalf("%s: %v", mapData.MapTitle, err)
+		}
+		saved, err := SerializeData(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(original, saved) {
+			t.Errorf("%s: text round trip changed the map (%d bytes read, %d bytes saved)",
+				mapData.MapTitle, len(original), len(saved))
+		}
+	}
+}
+
+func TestTextMapKeepsRawBytes(t *testing.T) {
+	mapData := NewHE3Map(2, 2)
+	// Maps before version 3 only have a flag for artillery
+	mapData.Version = 2
+	mapData.At(0, 0).rawTypeCode = 33
+	mapData.At(1, 0).rawInfantryFlag = 2
+	mapData.At(0, 1).HasArtillery = true
+	mapData.At(1, 1).rawArtilleryFlag = 255
+	original, err := SerializeData(mapData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var text bytes.Buffer
+	if err := WriteTextMap(&text, mapData); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ReadTextMap(&text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := SerializeData(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(original, saved) {
+		t.Errorf("text round trip changed the map:\n%v\n%v", original, saved)
+	}
+}
+
+func TestReadTextMapErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		error string
+	}{
+		{
+			name:  "party out of range",
+			text:  "size 1 1\n[terrain]\ng\n[height]\nlegend 0 0.5\n0\n[party]\n6\n",
+			error: `line 9: unknown party '6'`,
+		},
+		{
+			name:  "size too large",
+			text:  "size 1025 1\n",
+			error: "line 2: invalid size 1025 1, width and depth must be between 1 and 1024",
+		},
+		{
+			name:  "size zero",
+			text:  "size 0 4\n",
+			error: "line 2: invalid size 0 4, width and depth must be between 1 and 1024",
+		},
+		{
+			name:  "title too long",
+			text:  "title \"" + strings.Repeat("a", MAX_STRING_LENGTH+1) + "\"\n",
+			error: "line 2: title is 256 bytes, longer than 255",
+		},
+		{
+			name:  "city name too long",
+			text:  "size 1 1\n[cities]\n0 0 0 \"" + strings.Repeat("a", MAX_STRING_LENGTH+1) + "\"\n",
+			error: "line 4: city name is 256 bytes, longer than 255",
+		},
+		{
+			name:  "known raw type code",
+			text:  "size 1 1\n[terrain]\ng\n[height]\nlegend 0 0.5\n0\n[raw]\n0 0 type 9\n",
+			error: "line 9: type code 9 must be between 10 and 63",
+		},
+		{
+			name:  "raw type code on a city",
+			text:  "size 1 1\n[terrain]\nC\n[height]\nlegend 0 0.5\n0\n[raw]\n0 0 type 33\n",
+			error: "tile with a raw type code must be grass, got City",
+		},
+		{
+			name:  "raw infantry flag of an army",
+			text:  "size 1 1\n[raw]\n0 0 infantryflag 1\n",
+			error: "line 4: infantry flag 1 must be between 2 and 255",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadTextMap(strings.NewReader(TEXT_MAP_HEADER + "\n" + test.text))
+			if err == nil || err.Error() != test.error {
+				t.Errorf("got error %v, expected %q", err, test.error)
+			}
+		})
+	}
+}
+
+func TestWriteTextMapRejectsUnknownParty(t *testing.T) {
+	mapData := NewHE3Map(1, 1)
+	mapData.At(0, 0).Party = MAX_PARTIES
+	if err := WriteTextMap(&bytes.Buffer{}, mapData); err == nil {
+		t.Error("expected an error for a party the game doesn't have")
+	}
+}
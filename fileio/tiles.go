@@ -0,0 +1,92 @@
+package fileio
+
+import (
+	"io/ioutil"
+	"log"
+)
+
+// At returns the tile at (x,z) for either kind of map. Changing the tile changes the map.
+func (mapData *HE3Map) At(x, z int) *MapTile {
+	if mapData.tiles != nil {
+		return &mapData.tiles[x*int(mapData.Depth)+z]
+	}
+	return mapData.MapTiles[x][z]
+}
+
+// Set replaces the tile at (x,z) with a copy of tile. The armies are shared with tile.
+func (mapData *HE3Map) Set(x, z int, tile MapTile) {
+	if mapData.tiles != nil {
+		mapData.tiles[x*int(mapData.Depth)+z] = tile
+		return
+	}
+	mapData.MapTiles[x][z] = &tile
+}
+
+// IsCompact reports whether the tiles are stored in one flat slice instead of MapTiles
+func (mapData *HE3Map) IsCompact() bool {
+	return mapData.tiles != nil
+}
+
+// Compact moves the tiles into one flat slice and the armies into another. Large maps
+// then need a few allocations instead of one per tile and army. MapTiles is nil afterwards,
+// and pointers to the old tiles no longer change the map.
+func (mapData *HE3Map) Compact() {
+	if mapData.tiles != nil {
+		return
+	}
+	tiles := make([]MapTile, int(mapData.Width)*int(mapData.Depth))
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			tiles[x*int(mapData.Depth)+z] = *mapData.MapTiles[x][z]
+		}
+	}
+	packArmies(tiles)
+	mapData.tiles = tiles
+	mapData.MapTiles = nil
+}
+
+// packArmies copies the armies of the tiles into one slice and points the tiles at the copies
+func packArmies(tiles []MapTile) {
+	count := 0
+	for i := range tiles {
+		if tiles[i].Infantry != nil {
+			count++
+		}
+		if tiles[i].Artillery != nil {
+			count++
+		}
+	}
+	armies := make([]Army, 0, count)
+	for i := range tiles {
+		if tiles[i].Infantry != nil {
+			armies = append(armies, *tiles[i].Infantry)
+			tiles[i].Infantry = &armies[len(armies)-1]
+		}
+		if tiles[i].Artillery != nil {
+			armies = append(armies, *tiles[i].Artillery)
+			tiles[i].Artillery = &armies[len(armies)-1]
+		}
+	}
+}
+
+// DeserializeCompact reads a map like Deserialize and returns it compact
+func DeserializeCompact(content []byte) (*HE3Map, error) {
+	inputData, err := DecodeData(content)
+	if err != nil {
+		return nil, err
+	}
+	return deserializeData(inputData, true)
+}
+
+func ReadCompactHE3Map(filename string) *HE3Map {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal("Failed to load map: ", err)
+	}
+
+	mapData, err := DeserializeCompact(content)
+	if err != nil {
+		log.Fatal("Failed to read map: ", err)
+	}
+	return mapData
+}
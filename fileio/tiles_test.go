@@ -0,0 +1,235 @@
+package fileio
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+)
+
+func TestAtAndSet(t *testing.T) {
+	grid := NewHE3Map(3, 2)
+	compact := NewHE3Map(3, 2)
+	compact.Compact()
+	for _, mapData := range []*HE3Map{grid, compact} {
+		tile := MapTile{TileType: City, CityName: "Paris", Party: 2, HasInfantry: true, Infantry: &Army{X: 2, Y: 1, UnitInfantry: 10}}
+		mapData.Set(2, 1, tile)
+		tile.CityName = "Lyon"
+		got := mapData.At(2, 1)
+		if got.CityName != "Paris" || got.Party != 2 || got.Infantry.UnitInfantry != 10 {
+			t.Errorf("compact=%v: got %+v after Set", mapData.IsCompact(), got)
+		}
+
+		// At returns the tile in the map, not a copy
+		mapData.At(0, 1).Party = 4
+		if mapData.At(0, 1).Party != 4 {
+			t.Errorf("compact=%v: changing the tile from At didn't change the map", mapData.IsCompact())
+		}
+		if mapData.At(1, 1).Party != -1 || mapData.At(0, 0).Party != -1 {
+			t.Errorf("compact=%v: Set changed other tiles", mapData.IsCompact())
+		}
+	}
+
+	gridData, err := SerializeData(grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compactData, err := SerializeData(compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gridData, compactData) {
+		t.Error("grid and compact maps with the same tiles saved differently")
+	}
+}
+
+func TestCompactKeepsTiles(t *testing.T) {
+	for _, content := range bundledMaps(t) {
+		mapData, err := Deserialize(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compact, err := DeserializeCompact(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mapData.IsCompact() || !compact.IsCompact() || compact.MapTiles != nil {
+			t.Fatal("expected a grid map and a compact map")
+		}
+		expected, err := SerializeData(mapData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compacted := mapData.Clone()
+		compacted.Compact()
+		for _, other := range []*HE3Map{compact, compacted} {
+			saved, err := SerializeData(other)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(saved, expected) {
+				t.Errorf("%s: compact map saved differently", mapData.MapTitle)
+			}
+		}
+
+		// Clones of compact maps have their own armies
+		clone := compact.Clone()
+		for x := 0; x < int(clone.Width); x++ {
+			for z := 0; z < int(clone.Depth); z++ {
+				if army := clone.At(x, z).Infantry; army != nil {
+					army.UnitInfantry++
+				}
+			}
+		}
+		saved, err := SerializeData(compact)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(saved, expected) {
+			t.Errorf("%s: changing a clone changed the compact map", mapData.MapTitle)
+		}
+	}
+}
+
+func TestTransformsWorkOnCompactMaps(t *testing.T) {
+	for _, content := range bundledMaps(t) {
+		grid, err := Deserialize(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compact, err := DeserializeCompact(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transforms := map[string]func(mapData *HE3Map) (*HE3Map, error){
+			"crop":      func(mapData *HE3Map) (*HE3Map, error) { return Crop(mapData, 2, 2, 10, 8) },
+			"rotate180": Rotate180,
+			"resample":  func(mapData *HE3Map) (*HE3Map, error) { return Resample(mapData, 20, 16) },
+		}
+		for name, transform := range transforms {
+			fromGrid, err := transform(grid)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			fromCompact, err := transform(compact)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			gridData, err := SerializeData(fromGrid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compactData, err := SerializeData(fromCompact)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(gridData, compactData) {
+				t.Errorf("%s: %s of the compact map differs from the grid map", grid.MapTitle, name)
+			}
+		}
+	}
+}
+
+// reportRetained reports the heap the decoded map keeps alive, which is what large maps
+// cost after decoding
+func reportRetained(b *testing.B, decode func([]byte) (*HE3Map, error), content []byte) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	mapData, err := decode(content)
+	if err != nil {
+		b.Fatal(err)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(mapData)
+	b.ReportMetric(float64(after.HeapAlloc)-float64(before.HeapAlloc), "retained-B")
+}
+
+// BenchmarkDecodeTiles compares decoding into MapTiles and into compact storage
+func BenchmarkDecodeTiles(b *testing.B) {
+	for _, benchmark := range benchmarkMaps(b) {
+		content := benchmark.content
+		b.Run(benchmark.name+"/Grid", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Deserialize(content); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			reportRetained(b, Deserialize, content)
+		})
+		b.Run(benchmark.name+"/Compact", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := DeserializeCompact(content); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			reportRetained(b, DeserializeCompact, content)
+		})
+	}
+}
+
+// BenchmarkVisitTiles compares reading every tile and its neighbors through At, as the
+// renderers and analyses do, for both kinds of map
+func BenchmarkVisitTiles(b *testing.B) {
+	for _, benchmark := range benchmarkMaps(b) {
+		mapData, err := Deserialize(benchmark.content)
+		if err != nil {
+			b.Fatal(err)
+		}
+		compact := mapData.Clone()
+		compact.Compact()
+		for _, layout := range []struct {
+			name    string
+			mapData *HE3Map
+		}{{"Grid", mapData}, {"Compact", compact}} {
+			b.Run(benchmark.name+"/"+layout.name, func(b *testing.B) {
+				visitTiles(b, layout.mapData)
+			})
+		}
+	}
+}
+
+func visitTiles(b *testing.B, mapData *HE3Map) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		coast := 0
+		for x := 1; x < int(mapData.Width)-1; x++ {
+			for z := 1; z < int(mapData.Depth)-1; z++ {
+				if mapData.At(x, z).IsSea {
+					continue
+				}
+				if mapData.At(x-1, z).IsSea || mapData.At(x+1, z).IsSea ||
+					mapData.At(x, z-1).IsSea || mapData.At(x, z+1).IsSea {
+					coast++
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkSerializeTiles compares saving both kinds of map
+func BenchmarkSerializeTiles(b *testing.B) {
+	for _, benchmark := range benchmarkMaps(b) {
+		mapData, err := Deserialize(benchmark.content)
+		if err != nil {
+			b.Fatal(err)
+		}
+		compact := mapData.Clone()
+		compact.Compact()
+		for _, layout := range []struct {
+			name    string
+			mapData *HE3Map
+		}{{"Grid", mapData}, {"Compact", compact}} {
+			b.Run(benchmark.name+"/"+layout.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					SerializeData(layout.mapData)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package fileio
+
+import (
+	"fmt"
+	"math"
+)
+
+// Odd z rows are drawn half a hex to the right of even rows. Transforms keep every tile's
+// neighbors, so mirroring moves odd rows one tile relative to even rows. When that would
+// push a land tile off the map, the transform returns an error and the map should be
+// padded with sea first.
+
+// CopyMetadata creates an empty map of the given size with the title, author, style and
+// file version of mapData. Trailing data is only kept if it is the zero padding the game
+// saves with, because other trailing data, such as a saved game, describes the original
+// tiles. The game state flag is cleared for the same reason.
+func CopyMetadata(mapData *HE3Map, width int32, depth int32) *HE3Map {
+	newMap := NewHE3Map(width, depth)
+	newMap.MapTitle = mapData.MapTitle
+	newMap.MapAuthor = mapData.MapAuthor
+	newMap.MapStyle = mapData.MapStyle
+	if mapData.Version != 0 {
+		newMap.Version = mapData.Version
+	}
+	if len(mapData.Trailing) > 0 && isZeroPadding(mapData.Trailing) {
+		newMap.Trailing = make([]byte, len(mapData.Trailing))
+	}
+	return newMap
+}
+
+// placeTile copies a tile into the map at (x, z) and moves its armies with it
+func placeTile(mapData *HE3Map, x int, z int, tile *MapTile) {
+	placed := tile.Clone()
+	for _, army := range []*Army{placed.Infantry, placed.Artillery} {
+		if army != nil {
+			army.X = int32(x)
+			army.Y = int32(z)
+		}
+	}
+	mapData.Set(x, z, *placed)
+}
+
+func (mapData *HE3Map) contains(x int, z int) bool {
+	return x >= 0 && z >= 0 && x < int(mapData.Width) && z < int(mapData.Depth)
+}
+
+// moveTiles builds a map of the given size with every tile moved to position(x, z).
+// It fails if a land tile would be moved outside the map.
+func moveTiles(mapData *HE3Map, width int32, depth int32, name string, position func(x int, z int) (int, int)) (*HE3Map, error) {
+	newMap := CopyMetadata(mapData, width, depth)
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			tile := mapData.At(x, z)
+			newX, newZ := position(x, z)
+			if !newMap.contains(newX, newZ) {
+				if !tile.IsSea {
+					return nil, fmt.Errorf("%s would move land tile (%d,%d) off the map, pad the map with sea first", name, x, z)
+				}
+				continue
+			}
+			placeTile(newMap, newX, newZ, tile)
+		}
+	}
+	return newMap, nil
+}
+
+// Crop returns the width x depth rectangle that starts at (x, z). z must be even so that
+// rows keep their offset.
+func Crop(mapData *HE3Map, x int32, z int32, width int32, depth int32) (*HE3Map, error) {
+	if width <= 0 || depth <= 0 {
+		return nil, fmt.Errorf("invalid crop size %dx%d", width, depth)
+	}
+	// Compared as int64 so that large sizes can't overflow
+	if x < 0 || z < 0 || int64(x)+int64(width) > int64(mapData.Width) || int64(z)+int64(depth) > int64(mapData.Depth) {
+		return nil, fmt.Errorf("crop %dx%d at (%d,%d) is outside the %dx%d map", width, depth, x, z, mapData.Width, mapData.Depth)
+	}
+	if z%2 != 0 {
+		return nil, fmt.Errorf("crop must start on an even row, got z=%d", z)
+	}
+	newMap := CopyMetadata(mapData, width, depth)
+	for newX := 0; newX < int(width); newX++ {
+		for newZ := 0; newZ < int(depth); newZ++ {
+			placeTile(newMap, newX, newZ, mapData.At(newX+int(x), newZ+int(z)))
+		}
+	}
+	return newMap, nil
+}
+
+// Pad adds sea around the map. bottom must be even so that rows keep their offset.
+func Pad(mapData *HE3Map, left int32, right int32, bottom int32, top int32) (*HE3Map, error) {
+	if left < 0 || right < 0 || bottom < 0 || top < 0 {
+		return nil, fmt.Errorf("padding can't be negative")
+	}
+	if bottom%2 != 0 {
+		return nil, fmt.Errorf("bottom padding must be even, got %d", bottom)
+	}
+	width := int64(mapData.Width) + int64(left) + int64(right)
+	depth := int64(mapData.Depth) + int64(bottom) + int64(top)
+	if width > MAX_MAP_DIMENSION || depth > MAX_MAP_DIMENSION {
+		return nil, fmt.Errorf("padded map would be %dx%d, width and depth can be at most %d", width, depth, MAX_MAP_DIMENSION)
+	}
+	return moveTiles(mapData, int32(width), int32(depth), "pad", func(x int, z int) (int, int) {
+		return x + int(left), z + int(bottom)
+	})
+}
+
+// MirrorHorizontal flips the map left to right. Odd rows move one tile further left than
+// even rows, so the last tile of each odd row must be sea.
+func MirrorHorizontal(mapData *HE3Map) (*HE3Map, error) {
+	width := int(mapData.Width)
+	return moveTiles(mapData, mapData.Width, mapData.Depth, "horizontal mirror", func(x int, z int) (int, int) {
+		if z%2 == 1 {
+			return width - 2 - x, z
+		}
+		return width - 1 - x, z
+	})
+}
+
+// MirrorVertical flips the map top to bottom. When the depth is even, rows change between
+// odd and even, so odd rows move one tile right and their last tile must be sea.
+func MirrorVertical(mapData *HE3Map) (*HE3Map, error) {
+	depth := int(mapData.Depth)
+	return moveTiles(mapData, mapData.Width, mapData.Depth, "vertical mirror", func(x int, z int) (int, int) {
+		if depth%2 == 0 && z%2 == 1 {
+			return x + 1, depth - 1 - z
+		}
+		return x, depth - 1 - z
+	})
+}
+
+// Rotate180 turns the map half a turn. This keeps every tile when the depth is even.
+// When the depth is odd, odd rows move one tile left and their last tile must be sea.
+func Rotate180(mapData *HE3Map) (*HE3Map, error) {
+	width := int(mapData.Width)
+	depth := int(mapData.Depth)
+	return moveTiles(mapData, mapData.Width, mapData.Depth, "rotation", func(x int, z int) (int, int) {
+		if depth%2 == 1 && z%2 == 1 {
+			return width - 2 - x, depth - 1 - z
+		}
+		return width - 1 - x, depth - 1 - z
+	})
+}
+
+// resamplePosition scales a tile position between map sizes, using the position the tile
+// is drawn at so that odd rows stay half a hex to the right
+func resamplePosition(x int, z int, fromWidth, fromDepth, toWidth, toDepth int32) (int, int) {
+	drawnX := float64(x) + 0.5*float64(z%2)
+	newZ := int(math.Round((float64(z)+0.5)*float64(toDepth)/float64(fromDepth) - 0.5))
+	newZ = max(0, min(int(toDepth)-1, newZ))
+	newDrawnX := (drawnX+0.5)*float64(toWidth)/float64(fromWidth) - 0.5
+	newX := int(math.Round(newDrawnX - 0.5*float64(newZ%2)))
+	newX = max(0, min(int(toWidth)-1, newX))
+	return newX, newZ
+}
+
+func mergeArmy(army *Army, other *Army) *Army {
+	if army == nil {
+		return other
+	}
+	if other == nil {
+		return army
+	}
+	merged := *army
+	units := float32(army.UnitInfantry + army.UnitArtillery)
+	otherUnits := float32(other.UnitInfantry + other.UnitArtillery)
+	if units+otherUnits > 0 {
+		merged.Morale = (army.Morale*units + other.Morale*otherUnits) / (units + otherUnits)
+	}
+	merged.UnitInfantry += other.UnitInfantry
+	merged.UnitArtillery += other.UnitArtillery
+	return &merged
+}
+
+// Resample scales the map to a new size. Terrain, height, party and roads are taken from
+// the nearest tile. Each settlement and army is placed once at its scaled position.
+// When settlements land on the same tile, the more important one is kept, and armies
+// that land on the same tile are merged.
+func Resample(mapData *HE3Map, width int32, depth int32) (*HE3Map, error) {
+	if width <= 0 || depth <= 0 || width > MAX_MAP_DIMENSION || depth > MAX_MAP_DIMENSION {
+		return nil, fmt.Errorf("invalid map size %dx%d, width and depth must be between 1 and %d", width, depth, MAX_MAP_DIMENSION)
+	}
+	newMap := CopyMetadata(mapData, width, depth)
+	for newX := 0; newX < int(width); newX++ {
+		for newZ := 0; newZ < int(depth); newZ++ {
+			x, z := resamplePosition(newX, newZ, width, depth, mapData.Width, mapData.Depth)
+			source := mapData.At(x, z)
+			tile := &MapTile{HasRoad: source.HasRoad, Party: source.Party}
+			tile.SetHeight(source.Height)
+			if source.TileType < Airport {
+				tile.TileType = source.TileType
+			}
+			newMap.Set(newX, newZ, *tile)
+		}
+	}
+
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			source := mapData.At(x, z)
+			if source.TileType < Airport && source.Infantry == nil && source.Artillery == nil {
+				continue
+			}
+			newX, newZ := resamplePosition(x, z, mapData.Width, mapData.Depth, width, depth)
+			tile := newMap.At(newX, newZ)
+			if tile.IsSea {
+				// Keep settlements and armies on land
+				tile.SetHeight(source.Height)
+				if source.TileType < Airport {
+					tile.TileType = source.TileType
+				}
+			}
+			if source.Infantry != nil || source.Artillery != nil {
+				tile.Party = source.Party
+			}
+			if source.TileType >= Airport && (tile.TileType < Airport || source.HasFlag ||
+				(!tile.HasFlag && source.TileType > tile.TileType)) {
+				tile.SetHeight(source.Height)
+				tile.TileType = source.TileType
+				tile.CityName = source.CityName
+				tile.HasFlag = source.HasFlag
+				tile.HasRoad = source.HasRoad
+				tile.Party = source.Party
+			}
+			tile.Infantry = mergeArmy(tile.Infantry, source.Infantry)
+			tile.Artillery = mergeArmy(tile.Artillery, source.Artillery)
+			tile.HasInfantry = tile.Infantry != nil
+			tile.HasArtillery = tile.Artillery != nil
+			placeTile(newMap, newX, newZ, tile)
+		}
+	}
+	return newMap, nil
+}
@@ -0,0 +1,59 @@
+package fileio
+
+import "testing"
+
+func TestTransformsKeepVersionAndPadding(t *testing.T) {
+	mapData := NewHE3Map(4, 4)
+	mapData.Version = 4
+	mapData.Trailing = make([]byte, 3)
+
+	rotated, err := Rotate180(mapData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Version != 4 || len(rotated.Trailing) != 3 {
+		t.Errorf("got version %d and %d trailing bytes, expected version 4 and 3 bytes", rotated.Version, len(rotated.Trailing))
+	}
+
+	// A saved game describes the original tiles
+	mapData.GameState = 1
+	mapData.Trailing = []byte{1, 2, 3}
+	cropped, err := Crop(mapData, 0, 0, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cropped.GameState != 0 || len(cropped.Trailing) != 0 {
+		t.Errorf("got game state %d and %d trailing bytes, expected neither", cropped.GameState, len(cropped.Trailing))
+	}
+
+	newer := NewHE3Map(2, 2)
+	stitched, err := Stitch([]StitchPiece{{Map: mapData}, {Map: newer, X: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stitched.Version != MAP_VERSION {
+		t.Errorf("stitched map has version %d, expected %d", stitched.Version, MAP_VERSION)
+	}
+}
+
+func TestTransformsRejectSizesBeyondTheLargestMap(t *testing.T) {
+	mapData := NewHE3Map(4, 4)
+	if _, err := Crop(mapData, 1, 0, 1<<31-1, 1); err == nil {
+		t.Error("expected an error for a crop that overflows the map")
+	}
+	if _, err := Pad(mapData, 1<<31-1, 1, 0, 0); err == nil {
+		t.Error("expected an error for padding that overflows the map size")
+	}
+	if _, err := Pad(mapData, MAX_MAP_DIMENSION-4, 1, 0, 0); err == nil {
+		t.Error("expected an error for padding beyond the largest map")
+	}
+	if _, err := Pad(mapData, MAX_MAP_DIMENSION-4, 0, 0, 0); err != nil {
+		t.Error(err)
+	}
+	if _, err := Resample(mapData, MAX_MAP_DIMENSION+1, 4); err == nil {
+		t.Error("expected an error for resampling beyond the largest map")
+	}
+	if _, err := Resample(mapData, 4, MAX_MAP_DIMENSION+1); err == nil {
+		t.Error("expected an error for resampling beyond the largest map")
+	}
+}
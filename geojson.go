@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// GeoReference places the hex grid on real coordinates. The origin is the
+// bottom left corner of the rendered map, HexSize is the hex radius in the
+// output units and Rotation turns the grid counterclockwise around the origin.
+type GeoReference struct {
+	OriginX  float64
+	OriginY  float64
+	HexSize  float64
+	Rotation float64 // degrees
+}
+
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+type GeoJSONArmy struct {
+	X             int32   `json:"x"`
+	Y             int32   `json:"y"`
+	UnitInfantry  int32   `json:"infantry"`
+	UnitArtillery int32   `json:"artillery"`
+	Morale        float32 `json:"morale"`
+}
+
+// parseGeoOrigin parses an origin written as "x,y", such as "longitude,latitude"
+func parseGeoOrigin(text string) (float64, float64, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("origin %q must be written as x,y", text)
+	}
+	x, errX := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	y, errY := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if errX != nil || errY != nil {
+		return 0, 0, fmt.Errorf("origin %q must be written as x,y", text)
+	}
+	return x, y, nil
+}
+
+// transform converts a position in rendered map units, with y pointing north,
+// into the georeferenced coordinates
+func (geoReference GeoReference) transform(mapX, mapY float64) [2]float64 {
+	scale := geoReference.HexSize / HexRadius
+	angle := geoReference.Rotation * math.Pi / 180
+	x := mapX * scale
+	y := mapY * scale
+	return [2]float64{
+		geoReference.OriginX + x*math.Cos(angle) - y*math.Sin(angle),
+		geoReference.OriginY + x*math.Sin(angle) + y*math.Cos(angle),
+	}
+}
+
+// hexPolygon returns the closed outer ring of the hex at (x, z) in counterclockwise order
+func (geoReference GeoReference) hexPolygon(x, z int) [][][2]float64 {
+	centerX, centerY := getImagePosition(z, x)
+	ring := make([][2]float64, 0, 7)
+	for i := 0; i <= 6; i++ {
+		angle := math.Pi/2 + float64(i%6)*math.Pi/3
+		ring = append(ring, geoReference.transform(centerX+HexRadius*math.Cos(angle), centerY+HexRadius*math.Sin(angle)))
+	}
+	return [][][2]float64{ring}
+}
+
+func newGeoJSONArmy(army *fileio.Army) *GeoJSONArmy {
+	if army == nil {
+		return nil
+	}
+	return &GeoJSONArmy{
+		X:             army.X,
+		Y:             army.Y,
+		UnitInfantry:  army.UnitInfantry,
+		UnitArtillery: army.UnitArtillery,
+		Morale:        army.Morale,
+	}
+}
+
+// exportGeoJSON creates a polygon feature for every hex and a point feature for every city
+func exportGeoJSON(mapData *fileio.HE3Map, geoReference GeoReference) *GeoJSONFeatureCollection {
+	collection := &GeoJSONFeatureCollection{
+		Type:     "FeatureCollection",
+		Features: []GeoJSONFeature{},
+	}
+	cities := []GeoJSONFeature{}
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			tile := mapData.At(x, z)
+			collection.Features = append(collection.Features, GeoJSONFeature{
+				Type: "Feature",
+				Geometry: GeoJSONGeometry{
+					Type:        "Polygon",
+					Coordinates: geoReference.hexPolygon(x, z),
+				},
+				Properties: map[string]interface{}{
+					"kind":      "hex",
+					"x":         x,
+					"z":         z,
+					"type":      tile.TileType.String(),
+					"height":    tile.Height,
+					"sea":       tile.IsSea,
+					"mountain":  tile.IsMountain,
+					"party":     tile.Party,
+					"road":      tile.HasRoad,
+					"flag":      tile.HasFlag,
+					"city":      tile.CityName,
+					"infantry":  newGeoJSONArmy(tile.Infantry),
+					"artillery": newGeoJSONArmy(tile.Artillery),
+				},
+			})
+
+			if tile.TileType < fileio.Airport {
+				continue
+			}
+			centerX, centerY := getImagePosition(z, x)
+			cities = append(cities, GeoJSONFeature{
+				Type: "Feature",
+				Geometry: GeoJSONGeometry{
+					Type:        "Point",
+					Coordinates: geoReference.transform(centerX, centerY),
+				},
+				Properties: map[string]interface{}{
+					"kind":    "city",
+					"x":       x,
+					"z":       z,
+					"name":    tile.CityName,
+					"type":    tile.TileType.String(),
+					"party":   tile.Party,
+					"capital": tile.HasFlag,
+				},
+			})
+		}
+	}
+	collection.Features = append(collection.Features, cities...)
+	return collection
+}
+
+// newGeoReference checks the GeoJSON settings from the command line
+func newGeoReference(origin string, hexSize, rotation float64) (GeoReference, error) {
+	originX, originY, err := parseGeoOrigin(origin)
+	if err != nil {
+		return GeoReference{}, err
+	}
+	if hexSize <= 0 {
+		return GeoReference{}, fmt.Errorf("hex size must be positive, got %v", hexSize)
+	}
+	return GeoReference{
+		OriginX:  originX,
+		OriginY:  originY,
+		HexSize:  hexSize,
+		Rotation: rotation,
+	}, nil
+}
+
+func runGeoJSON(inputFilename, outputFilename, origin string, hexSize, rotation float64) error {
+	geoReference, err := newGeoReference(origin, hexSize, rotation)
+	if err != nil {
+		return err
+	}
+
+	collection := exportGeoJSON(fileio.ReadHE3Map(inputFilename), geoReference)
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	return writeJSON(outputFile, collection)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	// Height of the deepest sea and the highest mountain in imported maps,
+	// which is close to the range used by the bundled maps
+	ImportMinHeight = -0.3
+	ImportMaxHeight = 3.0
+)
+
+type HeightmapSettings struct {
+	Width int32
+	Depth int32
+	// Gray levels between 0 and 1. Pixels at or below SeaLevel become sea and
+	// pixels at or above MountainLevel become mountains.
+	SeaLevel      float64
+	MountainLevel float64
+}
+
+func readImage(filename string) (image.Image, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// sampleHex averages the pixels under the hex at (x, z). The image is laid out
+// like the rendered map, with z = 0 at the bottom of the image.
+func sampleHex(img image.Image, x, z int, width, depth int32) (r, g, b float64) {
+	bounds := img.Bounds()
+	mapWidth, mapHeight := getImagePosition(int(depth), int(width))
+	centerX, centerY := getImagePosition(z, x)
+	scaleX := float64(bounds.Dx()) / mapWidth
+	scaleY := float64(bounds.Dy()) / mapHeight
+
+	minX := bounds.Min.X + int(math.Floor((centerX-HexRadius)*scaleX))
+	maxX := bounds.Min.X + int(math.Ceil((centerX+HexRadius)*scaleX))
+	maxY := bounds.Max.Y - int(math.Floor((centerY-HexRadius)*scaleY))
+	minY := bounds.Max.Y - int(math.Ceil((centerY+HexRadius)*scaleY))
+
+	count := 0.0
+	for py := max(minY, bounds.Min.Y); py < min(maxY, bounds.Max.Y); py++ {
+		for px := max(minX, bounds.Min.X); px < min(maxX, bounds.Max.X); px++ {
+			pr, pg, pb, _ := img.At(px, py).RGBA()
+			r += float64(pr)
+			g += float64(pg)
+			b += float64(pb)
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, 0, 0
+	}
+	return r / count / 0xffff, g / count / 0xffff, b / count / 0xffff
+}
+
+// grayToHeight maps a gray level between 0 and 1 onto the tile heights so that
+// sea level becomes 0 and the mountain level becomes ELEVATION_MOUNTAIN
+func grayToHeight(gray float64, settings HeightmapSettings) float32 {
+	if gray <= settings.SeaLevel {
+		if settings.SeaLevel == 0 {
+			return 0
+		}
+		return float32(ImportMinHeight * (settings.SeaLevel - gray) / settings.SeaLevel)
+	}
+	if gray < settings.MountainLevel {
+		return float32(fileio.ELEVATION_MOUNTAIN * (gray - settings.SeaLevel) / (settings.MountainLevel - settings.SeaLevel))
+	}
+	if settings.MountainLevel >= 1 {
+		return fileio.ELEVATION_MOUNTAIN
+	}
+	return float32(fileio.ELEVATION_MOUNTAIN +
+		(ImportMaxHeight-fileio.ELEVATION_MOUNTAIN)*(gray-settings.MountainLevel)/(1-settings.MountainLevel))
+}
+
+// terrainPalette returns the colors used by getTileColor for the natural tile types
+func terrainPalette() map[fileio.FieldType][3]float64 {
+	palette := map[fileio.FieldType][3]float64{}
+	for _, tileType := range []fileio.FieldType{fileio.Grass, fileio.Sand, fileio.Farmland, fileio.Forest, fileio.Snow} {
+		tile := &fileio.MapTile{TileType: tileType}
+		tile.SetHeight(fileio.ELEVATION_MOUNTAIN / 2)
+		mapData := singleTileMapData(tile)
+		r, g, b := getTileColor(tile, mapData, 0, 0)
+		palette[tileType] = [3]float64{float64(r) / 255, float64(g) / 255, float64(b) / 255}
+	}
+	return palette
+}
+
+// classifyColor returns the tile type whose render color is closest to the given color
+func classifyColor(r, g, b float64, palette map[fileio.FieldType][3]float64) fileio.FieldType {
+	best := fileio.Grass
+	bestDistance := math.Inf(1)
+	for tileType := fileio.Grass; tileType <= fileio.Snow; tileType++ {
+		color := palette[tileType]
+		distance := (r-color[0])*(r-color[0]) + (g-color[1])*(g-color[1]) + (b-color[2])*(b-color[2])
+		if distance < bestDistance {
+			best = tileType
+			bestDistance = distance
+		}
+	}
+	return best
+}
+
+// importHeightmap builds a map from a grayscale elevation image. If colorImage is
+// not nil, the tile types are set from the closest terrain color in the renderer.
+func importHeightmap(heightImage image.Image, colorImage image.Image, settings HeightmapSettings) (*fileio.HE3Map, error) {
+	if settings.Width <= 0 || settings.Depth <= 0 || settings.Width > fileio.MAX_MAP_DIMENSION || settings.Depth > fileio.MAX_MAP_DIMENSION {
+		return nil, fmt.Errorf("invalid map size %dx%d, width and depth must be between 1 and %d",
+			settings.Width, settings.Depth, fileio.MAX_MAP_DIMENSION)
+	}
+	if settings.SeaLevel < 0 || settings.SeaLevel >= settings.MountainLevel || settings.MountainLevel > 1 {
+		return nil, fmt.Errorf("sea level %v and mountain level %v must satisfy 0 <= sea < mountain <= 1",
+			settings.SeaLevel, settings.MountainLevel)
+	}
+
+	mapData := fileio.NewHE3Map(settings.Width, settings.Depth)
+	palette := terrainPalette()
+	for x := 0; x < int(settings.Width); x++ {
+		for z := 0; z < int(settings.Depth); z++ {
+			r, g, b := sampleHex(heightImage, x, z, settings.Width, settings.Depth)
+			// Rec. 601 luma
+			gray := 0.299*r + 0.587*g + 0.114*b
+			tile := mapData.At(x, z)
+			tile.SetHeight(grayToHeight(gray, settings))
+
+			if colorImage != nil {
+				r, g, b := sampleHex(colorImage, x, z, settings.Width, settings.Depth)
+				tile.TileType = classifyColor(r, g, b, palette)
+			}
+		}
+	}
+	return mapData, nil
+}
+
+func runImportHeightmap(heightFilename, colorFilename, outputFilename string, settings HeightmapSettings) error {
+	heightImage, err := readImage(heightFilename)
+	if err != nil {
+		return err
+	}
+
+	var colorImage image.Image
+	if colorFilename != "" {
+		colorImage, err = readImage(colorFilename)
+		if err != nil {
+			return err
+		}
+	}
+
+	mapData, err := importHeightmap(heightImage, colorImage, settings)
+	if err != nil {
+		return err
+	}
+	mapData.MapTitle = strings.TrimSuffix(filepath.Base(heightFilename), filepath.Ext(heightFilename))
+	mapData.MapAuthor = "HexEmpire3Map"
+	return fileio.WriteHE3File(outputFilename, mapData)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"html/template"
+	"io"
+	"os"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+//go:embed assets/viewer.html
+var viewerTemplateText string
+
+var viewerTemplate = template.Must(template.New("viewer").Parse(viewerTemplateText))
+
+type ViewerArmy struct {
+	X             int32   `json:"x"`
+	Y             int32   `json:"y"`
+	UnitInfantry  int32   `json:"infantry"`
+	UnitArtillery int32   `json:"artillery"`
+	Morale        float32 `json:"morale"`
+}
+
+type ViewerTile struct {
+	X          int         `json:"x"`
+	Z          int         `json:"z"`
+	Type       string      `json:"type"`
+	Height     float32     `json:"height"`
+	IsSea      bool        `json:"sea"`
+	IsMountain bool        `json:"mountain"`
+	HasRoad    bool        `json:"road"`
+	HasFlag    bool        `json:"flag"`
+	IsPort     bool        `json:"port"`
+	Party      int         `json:"party"`
+	CityName   string      `json:"city"`
+	Color      [3]int      `json:"color"`
+	Infantry   *ViewerArmy `json:"infantry"`
+	Artillery  *ViewerArmy `json:"artillery"`
+}
+
+type ViewerData struct {
+	Title        string                     `json:"title"`
+	Author       string                     `json:"author"`
+	Width        int                        `json:"width"`
+	Depth        int                        `json:"depth"`
+	HexRadius    float64                    `json:"hexRadius"`
+	NeighborOdd  [6][2]int                  `json:"neighborOdd"`
+	NeighborEven [6][2]int                  `json:"neighborEven"`
+	PartyColors  [fileio.MAX_PARTIES][3]int `json:"partyColors"`
+	Tiles        []ViewerTile               `json:"tiles"`
+}
+
+func newViewerArmy(army *fileio.Army) *ViewerArmy {
+	if army == nil {
+		return nil
+	}
+	return &ViewerArmy{
+		X:             army.X,
+		Y:             army.Y,
+		UnitInfantry:  army.UnitInfantry,
+		UnitArtillery: army.UnitArtillery,
+		Morale:        army.Morale,
+	}
+}
+
+func newViewerData(he3Map *fileio.HE3Map) *ViewerData {
+	mapData := newMapData(he3Map)
+	viewerData := &ViewerData{
+		Title:        he3Map.MapTitle,
+		Author:       he3Map.MapAuthor,
+		Width:        mapData.Width,
+		Depth:        mapData.Depth,
+		HexRadius:    HexRadius,
+		NeighborOdd:  NeighborOdd,
+		NeighborEven: NeighborEven,
+		PartyColors:  PartyColors,
+		Tiles:        make([]ViewerTile, 0, mapData.Width*mapData.Depth),
+	}
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			tile := mapData.At(x, z)
+			r, g, b := getTileColor(tile, mapData, x, z)
+			viewerData.Tiles = append(viewerData.Tiles, ViewerTile{
+				X:          x,
+				Z:          z,
+				Type:       tile.TileType.String(),
+				Height:     tile.Height,
+				IsSea:      tile.IsSea,
+				IsMountain: tile.IsMountain,
+				HasRoad:    tile.HasRoad,
+				HasFlag:    tile.HasFlag,
+				IsPort:     isPort(x, z, mapData),
+				Party:      tile.Party,
+				CityName:   tile.CityName,
+				Color:      [3]int{r, g, b},
+				Infantry:   newViewerArmy(tile.Infantry),
+				Artillery:  newViewerArmy(tile.Artillery),
+			})
+		}
+	}
+	return viewerData
+}
+
+// writeViewer writes a single HTML page with the map data embedded, so it works offline
+func writeViewer(w io.Writer, he3Map *fileio.HE3Map) error {
+	viewerData := newViewerData(he3Map)
+	mapJSON, err := json.Marshal(viewerData)
+	if err != nil {
+		return err
+	}
+	return viewerTemplate.Execute(w, struct {
+		Title   string
+		MapJSON template.JS
+	}{
+		Title:   he3Map.MapTitle,
+		MapJSON: template.JS(mapJSON),
+	})
+}
+
+func runHTMLViewer(inputFilename, outputFilename string) error {
+	outputFile, err := os.Create(outputFilename)
+	if err != nil {
+		return err
+	}
+	defer outputFile.Close()
+	return writeViewer(outputFile, fileio.ReadHE3Map(inputFilename))
+}
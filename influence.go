@@ -0,0 +1,252 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	// InfluenceAlpha is the opacity of the party colors drawn over the map
+	InfluenceAlpha = 120
+	// RoadCost is the cost of moving between two tiles connected by road
+	RoadCost = 0.5
+	// Tiles that are this close to two parties are contested
+	InfluenceTieMargin = 1e-9
+)
+
+var (
+	// TerrainCosts is the cost of moving onto a tile of each type without a road.
+	// Settlements and airports cost the same as grass.
+	TerrainCosts = map[fileio.FieldType]float64{
+		fileio.Grass:    1,
+		fileio.Farmland: 1,
+		fileio.Sand:     1.5,
+		fileio.Forest:   2,
+		fileio.Snow:     2,
+	}
+)
+
+type PartyInfluence struct {
+	Party       int `json:"party"`
+	Settlements int `json:"settlements"`
+	// Tiles closer to this party's settlements than to any other party's
+	Tiles int `json:"tiles"`
+	// Share of all assigned tiles in percent
+	Share float64 `json:"share"`
+	// Average travel cost from the tiles to the nearest settlement of the party
+	AverageCost float64 `json:"averageCost"`
+}
+
+type InfluenceReport struct {
+	Parties []PartyInfluence `json:"parties"`
+	// Tiles that are equally close to two parties
+	Contested int `json:"contested"`
+	// Land tiles that no settlement can reach
+	Unreachable int `json:"unreachable"`
+	// Party that controls each tile, -1 for none, indexed as [x][z]
+	Owners [][]int `json:"-"`
+}
+
+// moveCost returns the cost of moving between neighbors, or false if the move is not possible
+func moveCost(from *fileio.MapTile, to *fileio.MapTile) (float64, bool) {
+	if to.IsSea || to.IsMountain {
+		return 0, false
+	}
+	if shouldDrawRoad(from) && shouldDrawRoad(to) && (from.HasRoad || to.HasRoad) {
+		return RoadCost, true
+	}
+	if cost, ok := TerrainCosts[to.TileType]; ok {
+		return cost, true
+	}
+	return 1, true
+}
+
+type influenceItem struct {
+	x, z int
+	cost float64
+}
+
+type influenceQueue []influenceItem
+
+func (queue influenceQueue) Len() int           { return len(queue) }
+func (queue influenceQueue) Less(i, j int) bool { return queue[i].cost < queue[j].cost }
+func (queue influenceQueue) Swap(i, j int)      { queue[i], queue[j] = queue[j], queue[i] }
+func (queue *influenceQueue) Push(item any)     { *queue = append(*queue, item.(influenceItem)) }
+func (queue *influenceQueue) Pop() any {
+	old := *queue
+	item := old[len(old)-1]
+	*queue = old[:len(old)-1]
+	return item
+}
+
+// travelCosts runs Dijkstra's algorithm from the sources and returns the cheapest cost
+// to reach each tile, or +Inf for tiles the sources can't reach
+func travelCosts(mapData *MapData, sources [][2]int) [][]float64 {
+	costs := make([][]float64, mapData.Width)
+	for x := range costs {
+		costs[x] = make([]float64, mapData.Depth)
+		for z := range costs[x] {
+			costs[x][z] = math.Inf(1)
+		}
+	}
+	queue := &influenceQueue{}
+	for _, source := range sources {
+		costs[source[0]][source[1]] = 0
+		heap.Push(queue, influenceItem{x: source[0], z: source[1]})
+	}
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(influenceItem)
+		if item.cost > costs[item.x][item.z] {
+			continue
+		}
+		tile := mapData.At(item.x, item.z)
+		for _, neighbor := range getNeighbors(item.x, item.z) {
+			newX, newZ := neighbor[0], neighbor[1]
+			if !isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) {
+				continue
+			}
+			step, ok := moveCost(tile, mapData.At(newX, newZ))
+			if !ok {
+				continue
+			}
+			if cost := item.cost + step; cost < costs[newX][newZ] {
+				costs[newX][newZ] = cost
+				heap.Push(queue, influenceItem{x: newX, z: newZ, cost: cost})
+			}
+		}
+	}
+	return costs
+}
+
+// computeInfluence finds the travel cost from the settlements of each party to every
+// tile, and gives each land tile to the party with the cheapest route to it. Tiles that
+// two parties reach at the same cost are contested.
+func computeInfluence(mapData *MapData) *InfluenceReport {
+	sources := make([][][2]int, len(PartyColors))
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			tile := mapData.At(x, z)
+			if isSettlement(tile) && tile.Party >= 0 && tile.Party < len(PartyColors) {
+				sources[tile.Party] = append(sources[tile.Party], [2]int{x, z})
+			}
+		}
+	}
+	settlements := make([]int, len(PartyColors))
+	partyCosts := make([][][]float64, len(PartyColors))
+	for party := range sources {
+		settlements[party] = len(sources[party])
+		if settlements[party] > 0 {
+			partyCosts[party] = travelCosts(mapData, sources[party])
+		}
+	}
+
+	costs := make([][]float64, mapData.Width)
+	owners := make([][]int, mapData.Width)
+	contested := make([][]bool, mapData.Width)
+	for x := range costs {
+		costs[x] = make([]float64, mapData.Depth)
+		owners[x] = make([]int, mapData.Depth)
+		contested[x] = make([]bool, mapData.Depth)
+		for z := range costs[x] {
+			costs[x][z] = math.Inf(1)
+			owners[x][z] = -1
+			for party, partyCost := range partyCosts {
+				if partyCost == nil || math.IsInf(partyCost[x][z], 1) {
+					continue
+				}
+				cost := partyCost[x][z]
+				if math.Abs(cost-costs[x][z]) <= InfluenceTieMargin {
+					contested[x][z] = true
+				} else if cost < costs[x][z] {
+					costs[x][z] = cost
+					owners[x][z] = party
+					contested[x][z] = false
+				}
+			}
+		}
+	}
+
+	report := &InfluenceReport{Parties: []PartyInfluence{}, Owners: owners}
+	tiles := make([]int, len(PartyColors))
+	totalCost := make([]float64, len(PartyColors))
+	assigned := 0
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			if mapData.At(x, z).IsSea {
+				continue
+			}
+			if contested[x][z] {
+				owners[x][z] = -1
+				report.Contested++
+			} else if owners[x][z] < 0 {
+				report.Unreachable++
+			} else {
+				tiles[owners[x][z]]++
+				totalCost[owners[x][z]] += costs[x][z]
+				assigned++
+			}
+		}
+	}
+	for party := range tiles {
+		if settlements[party] == 0 {
+			continue
+		}
+		influence := PartyInfluence{Party: party, Settlements: settlements[party], Tiles: tiles[party]}
+		if assigned > 0 {
+			influence.Share = 100 * float64(tiles[party]) / float64(assigned)
+		}
+		if tiles[party] > 0 {
+			influence.AverageCost = totalCost[party] / float64(tiles[party])
+		}
+		report.Parties = append(report.Parties, influence)
+	}
+	return report
+}
+
+func writeInfluenceText(w io.Writer, report *InfluenceReport) error {
+	fmt.Fprintln(w, "Party  Settlements  Tiles  Share  Average cost")
+	for _, party := range report.Parties {
+		fmt.Fprintf(w, "%5d  %11d  %5d  %4.1f%%  %12.2f\n",
+			party.Party, party.Settlements, party.Tiles, party.Share, party.AverageCost)
+	}
+	_, err := fmt.Fprintf(w, "%d contested tiles, %d unreachable land tiles\n", report.Contested, report.Unreachable)
+	return err
+}
+
+// drawInfluence paints each land tile in the color of the party that controls it
+func drawInfluence(mapData *MapData, report *InfluenceReport, outputFilename string) error {
+	dc := renderMap(mapData, func(dc *gg.Context) {
+		for x := 0; x < mapData.Width; x++ {
+			for z := 0; z < mapData.Depth; z++ {
+				if owner := report.Owners[x][z]; owner >= 0 {
+					color := PartyColors[owner]
+					fillHex(dc, x, z, color[0], color[1], color[2], InfluenceAlpha)
+				}
+			}
+		}
+	})
+	return dc.SavePNG(outputFilename)
+}
+
+func runInfluence(inputFilename, outputFilename, format, reportFilename string) error {
+	mapData := newMapData(fileio.ReadCompactHE3Map(inputFilename))
+	report := computeInfluence(mapData)
+	err := writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, report)
+		}
+		return writeInfluenceText(w, report)
+	})
+	if err != nil {
+		return err
+	}
+	if outputFilename == "" {
+		return nil
+	}
+	return drawInfluence(mapData, report, outputFilename)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+func TestInfluenceOfSymmetricMap(t *testing.T) {
+	// A row of land with a city of each party at its ends, and a dead end at (3,1) to (3,3).
+	// (3,1) touches (3,0) and (4,0), so both cities reach it at the same cost, and the dead
+	// end behind it is contested too.
+	he3Map := fileio.NewHE3Map(8, 4)
+	for x := 0; x < 8; x++ {
+		he3Map.At(x, 0).SetHeight(0.3)
+	}
+	for z := 1; z < 4; z++ {
+		he3Map.At(3, z).SetHeight(0.3)
+	}
+	he3Map.Set(0, 0, fileio.MapTile{TileType: fileio.City, CityName: "West", Party: 0, Height: 0.3})
+	he3Map.Set(7, 0, fileio.MapTile{TileType: fileio.City, CityName: "East", Party: 1, Height: 0.3})
+
+	report := computeInfluence(newMapData(he3Map))
+	if len(report.Parties) != 2 {
+		t.Fatalf("got %d parties, expected 2", len(report.Parties))
+	}
+	for _, party := range report.Parties {
+		if party.Tiles != 4 {
+			t.Errorf("party %d has %d tiles, expected 4", party.Party, party.Tiles)
+		}
+	}
+	if report.Contested != 3 {
+		t.Errorf("got %d contested tiles, expected 3", report.Contested)
+	}
+	for z := 1; z < 4; z++ {
+		if owner := report.Owners[3][z]; owner != -1 {
+			t.Errorf("contested tile (3,%d) is owned by party %d", z, owner)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+
+	"github.com/fogleman/gg"
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	// LandmassAlpha is the opacity of the landmass colors drawn over the map
+	LandmassAlpha = 140
+)
+
+var (
+	// LandmassColors are given to landmasses from largest to smallest, repeating if there are more
+	LandmassColors = [][3]int{
+		{230, 25, 75}, {60, 180, 75}, {255, 225, 25}, {0, 130, 200}, {245, 130, 48},
+		{145, 30, 180}, {70, 240, 240}, {240, 50, 230}, {210, 245, 60}, {250, 190, 212},
+	}
+	LakeColor = [3]int{120, 200, 255}
+)
+
+type LandmassPort struct {
+	Name  string `json:"name"`
+	X     int    `json:"x"`
+	Z     int    `json:"z"`
+	Party int    `json:"party"`
+	// Sea bodies the port is next to
+	Seas []int `json:"seas"`
+}
+
+type Landmass struct {
+	ID    int `json:"id"`
+	Tiles int `json:"tiles"`
+	// Tiles next to at least one sea tile
+	CoastTiles int `json:"coastTiles"`
+	// Number of hex edges between this landmass and the sea
+	Coastline   int            `json:"coastline"`
+	Settlements int            `json:"settlements"`
+	Ports       []LandmassPort `json:"ports"`
+	// Tile count per party, neutral tiles are not counted
+	Parties map[int]int `json:"parties"`
+	// A tile of the landmass, to find it on the map
+	X int `json:"x"`
+	Z int `json:"z"`
+}
+
+type SeaBody struct {
+	ID        int  `json:"id"`
+	Tiles     int  `json:"tiles"`
+	Coastline int  `json:"coastline"`
+	Lake      bool `json:"lake"`
+	X         int  `json:"x"`
+	Z         int  `json:"z"`
+}
+
+// LandmassReport describes the connected land and sea areas of a map. The largest landmass
+// is the mainland and every other landmass counts as an island. Sea that doesn't reach
+// the edge of the map is a lake.
+type LandmassReport struct {
+	Landmasses []Landmass `json:"landmasses"`
+	SeaBodies  []SeaBody  `json:"seaBodies"`
+	Islands    int        `json:"islands"`
+	Lakes      int        `json:"lakes"`
+	Coastline  int        `json:"coastline"`
+	// Landmass or sea body of each tile, indexed as [x][z]
+	LandLabels [][]int `json:"-"`
+	SeaLabels  [][]int `json:"-"`
+}
+
+// labelComponents numbers the connected areas of tiles where include is true, with -1 for
+// other tiles. Areas are numbered in the order they are found.
+func labelComponents(mapData *MapData, include func(tile *fileio.MapTile) bool) ([][]int, int) {
+	labels := make([][]int, mapData.Width)
+	for x := range labels {
+		labels[x] = make([]int, mapData.Depth)
+		for z := range labels[x] {
+			labels[x][z] = -1
+		}
+	}
+	count := 0
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			if labels[x][z] >= 0 || !include(mapData.At(x, z)) {
+				continue
+			}
+			labels[x][z] = count
+			queue := [][2]int{{x, z}}
+			for len(queue) > 0 {
+				current := queue[0]
+				queue = queue[1:]
+				for _, neighbor := range getNeighbors(current[0], current[1]) {
+					newX, newZ := neighbor[0], neighbor[1]
+					if !isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) ||
+						labels[newX][newZ] >= 0 || !include(mapData.At(newX, newZ)) {
+						continue
+					}
+					labels[newX][newZ] = count
+					queue = append(queue, neighbor)
+				}
+			}
+			count++
+		}
+	}
+	return labels, count
+}
+
+// relabelBySize renumbers labels so that the largest area is 0
+func relabelBySize(labels [][]int, sizes []int) ([][]int, []int) {
+	order := make([]int, len(sizes))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return sizes[order[i]] > sizes[order[j]]
+	})
+	newLabel := make([]int, len(sizes))
+	newSizes := make([]int, len(sizes))
+	for i, label := range order {
+		newLabel[label] = i
+		newSizes[i] = sizes[label]
+	}
+	for x := range labels {
+		for z := range labels[x] {
+			if labels[x][z] >= 0 {
+				labels[x][z] = newLabel[labels[x][z]]
+			}
+		}
+	}
+	return labels, newSizes
+}
+
+func componentSizes(labels [][]int, count int) []int {
+	sizes := make([]int, count)
+	for x := range labels {
+		for z := range labels[x] {
+			if labels[x][z] >= 0 {
+				sizes[labels[x][z]]++
+			}
+		}
+	}
+	return sizes
+}
+
+func analyzeLandmasses(mapData *MapData) *LandmassReport {
+	landLabels, landCount := labelComponents(mapData, func(tile *fileio.MapTile) bool { return !tile.IsSea })
+	seaLabels, seaCount := labelComponents(mapData, func(tile *fileio.MapTile) bool { return tile.IsSea })
+	landLabels, landSizes := relabelBySize(landLabels, componentSizes(landLabels, landCount))
+	seaLabels, seaSizes := relabelBySize(seaLabels, componentSizes(seaLabels, seaCount))
+
+	report := &LandmassReport{
+		Landmasses: make([]Landmass, landCount),
+		SeaBodies:  make([]SeaBody, seaCount),
+		LandLabels: landLabels,
+		SeaLabels:  seaLabels,
+	}
+	for i := range report.Landmasses {
+		report.Landmasses[i] = Landmass{ID: i, Tiles: landSizes[i], X: -1, Ports: []LandmassPort{}, Parties: map[int]int{}}
+	}
+	for i := range report.SeaBodies {
+		report.SeaBodies[i] = SeaBody{ID: i, Tiles: seaSizes[i], X: -1, Lake: true}
+	}
+
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			tile := mapData.At(x, z)
+			if tile.IsSea {
+				sea := &report.SeaBodies[seaLabels[x][z]]
+				if sea.X < 0 {
+					sea.X, sea.Z = x, z
+				}
+				if x == 0 || z == 0 || x == mapData.Width-1 || z == mapData.Depth-1 {
+					sea.Lake = false
+				}
+				continue
+			}
+
+			landmass := &report.Landmasses[landLabels[x][z]]
+			if landmass.X < 0 {
+				landmass.X, landmass.Z = x, z
+			}
+			if tile.Party >= 0 {
+				landmass.Parties[tile.Party]++
+			}
+			if isSettlement(tile) {
+				landmass.Settlements++
+			}
+			seas := []int{}
+			for _, neighbor := range getNeighbors(x, z) {
+				newX, newZ := neighbor[0], neighbor[1]
+				if !isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) || !mapData.At(newX, newZ).IsSea {
+					continue
+				}
+				sea := seaLabels[newX][newZ]
+				landmass.Coastline++
+				report.SeaBodies[sea].Coastline++
+				report.Coastline++
+				if !slices.Contains(seas, sea) {
+					seas = append(seas, sea)
+				}
+			}
+			if len(seas) > 0 {
+				landmass.CoastTiles++
+			}
+			if isPort(x, z, mapData) {
+				sort.Ints(seas)
+				landmass.Ports = append(landmass.Ports, LandmassPort{
+					Name:  tile.CityName,
+					X:     x,
+					Z:     z,
+					Party: tile.Party,
+					Seas:  seas,
+				})
+			}
+		}
+	}
+
+	report.Islands = max(0, landCount-1)
+	for _, sea := range report.SeaBodies {
+		if sea.Lake {
+			report.Lakes++
+		}
+	}
+	return report
+}
+
+func writeLandmassText(w io.Writer, report *LandmassReport) error {
+	fmt.Fprintf(w, "%d landmasses (%d islands), %d sea bodies (%d lakes), coastline %d edges\n",
+		len(report.Landmasses), report.Islands, len(report.SeaBodies), report.Lakes, report.Coastline)
+	for _, landmass := range report.Landmasses {
+		kind := "Island"
+		if landmass.ID == 0 {
+			kind = "Mainland"
+		}
+		fmt.Fprintf(w, "%s %d at (%d,%d): %d tiles, %d settlements, coastline %d edges, %d ports\n",
+			kind, landmass.ID, landmass.X, landmass.Z, landmass.Tiles, landmass.Settlements, landmass.Coastline, len(landmass.Ports))
+		for _, port := range landmass.Ports {
+			fmt.Fprintf(w, "  port %s (%d,%d) party %d, seas %v\n", port.Name, port.X, port.Z, port.Party, port.Seas)
+		}
+	}
+	for _, sea := range report.SeaBodies {
+		kind := "Sea"
+		if sea.Lake {
+			kind = "Lake"
+		}
+		fmt.Fprintf(w, "%s %d at (%d,%d): %d tiles, coastline %d edges\n", kind, sea.ID, sea.X, sea.Z, sea.Tiles, sea.Coastline)
+	}
+	return nil
+}
+
+// drawLandmasses colors each landmass and lake and outlines the ports. It doesn't print
+// anything so that the report can be written to stdout.
+func drawLandmasses(mapData *MapData, report *LandmassReport, outputFilename string) error {
+	dc := renderMap(mapData, func(dc *gg.Context) {
+		for x := 0; x < mapData.Width; x++ {
+			for z := 0; z < mapData.Depth; z++ {
+				if label := report.LandLabels[x][z]; label >= 0 {
+					color := LandmassColors[label%len(LandmassColors)]
+					fillHex(dc, x, z, color[0], color[1], color[2], LandmassAlpha)
+				} else if report.SeaBodies[report.SeaLabels[x][z]].Lake {
+					fillHex(dc, x, z, LakeColor[0], LakeColor[1], LakeColor[2], LandmassAlpha)
+				}
+			}
+		}
+		for _, landmass := range report.Landmasses {
+			for _, port := range landmass.Ports {
+				drawHexOutline(dc, port.X, port.Z, 255, 255, 255)
+			}
+		}
+	})
+	return dc.SavePNG(outputFilename)
+}
+
+func runLandmass(inputFilename, outputFilename, format, reportFilename string) error {
+	mapData := newMapData(fileio.ReadCompactHE3Map(inputFilename))
+	report := analyzeLandmasses(mapData)
+	err := writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, report)
+		}
+		return writeLandmassText(w, report)
+	})
+	if err != nil {
+		return err
+	}
+	if outputFilename == "" {
+		return nil
+	}
+	return drawLandmasses(mapData, report, outputFilename)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+func TestAnalyzeLandmasses(t *testing.T) {
+	// A 4x4 mainland with a lake at (1,1), and a two tile island at x=6
+	he3Map := fileio.NewHE3Map(8, 4)
+	for x := 0; x < 4; x++ {
+		for z := 0; z < 4; z++ {
+			he3Map.At(x, z).SetHeight(0.3)
+		}
+	}
+	he3Map.At(1, 1).SetHeight(0)
+	he3Map.At(6, 1).SetHeight(0.3)
+	he3Map.At(6, 2).SetHeight(0.3)
+	he3Map.Set(3, 0, fileio.MapTile{TileType: fileio.Town, CityName: "Harbor", Party: 0, Height: 0.3})
+	he3Map.Set(2, 2, fileio.MapTile{TileType: fileio.Factory, CityName: "Works", Party: 0, Height: 0.3})
+
+	report := analyzeLandmasses(newMapData(he3Map))
+	if len(report.Landmasses) != 2 || report.Islands != 1 {
+		t.Fatalf("got %d landmasses and %d islands, expected 2 and 1", len(report.Landmasses), report.Islands)
+	}
+	mainland, island := report.Landmasses[0], report.Landmasses[1]
+	if mainland.Tiles != 15 || island.Tiles != 2 {
+		t.Errorf("got landmasses of %d and %d tiles, expected 15 and 2", mainland.Tiles, island.Tiles)
+	}
+	// Factories count as settlements, like in the road network
+	if mainland.Settlements != 2 {
+		t.Errorf("mainland has %d settlements, expected 2", mainland.Settlements)
+	}
+	if len(mainland.Ports) != 1 || mainland.Ports[0].Name != "Harbor" {
+		t.Errorf("got ports %+v, expected Harbor", mainland.Ports)
+	}
+	if mainland.Parties[0] != 2 {
+		t.Errorf("party 0 has %d mainland tiles, expected 2", mainland.Parties[0])
+	}
+
+	if len(report.SeaBodies) != 2 || report.Lakes != 1 {
+		t.Fatalf("got %d sea bodies and %d lakes, expected 2 and 1", len(report.SeaBodies), report.Lakes)
+	}
+	lake := report.SeaBodies[1]
+	if !lake.Lake || lake.Tiles != 1 || lake.Coastline != 6 {
+		t.Errorf("got lake %+v, expected a one tile lake with 6 edges of coastline", lake)
+	}
+	if report.Coastline != report.SeaBodies[0].Coastline+lake.Coastline {
+		t.Errorf("map has %d edges of coastline, but the sea bodies have %d and %d",
+			report.Coastline, report.SeaBodies[0].Coastline, lake.Coastline)
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"encoding/base64"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 	"unicode"
 
 	"golang.org/x/text/runes"
@@ -15,12 +19,13 @@ import (
 
 	"github.com/fogleman/gg"
 	"github.com/samuelyuan/HexEmpire3Map/fileio"
+	"github.com/samuelyuan/HexEmpire3Map/sim"
 )
 
 type MapData struct {
-	MapTiles [][]*fileio.MapTile
-	Width    int
-	Depth    int
+	Map   *fileio.HE3Map
+	Width int
+	Depth int
 }
 
 const (
@@ -31,7 +36,7 @@ var (
 	NeighborOdd  = [6][2]int{{-1, 0}, {0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}}
 	NeighborEven = [6][2]int{{-1, 0}, {-1, -1}, {0, -1}, {1, 0}, {0, 1}, {-1, 1}}
 	// PartyColors represents the colors for each faction
-	PartyColors = [6][3]int{
+	PartyColors = [fileio.MAX_PARTIES][3]int{
 		{0, 76, 229},   // Party 0: Bluegaria (Blue)
 		{178, 0, 204},  // Party 1: Violetnam (Purple)
 		{255, 8, 8},    // Party 2: Redosia (Red)
@@ -42,14 +47,25 @@ var (
 )
 
 func readData(filename string) (*MapData, error) {
-	mapTiles := fileio.ReadHE3File(filename)
+	return newMapData(fileio.ReadCompactHE3Map(filename)), nil
+}
 
-	mapData := &MapData{
-		MapTiles: mapTiles,
-		Width:    len(mapTiles),
-		Depth:    len(mapTiles[0]),
+func newMapData(he3Map *fileio.HE3Map) *MapData {
+	return &MapData{
+		Map:   he3Map,
+		Width: int(he3Map.Width),
+		Depth: int(he3Map.Depth),
 	}
-	return mapData, nil
+}
+
+// singleTileMapData wraps a tile in a 1x1 map, for drawing a tile on its own
+func singleTileMapData(tile *fileio.MapTile) *MapData {
+	return newMapData(&fileio.HE3Map{MapTiles: [][]*fileio.MapTile{{tile}}, Width: 1, Depth: 1})
+}
+
+// At returns the tile at (x,z)
+func (mapData *MapData) At(x, z int) *fileio.MapTile {
+	return mapData.Map.At(x, z)
 }
 
 func getNeighbors(x int, z int) [6][2]int {
@@ -71,7 +87,7 @@ func getNeighbors(x int, z int) [6][2]int {
 }
 
 func isPort(x int, z int, mapData *MapData) bool {
-	if mapData.MapTiles[x][z].TileType < fileio.Town {
+	if mapData.At(x, z).TileType < fileio.Town {
 		return false
 	}
 
@@ -80,7 +96,7 @@ func isPort(x int, z int, mapData *MapData) bool {
 		newX := neighbors[i][0]
 		newZ := neighbors[i][1]
 		if newX >= 0 && newZ >= 0 && newX < mapData.Width && newZ < mapData.Depth {
-			if mapData.MapTiles[newX][newZ].IsSea {
+			if mapData.At(newX, newZ).IsSea {
 				return true
 			}
 		}
@@ -148,7 +164,8 @@ func drawMountain(dc *gg.Context, x, y float64) {
 }
 
 func drawCityMarker(dc *gg.Context, x, y float64, tile *fileio.MapTile) {
-	if tile.HasFlag && tile.Party >= 0 {
+	// Parties the game doesn't have are drawn like neutral cities
+	if tile.HasFlag && tile.Party >= 0 && tile.Party < len(PartyColors) {
 		// Draw capital city
 		dc.DrawCircle(x, y, HexRadius/2)
 		dc.SetRGB255(PartyColors[tile.Party][0], PartyColors[tile.Party][1], PartyColors[tile.Party][2])
@@ -166,7 +183,7 @@ func drawTiles(dc *gg.Context, mapData *MapData) {
 			x, y := getImagePosition(i, j)
 			dc.DrawRegularPolygon(6, x, y, HexRadius, math.Pi/2)
 
-			tile := mapData.MapTiles[j][i]
+			tile := mapData.At(j, i)
 			r, g, b := getTileColor(tile, mapData, j, i)
 			dc.SetRGB255(r, g, b)
 			dc.Fill()
@@ -195,7 +212,7 @@ func shouldDrawRoad(tile *fileio.MapTile) bool {
 func drawRoads(dc *gg.Context, mapData *MapData) {
 	for i := 0; i < mapData.Depth; i++ {
 		for j := 0; j < mapData.Width; j++ {
-			if !mapData.MapTiles[j][i].HasRoad {
+			if !mapData.At(j, i).HasRoad {
 				continue
 			}
 
@@ -205,7 +222,7 @@ func drawRoads(dc *gg.Context, mapData *MapData) {
 				newX := neighbors[n][0]
 				newZ := neighbors[n][1]
 				if isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) {
-					neighborTile := mapData.MapTiles[newX][newZ]
+					neighborTile := mapData.At(newX, newZ)
 					if shouldDrawRoad(neighborTile) {
 						x2, y2 := getImagePosition(newZ, newX)
 						dc.SetRGB255(78, 53, 36)
@@ -224,30 +241,66 @@ func drawCityNames(dc *gg.Context, mapData *MapData) {
 		for j := 0; j < mapData.Width; j++ {
 			// Invert depth because the map is inverted
 			x, y := getImagePosition(mapData.Depth-i, j)
-			tile := mapData.MapTiles[j][i]
+			tile := mapData.At(j, i)
 			dc.SetRGB255(255, 255, 255)
 			dc.DrawString(removeAccents(tile.CityName), x-(5.0*float64(len(tile.CityName))/2.0), y-HexRadius*1.5)
 		}
 	}
 }
 
+// drawHexOutline strokes the border of the hex at map position (x, z)
+func drawHexOutline(dc *gg.Context, x, z int, r, g, b int) {
+	imageX, imageY := getImagePosition(z, x)
+	dc.DrawRegularPolygon(6, imageX, imageY, HexRadius-1, math.Pi/2)
+	dc.SetRGB255(r, g, b)
+	dc.SetLineWidth(2)
+	dc.Stroke()
+	dc.SetLineWidth(1)
+}
+
+// fillHex paints a translucent color over the hex at map position (x, z)
+func fillHex(dc *gg.Context, x, z int, r, g, b, a int) {
+	imageX, imageY := getImagePosition(z, x)
+	dc.DrawRegularPolygon(6, imageX, imageY, HexRadius, math.Pi/2)
+	dc.SetRGBA255(r, g, b, a)
+	dc.Fill()
+}
+
 func drawMap(mapData *MapData, outputFilename string) {
-	mapWidth := len(mapData.MapTiles)
-	mapDepth := len(mapData.MapTiles[0])
+	drawMapWithOverlay(mapData, outputFilename, nil)
+}
+
+// drawMapWithOverlay renders the map like drawMap, but calls overlay after the
+// tiles and roads are drawn so that analyses can mark hexes before the city names
+// are written on top. The overlay uses the same inverted coordinates as drawTiles.
+func drawMapWithOverlay(mapData *MapData, outputFilename string, overlay func(dc *gg.Context)) {
+	fmt.Println("Map depth: ", mapData.Depth, ", width: ", mapData.Width)
+	dc := renderMap(mapData, overlay)
+
+	dc.SavePNG(outputFilename)
+	fmt.Println("Saved image to", outputFilename)
+}
 
-	maxImageWidth, maxImageHeight := getImagePosition(mapDepth, mapWidth)
-	dc := gg.NewContext(int(maxImageWidth), int(maxImageHeight))
-	fmt.Println("Map depth: ", mapDepth, ", width: ", mapWidth)
+// renderSize returns the width and height of the image renderMap draws
+func renderSize(mapData *MapData) (int, int) {
+	maxImageWidth, maxImageHeight := getImagePosition(mapData.Depth, mapData.Width)
+	return int(maxImageWidth), int(maxImageHeight)
+}
+
+// renderMap draws the map into a new image. The overlay may be nil.
+func renderMap(mapData *MapData, overlay func(dc *gg.Context)) *gg.Context {
+	dc := gg.NewContext(renderSize(mapData))
 
 	// Need to invert image because the map format is inverted
 	dc.InvertY()
 
 	drawTiles(dc, mapData)
 	drawRoads(dc, mapData)
+	if overlay != nil {
+		overlay(dc)
+	}
 	drawCityNames(dc, mapData)
-
-	dc.SavePNG(outputFilename)
-	fmt.Println("Saved image to", outputFilename)
+	return dc
 }
 
 func printHelp() {
@@ -257,23 +310,114 @@ func printHelp() {
 	fmt.Println("  hexmap -mode=<mode> -input=<input> [-output=<output>]")
 	fmt.Println()
 	fmt.Println("Modes:")
-	fmt.Println("  visualize  - Convert .he3 map file to PNG image")
-	fmt.Println("  decompress - Decompress .he3 file to binary data")
-	fmt.Println("  compress   - Compress binary data to .he3 format")
-	fmt.Println("  help       - Show this help message")
+	fmt.Println("  visualize        - Convert .he3 map file to PNG image")
+	fmt.Println("  decompress       - Decompress .he3 file to binary data")
+	fmt.Println("  compress         - Compress binary data to .he3 format")
+	fmt.Println("  diff             - Compare two .he3 maps tile by tile (-input and -input2)")
+	fmt.Println("  merge            - Three-way merge of map edits (-base, -ours and -theirs)")
+	fmt.Println("  textconv         - Print a line-oriented text form of a .he3 map for git diff")
+	fmt.Println("  totext           - Convert .he3 map file to the editable text map format")
+	fmt.Println("  fromtext         - Convert a text map back to .he3 format")
+	fmt.Println("  import-heightmap - Build a .he3 map from a grayscale elevation image")
+	fmt.Println("  totmx            - Convert .he3 map file to a Tiled TMX map")
+	fmt.Println("  fromtmx          - Convert a Tiled TMX map back to .he3 format")
+	fmt.Println("  geojson          - Export hexes and cities as GeoJSON features")
+	fmt.Println("  html             - Write an interactive offline HTML viewer for a .he3 map")
+	fmt.Println("  validate         - Check that a .he3 map can be saved and loaded by the game")
+	fmt.Println("  serve            - Run an HTTP server that renders, validates and converts uploaded maps")
+	fmt.Println("  tournament       - Play AI matches on a map and report win rates per party")
+	fmt.Println("  simulate         - Play one AI game on a map and save it as a replay")
+	fmt.Println("  record           - Save a sequence of .he3 maps as a replay (-input and extra map arguments)")
+	fmt.Println("  animate          - Render a replay as an animated GIF, or APNG when -output ends in .png")
+	fmt.Println("  transform        - Crop, pad, mirror, rotate or resample a .he3 map (-op)")
+	fmt.Println("  symmetrize       - Copy the designed part of a map to make it symmetric (-symmetry)")
+	fmt.Println("  symmetry-check   - Report tiles that break the symmetry of a map (-symmetry)")
+	fmt.Println("  stitch           - Join .he3 maps given as file@x,z arguments into one map (-parties)")
+	fmt.Println("  landmass         - Report landmasses, islands, sea bodies, coastline and ports, and render them")
+	fmt.Println("  roads            - Check that settlements are connected by road and export the road graph (-dot)")
+	fmt.Println("  strategic        - Report frontlines between parties and chokepoints on land and sea")
+	fmt.Println("  influence        - Give each tile to the party whose settlements reach it first and render the areas")
+	fmt.Println("  roundtrip-check  - Check that every .he3 map in a directory saves back to identical data")
+	fmt.Println("  query            - List the tiles that match a filter expression (-q)")
+	fmt.Println("  batch            - Run a pipeline of operations on many maps from a YAML manifest (-manifest)")
+	fmt.Println("  help             - Show this help message")
 	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  hexmap -mode=visualize -input=maps/Europe.he3 -output=europe.png")
 	fmt.Println("  hexmap -mode=decompress -input=maps/Europe.he3 -output=europe.bin")
 	fmt.Println("  hexmap -mode=compress -input=europe.bin -output=europe_new.he3")
+	fmt.Println("  hexmap -mode=diff -input=old.he3 -input2=new.he3 -format=json -highlight=changes.png")
+	fmt.Println("  hexmap -mode=merge -base=base.he3 -ours=ours.he3 -theirs=theirs.he3 -strategy=report -output=merged.he3")
+	fmt.Println("  hexmap -mode=textconv -input=maps/Europe.he3")
+	fmt.Println("  hexmap -mode=totext -input=maps/Europe.he3 -output=europe.txt")
+	fmt.Println("  hexmap -mode=fromtext -input=europe.txt -output=europe_new.he3")
+	fmt.Println("  hexmap -mode=import-heightmap -input=dem.png -colors=terrain.png -width=60 -depth=60 -output=region.he3")
+	fmt.Println("  hexmap -mode=totmx -input=maps/Europe.he3 -output=europe.tmx")
+	fmt.Println("  hexmap -mode=fromtmx -input=europe.tmx -output=europe_new.he3")
+	fmt.Println("  hexmap -mode=geojson -input=maps/Europe.he3 -origin=-10,30 -hexsize=0.5 -output=europe.geojson")
+	fmt.Println("  hexmap -mode=html -input=maps/Europe.he3 -output=europe.html")
+	fmt.Println("  hexmap -mode=validate -input=maps/Europe.he3 -format=json")
+	fmt.Println("  hexmap -mode=serve -addr=:8080")
+	fmt.Println("  hexmap -mode=tournament -input=maps/Europe.he3 -games=1000")
+	fmt.Println("  hexmap -mode=simulate -input=maps/Europe.he3 -seed=7 -output=europe.he3replay")
+	fmt.Println("  hexmap -mode=record -input=v1.he3 -output=history.he3replay v2.he3 v3.he3")
+	fmt.Println("  hexmap -mode=animate -input=europe.he3replay -delay=200 -output=europe.gif")
+	fmt.Println("  hexmap -mode=transform -input=maps/Europe.he3 -op=crop,rotate180 -rect=0,20,30,30 -output=corner.he3")
+	fmt.Println("  hexmap -mode=symmetrize -input=half.he3 -symmetry=mirror-h -output=full.he3")
+	fmt.Println("  hexmap -mode=symmetry-check -input=full.he3 -symmetry=rotate3 -center=30,30 -highlight=asymmetric.png")
+	fmt.Println("  hexmap -mode=stitch -parties=1:0=-1,1:1=-1 -output=theatre.he3 maps/Europe.he3 maps/India.he3@60,0")
+	fmt.Println("  hexmap -mode=landmass -input=maps/Europe.he3 -format=json -output=landmasses.png")
+	fmt.Println("  hexmap -mode=roads -input=maps/Europe.he3 -dot=roads.dot -highlight=roads.png")
+	fmt.Println("  hexmap -mode=strategic -input=maps/Europe.he3 -format=json -output=strategic.png")
+	fmt.Println("  hexmap -mode=influence -input=maps/Europe.he3 -output=influence.png")
+	fmt.Println("  hexmap -mode=roundtrip-check -input=maps")
+	fmt.Println("  hexmap -mode=query -input=maps/Europe.he3 -q='type=Factory and party=2 and sea_distance<=5' -highlight=factories.png")
+	fmt.Println("  hexmap -mode=batch -manifest=jobs.yaml -concurrency=8")
+	fmt.Println("  hexmap -mode=visualize -input=draft.he3 -output=draft.png -watch")
 	fmt.Println()
 }
 
 func main() {
-	availableModes := "[visualize, decompress, compress, help]"
+	availableModes := "[visualize, decompress, compress, diff, merge, textconv, totext, fromtext, import-heightmap, totmx, fromtmx, geojson, html, validate, serve, tournament, simulate, record, animate, transform, symmetrize, symmetry-check, stitch, landmass, roads, strategic, influence, roundtrip-check, query, batch, help]"
 	modePtr := flag.String("mode", "", "Available modes: "+availableModes)
 	inputPtr := flag.String("input", "", "Input filename")
+	input2Ptr := flag.String("input2", "", "Second input filename for modes that compare maps")
 	outputPtr := flag.String("output", "output.png", "Output filename")
+	formatPtr := flag.String("format", "text", "Report format: [text, json]")
+	reportPtr := flag.String("report", "", "Report filename (defaults to stdout)")
+	highlightPtr := flag.String("highlight", "", "Optional PNG filename that highlights the reported hexes")
+	basePtr := flag.String("base", "", "Common ancestor map for merge")
+	oursPtr := flag.String("ours", "", "Our version of the map for merge")
+	theirsPtr := flag.String("theirs", "", "Their version of the map for merge")
+	strategyPtr := flag.String("strategy", MergeReport, "Merge conflict strategy: [ours, theirs, report]")
+	widthPtr := flag.Int("width", 60, "Map width for modes that create or resample a map")
+	depthPtr := flag.Int("depth", 60, "Map depth for modes that create or resample a map")
+	seaLevelPtr := flag.Float64("sealevel", 0.25, "Gray level (0-1) at or below which heightmap pixels become sea")
+	mountainLevelPtr := flag.Float64("mountain", 0.75, "Gray level (0-1) at or above which heightmap pixels become mountains")
+	colorsPtr := flag.String("colors", "", "Optional color image used to classify tile types")
+	originPtr := flag.String("origin", "0,0", "GeoJSON coordinates of the bottom left corner of the map as x,y")
+	hexSizePtr := flag.Float64("hexsize", 1, "GeoJSON hex radius in output coordinate units")
+	rotationPtr := flag.Float64("rotation", 0, "GeoJSON counterclockwise rotation in degrees")
+	addrPtr := flag.String("addr", ":8080", "Address for the HTTP server to listen on")
+	maxUploadPtr := flag.Int64("maxupload", 8<<20, "Largest map upload accepted by the HTTP server in bytes")
+	timeoutPtr := flag.Duration("timeout", 30*time.Second, "Time limit for each HTTP request")
+	maxPixelsPtr := flag.Int64("maxpixels", 16<<20, "Largest image the HTTP server renders in pixels")
+	concurrencyPtr := flag.Int("concurrency", runtime.NumCPU(), "Number of uploads the HTTP server processes, tournament games played, or batch maps processed at the same time")
+	gamesPtr := flag.Int("games", 100, "Number of games to play in a tournament")
+	turnsPtr := flag.Int("turns", 200, "Turn limit for each tournament game")
+	seedPtr := flag.Int64("seed", 1, "Random seed for the first tournament game")
+	opPtr := flag.String("op", "", "Comma separated transforms: [crop, pad, mirror-h, mirror-v, rotate180, resample]")
+	rectPtr := flag.String("rect", "", "Crop rectangle as x,z,width,depth")
+	padPtr := flag.String("pad", "0,0,0,0", "Sea padding as left,right,bottom,top")
+	delayPtr := flag.Int("delay", 500, "Milliseconds between frames of an animation")
+	symmetryPtr := flag.String("symmetry", "", "Map symmetry: [mirror-h, mirror-v, rotate180, rotate3, rotate6]")
+	centerPtr := flag.String("center", "", "Center tile as x,z for rotate3 and rotate6 (defaults to the middle of the map)")
+	partiesPtr := flag.String("parties", "", "Party mapping for stitch as map:party=newparty, comma separated")
+	dotPtr := flag.String("dot", "", "Optional GraphViz DOT filename for the road graph")
+	watchPtr := flag.Bool("watch", false, "Convert the input again whenever it changes, for visualize, decompress, totext, geojson and html")
+	manifestPtr := flag.String("manifest", "", "YAML manifest of inputs and operations for batch")
+	queryPtr := flag.String("q", "", "Tile filter expression for query, such as 'type in (City,Capital) and party=2'")
+	playersPtr := flag.String("players", strings.Join(sim.Strategies, ","), "Comma separated AI strategies that take turns playing each party")
 	flag.Parse()
 
 	mode := *modePtr
@@ -288,9 +432,28 @@ func main() {
 		return
 	}
 
-	fmt.Println("Mode: ", mode)
-	fmt.Println("Input filename: ", inputFilename)
-	fmt.Println("Output filename: ", outputFilename)
+	// Report modes print to stdout, so they skip the banner to keep the output parseable
+	reportModes := map[string]bool{"diff": true, "merge": true, "textconv": true, "validate": true, "tournament": true, "symmetry-check": true, "landmass": true, "roads": true, "strategic": true, "influence": true, "roundtrip-check": true, "query": true, "batch": true}
+	if !reportModes[mode] {
+		fmt.Println("Mode: ", mode)
+		fmt.Println("Input filename: ", inputFilename)
+		fmt.Println("Output filename: ", outputFilename)
+	}
+
+	if *watchPtr {
+		geoReference, err := newGeoReference(*originPtr, *hexSizePtr, *rotationPtr)
+		if err != nil {
+			log.Fatal("Invalid GeoJSON settings: ", err)
+		}
+		convert, err := newWatchConverter(mode, geoReference)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runWatch(inputFilename, outputFilename, convert); err != nil {
+			log.Fatal("Failed to watch map: ", err)
+		}
+		return
+	}
 
 	if mode == "visualize" {
 		mapData, err := readData(inputFilename)
@@ -314,6 +477,210 @@ func main() {
 		if err != nil {
 			log.Fatal("Failed to write to output file: ", err)
 		}
+	} else if mode == "diff" {
+		err := runDiff(inputFilename, *input2Ptr, *formatPtr, *reportPtr, *highlightPtr)
+		if err != nil {
+			log.Fatal("Failed to diff maps: ", err)
+		}
+	} else if mode == "merge" {
+		hasConflicts, err := runMerge(*basePtr, *oursPtr, *theirsPtr, outputFilename, *strategyPtr, *formatPtr, *reportPtr)
+		if err != nil {
+			log.Fatal("Failed to merge maps: ", err)
+		}
+		if hasConflicts {
+			// Non-zero exit status tells git that the merge driver left conflicts
+			os.Exit(1)
+		}
+	} else if mode == "textconv" {
+		err := writeCanonicalText(os.Stdout, fileio.ReadHE3Map(inputFilename))
+		if err != nil {
+			log.Fatal("Failed to write text: ", err)
+		}
+	} else if mode == "totext" {
+		outputFile, err := os.Create(outputFilename)
+		if err != nil {
+			log.Fatal("Failed to create output file: ", err)
+		}
+		defer outputFile.Close()
+		if err := fileio.WriteTextMap(outputFile, fileio.ReadHE3Map(inputFilename)); err != nil {
+			log.Fatal("Failed to write text map: ", err)
+		}
+	} else if mode == "fromtext" {
+		inputFile, err := os.Open(inputFilename)
+		if err != nil {
+			log.Fatal("Failed to read input file: ", err)
+		}
+		defer inputFile.Close()
+		mapData, err := fileio.ReadTextMap(inputFile)
+		if err != nil {
+			log.Fatal("Failed to parse text map: ", err)
+		}
+		if err := fileio.WriteHE3File(outputFilename, mapData); err != nil {
+			log.Fatal("Failed to write to output file: ", err)
+		}
+	} else if mode == "import-heightmap" {
+		settings := HeightmapSettings{
+			Width:         int32(*widthPtr),
+			Depth:         int32(*depthPtr),
+			SeaLevel:      *seaLevelPtr,
+			MountainLevel: *mountainLevelPtr,
+		}
+		if err := runImportHeightmap(inputFilename, *colorsPtr, outputFilename, settings); err != nil {
+			log.Fatal("Failed to import heightmap: ", err)
+		}
+	} else if mode == "totmx" {
+		if err := exportTmx(fileio.ReadHE3Map(inputFilename), outputFilename); err != nil {
+			log.Fatal("Failed to export TMX map: ", err)
+		}
+	} else if mode == "fromtmx" {
+		mapData, err := importTmx(inputFilename)
+		if err != nil {
+			log.Fatal("Failed to import TMX map: ", err)
+		}
+		if err := fileio.WriteHE3File(outputFilename, mapData); err != nil {
+			log.Fatal("Failed to write to output file: ", err)
+		}
+	} else if mode == "geojson" {
+		if err := runGeoJSON(inputFilename, outputFilename, *originPtr, *hexSizePtr, *rotationPtr); err != nil {
+			log.Fatal("Failed to export GeoJSON: ", err)
+		}
+	} else if mode == "html" {
+		if err := runHTMLViewer(inputFilename, outputFilename); err != nil {
+			log.Fatal("Failed to write HTML viewer: ", err)
+		}
+	} else if mode == "validate" {
+		report := validateMap(fileio.ReadHE3Map(inputFilename))
+		err := writeReport(*reportPtr, func(w io.Writer) error {
+			if *formatPtr == "json" {
+				return writeJSON(w, report)
+			}
+			return writeValidationText(w, report)
+		})
+		if err != nil {
+			log.Fatal("Failed to write validation report: ", err)
+		}
+		if !report.Valid {
+			os.Exit(1)
+		}
+	} else if mode == "serve" {
+		config := ServerConfig{
+			MaxUploadBytes:  *maxUploadPtr,
+			Timeout:         *timeoutPtr,
+			MaxConcurrent:   *concurrencyPtr,
+			MaxRenderPixels: *maxPixelsPtr,
+		}
+		if err := runServer(*addrPtr, config); err != nil {
+			log.Fatal("Server failed: ", err)
+		}
+	} else if mode == "tournament" {
+		settings := TournamentSettings{
+			Games:      *gamesPtr,
+			MaxTurns:   *turnsPtr,
+			Seed:       *seedPtr,
+			Strategies: parseStrategies(*playersPtr),
+			Workers:    *concurrencyPtr,
+		}
+		report, err := runTournament(fileio.ReadHE3Map(inputFilename), settings)
+		if err != nil {
+			log.Fatal("Failed to run tournament: ", err)
+		}
+		err = writeReport(*reportPtr, func(w io.Writer) error {
+			if *formatPtr == "json" {
+				return writeJSON(w, report)
+			}
+			return writeTournamentText(w, report)
+		})
+		if err != nil {
+			log.Fatal("Failed to write tournament report: ", err)
+		}
+	} else if mode == "simulate" {
+		err := runSimulate(inputFilename, outputFilename, parseStrategies(*playersPtr), *seedPtr, *turnsPtr)
+		if err != nil {
+			log.Fatal("Failed to simulate game: ", err)
+		}
+	} else if mode == "record" {
+		inputFilenames := flag.Args()
+		if inputFilename != "" {
+			inputFilenames = append([]string{inputFilename}, inputFilenames...)
+		}
+		if err := runRecord(inputFilenames, outputFilename); err != nil {
+			log.Fatal("Failed to record replay: ", err)
+		}
+	} else if mode == "animate" {
+		if err := runAnimate(inputFilename, outputFilename, *delayPtr); err != nil {
+			log.Fatal("Failed to animate replay: ", err)
+		}
+	} else if mode == "transform" {
+		settings := TransformSettings{
+			Operations: *opPtr,
+			Rect:       *rectPtr,
+			Pad:        *padPtr,
+			Width:      int32(*widthPtr),
+			Depth:      int32(*depthPtr),
+		}
+		if err := runTransform(inputFilename, outputFilename, settings); err != nil {
+			log.Fatal("Failed to transform map: ", err)
+		}
+	} else if mode == "symmetrize" {
+		if err := runSymmetrize(inputFilename, outputFilename, *symmetryPtr, *centerPtr); err != nil {
+			log.Fatal("Failed to symmetrize map: ", err)
+		}
+	} else if mode == "symmetry-check" {
+		symmetric, err := runSymmetryCheck(inputFilename, *symmetryPtr, *centerPtr, *formatPtr, *reportPtr, *highlightPtr)
+		if err != nil {
+			log.Fatal("Failed to check symmetry: ", err)
+		}
+		if !symmetric {
+			os.Exit(1)
+		}
+	} else if mode == "stitch" {
+		arguments := flag.Args()
+		if inputFilename != "" {
+			arguments = append([]string{inputFilename}, arguments...)
+		}
+		if err := runStitch(arguments, *partiesPtr, outputFilename); err != nil {
+			log.Fatal("Failed to stitch maps: ", err)
+		}
+	} else if mode == "landmass" {
+		if err := runLandmass(inputFilename, outputFilename, *formatPtr, *reportPtr); err != nil {
+			log.Fatal("Failed to analyze landmasses: ", err)
+		}
+	} else if mode == "roads" {
+		connected, err := runRoads(inputFilename, *dotPtr, *formatPtr, *reportPtr, *highlightPtr)
+		if err != nil {
+			log.Fatal("Failed to check roads: ", err)
+		}
+		if !connected {
+			os.Exit(1)
+		}
+	} else if mode == "strategic" {
+		if err := runStrategic(inputFilename, outputFilename, *formatPtr, *reportPtr); err != nil {
+			log.Fatal("Failed to analyze map: ", err)
+		}
+	} else if mode == "influence" {
+		if err := runInfluence(inputFilename, outputFilename, *formatPtr, *reportPtr); err != nil {
+			log.Fatal("Failed to compute influence: ", err)
+		}
+	} else if mode == "roundtrip-check" {
+		passed, err := runRoundTripCheck(inputFilename, *formatPtr, *reportPtr)
+		if err != nil {
+			log.Fatal("Failed to check round trip: ", err)
+		}
+		if !passed {
+			os.Exit(1)
+		}
+	} else if mode == "query" {
+		if err := runQuery(inputFilename, *queryPtr, *formatPtr, *reportPtr, *highlightPtr); err != nil {
+			log.Fatal("Failed to run query: ", err)
+		}
+	} else if mode == "batch" {
+		passed, err := runBatch(*manifestPtr, *concurrencyPtr, *formatPtr, *reportPtr)
+		if err != nil {
+			log.Fatal("Failed to run batch: ", err)
+		}
+		if !passed {
+			os.Exit(1)
+		}
 	} else {
 		fmt.Println("Invalid mode. One of the following modes are supported " + availableModes)
 		fmt.Println("Use -mode=help for usage information")
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+func TestRenderCapitalOfUnknownParty(t *testing.T) {
+	he3Map := fileio.NewHE3Map(2, 2)
+	for x := 0; x < 2; x++ {
+		for z := 0; z < 2; z++ {
+			he3Map.At(x, z).SetHeight(0.3)
+		}
+	}
+	// Decodes fine, but the game has no party 7 to color it with
+	he3Map.Set(1, 1, fileio.MapTile{TileType: fileio.City, CityName: "Paris", Party: 7, HasFlag: true, Height: 0.3})
+	mapData := newMapData(he3Map)
+
+	renderMap(mapData, nil)
+	if err := writeSVG(io.Discard, mapData); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	// MergeOurs resolves conflicting tiles with our version
+	MergeOurs = "ours"
+	// MergeTheirs resolves conflicting tiles with their version
+	MergeTheirs = "theirs"
+	// MergeReport keeps our version of conflicting tiles and reports the merge as failed
+	MergeReport = "report"
+)
+
+type TileConflict struct {
+	X      int           `json:"x"`
+	Z      int           `json:"z"`
+	Ours   []FieldChange `json:"ours"`
+	Theirs []FieldChange `json:"theirs"`
+}
+
+type MetadataConflict struct {
+	Field  string `json:"field"`
+	Base   string `json:"base"`
+	Ours   string `json:"ours"`
+	Theirs string `json:"theirs"`
+}
+
+type MergeResult struct {
+	Merged *fileio.HE3Map `json:"-"`
+	// Number of tiles taken from each side without a conflict
+	OursTiles         int                `json:"oursTiles"`
+	TheirsTiles       int                `json:"theirsTiles"`
+	Conflicts         []TileConflict     `json:"conflicts"`
+	MetadataConflicts []MetadataConflict `json:"metadataConflicts"`
+}
+
+func (result *MergeResult) HasConflicts() bool {
+	return len(result.Conflicts) > 0 || len(result.MetadataConflicts) > 0
+}
+
+// mergeValue picks the side that changed the value. The last return value is
+// true if both sides changed it to different values, in which case ours is returned.
+func mergeValue[T comparable](base, ours, theirs T) (T, bool) {
+	if ours == theirs || theirs == base {
+		return ours, false
+	}
+	if ours == base {
+		return theirs, false
+	}
+	return ours, true
+}
+
+func mergeMetadata(base, ours, theirs *fileio.HE3Map, strategy string, result *MergeResult) {
+	merged := result.Merged
+
+	title, conflict := mergeValue(base.MapTitle, ours.MapTitle, theirs.MapTitle)
+	merged.MapTitle = title
+	if conflict {
+		result.MetadataConflicts = append(result.MetadataConflicts,
+			MetadataConflict{"title", base.MapTitle, ours.MapTitle, theirs.MapTitle})
+		if strategy == MergeTheirs {
+			merged.MapTitle = theirs.MapTitle
+		}
+	}
+
+	author, conflict := mergeValue(base.MapAuthor, ours.MapAuthor, theirs.MapAuthor)
+	merged.MapAuthor = author
+	if conflict {
+		result.MetadataConflicts = append(result.MetadataConflicts,
+			MetadataConflict{"author", base.MapAuthor, ours.MapAuthor, theirs.MapAuthor})
+		if strategy == MergeTheirs {
+			merged.MapAuthor = theirs.MapAuthor
+		}
+	}
+
+	style, conflict := mergeValue(base.MapStyle, ours.MapStyle, theirs.MapStyle)
+	merged.MapStyle = style
+	if conflict {
+		result.MetadataConflicts = append(result.MetadataConflicts,
+			MetadataConflict{"style", fmt.Sprint(base.MapStyle), fmt.Sprint(ours.MapStyle), fmt.Sprint(theirs.MapStyle)})
+		if strategy == MergeTheirs {
+			merged.MapStyle = theirs.MapStyle
+		}
+	}
+}
+
+// mergeMaps combines the tile edits made by ours and theirs since base. Tiles
+// changed on only one side are taken from that side. Tiles changed on both sides
+// are a conflict unless both made the same change, and are resolved with strategy.
+func mergeMaps(base, ours, theirs *fileio.HE3Map, strategy string) (*MergeResult, error) {
+	if strategy != MergeOurs && strategy != MergeTheirs && strategy != MergeReport {
+		return nil, fmt.Errorf("unknown merge strategy %q", strategy)
+	}
+	if ours.Width != base.Width || ours.Depth != base.Depth ||
+		theirs.Width != base.Width || theirs.Depth != base.Depth {
+		return nil, fmt.Errorf("map sizes differ: base %dx%d, ours %dx%d, theirs %dx%d",
+			base.Width, base.Depth, ours.Width, ours.Depth, theirs.Width, theirs.Depth)
+	}
+
+	result := &MergeResult{
+		Merged:            ours.Clone(),
+		Conflicts:         []TileConflict{},
+		MetadataConflicts: []MetadataConflict{},
+	}
+	mergeMetadata(base, ours, theirs, strategy, result)
+
+	for x := 0; x < int(base.Width); x++ {
+		for z := 0; z < int(base.Depth); z++ {
+			oursChanges := diffTiles(base.At(x, z), ours.At(x, z))
+			theirsChanges := diffTiles(base.At(x, z), theirs.At(x, z))
+			if len(theirsChanges) == 0 {
+				if len(oursChanges) > 0 {
+					result.OursTiles++
+				}
+				continue
+			}
+			if len(oursChanges) == 0 {
+				result.Merged.Set(x, z, *theirs.At(x, z).Clone())
+				result.TheirsTiles++
+				continue
+			}
+			if len(diffTiles(ours.At(x, z), theirs.At(x, z))) == 0 {
+				// Both sides made the same edit
+				continue
+			}
+
+			result.Conflicts = append(result.Conflicts, TileConflict{
+				X:      x,
+				Z:      z,
+				Ours:   oursChanges,
+				Theirs: theirsChanges,
+			})
+			if strategy == MergeTheirs {
+				result.Merged.Set(x, z, *theirs.At(x, z).Clone())
+			}
+		}
+	}
+	return result, nil
+}
+
+func writeMergeText(w io.Writer, result *MergeResult) error {
+	fmt.Fprintln(w, "Tiles merged from ours:", result.OursTiles)
+	fmt.Fprintln(w, "Tiles merged from theirs:", result.TheirsTiles)
+	for _, conflict := range result.MetadataConflicts {
+		fmt.Fprintf(w, "CONFLICT %s: base %q, ours %q, theirs %q\n",
+			conflict.Field, conflict.Base, conflict.Ours, conflict.Theirs)
+	}
+	for _, conflict := range result.Conflicts {
+		fmt.Fprintf(w, "CONFLICT (%d,%d)\n", conflict.X, conflict.Z)
+		for _, change := range conflict.Ours {
+			fmt.Fprintf(w, "  ours   %s: %q -> %q\n", change.Field, change.Old, change.New)
+		}
+		for _, change := range conflict.Theirs {
+			fmt.Fprintf(w, "  theirs %s: %q -> %q\n", change.Field, change.Old, change.New)
+		}
+	}
+	_, err := fmt.Fprintln(w, "Conflicts:", len(result.Conflicts)+len(result.MetadataConflicts))
+	return err
+}
+
+// runMerge writes the merged map to outputFilename and returns true if the
+// merge had conflicts that were not resolved by the strategy.
+func runMerge(baseFilename, oursFilename, theirsFilename, outputFilename, strategy, format, reportFilename string) (bool, error) {
+	base := fileio.ReadHE3Map(baseFilename)
+	ours := fileio.ReadHE3Map(oursFilename)
+	theirs := fileio.ReadHE3Map(theirsFilename)
+
+	result, err := mergeMaps(base, ours, theirs, strategy)
+	if err != nil {
+		return false, err
+	}
+
+	err = writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, result)
+		}
+		return writeMergeText(w, result)
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if err := fileio.WriteHE3File(outputFilename, result.Merged); err != nil {
+		return false, err
+	}
+	return strategy == MergeReport && result.HasConflicts(), nil
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/fogleman/gg"
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// Kinds of values a query field holds
+const (
+	QueryNumber = iota
+	QueryString
+	QueryBool
+	// QueryType is a tile type, compared by name or in the order Grass, Sand, ... Capital
+	QueryType
+)
+
+// queryTile is the tile a query is evaluated on
+type queryTile struct {
+	x, z        int
+	tile        *fileio.MapTile
+	mapData     *MapData
+	seaDistance [][]int
+}
+
+type queryValue struct {
+	number  float64
+	text    string
+	boolean bool
+}
+
+type queryField struct {
+	kind  int
+	value func(t *queryTile) queryValue
+}
+
+func numberValue(number float64) queryValue { return queryValue{number: number} }
+func boolValue(boolean bool) queryValue     { return queryValue{boolean: boolean} }
+
+// QueryFields are the tile properties a query can test
+var QueryFields = map[string]queryField{
+	"x":         {QueryNumber, func(t *queryTile) queryValue { return numberValue(float64(t.x)) }},
+	"z":         {QueryNumber, func(t *queryTile) queryValue { return numberValue(float64(t.z)) }},
+	"type":      {QueryType, func(t *queryTile) queryValue { return numberValue(float64(t.tile.TileType)) }},
+	"party":     {QueryNumber, func(t *queryTile) queryValue { return numberValue(float64(t.tile.Party)) }},
+	"height":    {QueryNumber, func(t *queryTile) queryValue { return numberValue(float64(t.tile.Height)) }},
+	"name":      {QueryString, func(t *queryTile) queryValue { return queryValue{text: t.tile.CityName} }},
+	"road":      {QueryBool, func(t *queryTile) queryValue { return boolValue(t.tile.HasRoad) }},
+	"flag":      {QueryBool, func(t *queryTile) queryValue { return boolValue(t.tile.HasFlag) }},
+	"sea":       {QueryBool, func(t *queryTile) queryValue { return boolValue(t.tile.IsSea) }},
+	"mountain":  {QueryBool, func(t *queryTile) queryValue { return boolValue(t.tile.IsMountain) }},
+	"port":      {QueryBool, func(t *queryTile) queryValue { return boolValue(isPort(t.x, t.z, t.mapData)) }},
+	"infantry":  {QueryBool, func(t *queryTile) queryValue { return boolValue(t.tile.Infantry != nil) }},
+	"artillery": {QueryBool, func(t *queryTile) queryValue { return boolValue(t.tile.Artillery != nil) }},
+	// Number of hexes to the nearest sea tile, 0 for sea and -1 if the map has no sea
+	"sea_distance": {QueryNumber, func(t *queryTile) queryValue { return numberValue(float64(t.seaDistance[t.x][t.z])) }},
+}
+
+// QueryOperators are the comparisons a query can make. Boolean fields only allow = and !=.
+var QueryOperators = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// tileQuery reports whether a tile matches
+type tileQuery func(t *queryTile) bool
+
+type queryToken struct {
+	text     string
+	position int
+	// quoted is true for string literals, which are never keywords or operators
+	quoted bool
+}
+
+type queryParser struct {
+	tokens []queryToken
+	next   int
+}
+
+func tokenizeQuery(text string) ([]queryToken, error) {
+	tokens := []queryToken{}
+	for i := 0; i < len(text); {
+		char := rune(text[i])
+		switch {
+		case unicode.IsSpace(char):
+			i++
+		case char == '"' || char == '\'':
+			end := strings.IndexByte(text[i+1:], text[i])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i+1)
+			}
+			tokens = append(tokens, queryToken{text: text[i+1 : i+1+end], position: i + 1, quoted: true})
+			i += end + 2
+		case strings.ContainsRune("(),", char):
+			tokens = append(tokens, queryToken{text: string(char), position: i + 1})
+			i++
+		case strings.ContainsRune("=!<>", char):
+			start := i
+			i++
+			if i < len(text) && text[i] == '=' {
+				i++
+			}
+			if text[start:i] == "!" {
+				return nil, fmt.Errorf("expected != at %d", start+1)
+			}
+			tokens = append(tokens, queryToken{text: text[start:i], position: start + 1})
+		case char == '-' || char == '.' || char == '_' || unicode.IsLetter(char) || unicode.IsDigit(char):
+			start := i
+			for i < len(text) && (text[i] == '-' || text[i] == '.' || text[i] == '_' ||
+				unicode.IsLetter(rune(text[i])) || unicode.IsDigit(rune(text[i]))) {
+				i++
+			}
+			tokens = append(tokens, queryToken{text: text[start:i], position: start + 1})
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", char, i+1)
+		}
+	}
+	return tokens, nil
+}
+
+// parseQuery compiles a filter expression such as
+// "type in (City, Capital) and party=2 and height>0.3"
+func parseQuery(text string) (tileQuery, error) {
+	tokens, err := tokenizeQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("query is empty")
+	}
+	parser := &queryParser{tokens: tokens}
+	query, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.next < len(tokens) {
+		return nil, parser.errorf("unexpected %q", tokens[parser.next].text)
+	}
+	return query, nil
+}
+
+func (parser *queryParser) errorf(format string, args ...any) error {
+	if parser.next >= len(parser.tokens) {
+		return fmt.Errorf(format+" at the end of the query", args...)
+	}
+	return fmt.Errorf(format+" at %d", append(args, parser.tokens[parser.next].position)...)
+}
+
+// peek returns the next token, or an empty token at the end of the query
+func (parser *queryParser) peek() queryToken {
+	if parser.next >= len(parser.tokens) {
+		return queryToken{}
+	}
+	return parser.tokens[parser.next]
+}
+
+// accept consumes the next token if it is the keyword or symbol
+func (parser *queryParser) accept(text string) bool {
+	token := parser.peek()
+	if token.quoted || token.text == "" || !strings.EqualFold(token.text, text) {
+		return false
+	}
+	parser.next++
+	return true
+}
+
+func (parser *queryParser) parseOr() (tileQuery, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for parser.accept("or") {
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		first := left
+		left = func(t *queryTile) bool { return first(t) || right(t) }
+	}
+	return left, nil
+}
+
+func (parser *queryParser) parseAnd() (tileQuery, error) {
+	left, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for parser.accept("and") {
+		right, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		first := left
+		left = func(t *queryTile) bool { return first(t) && right(t) }
+	}
+	return left, nil
+}
+
+func (parser *queryParser) parseNot() (tileQuery, error) {
+	if parser.accept("not") {
+		query, err := parser.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(t *queryTile) bool { return !query(t) }, nil
+	}
+	if parser.accept("(") {
+		query, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !parser.accept(")") {
+			return nil, parser.errorf("expected )")
+		}
+		return query, nil
+	}
+	return parser.parseComparison()
+}
+
+func (parser *queryParser) parseComparison() (tileQuery, error) {
+	token := parser.peek()
+	name := strings.ToLower(token.text)
+	field, ok := QueryFields[name]
+	if token.quoted || !ok {
+		if token.text == "" {
+			return nil, parser.errorf("expected a field")
+		}
+		return nil, parser.errorf("unknown field %q", token.text)
+	}
+	parser.next++
+
+	if parser.accept("in") {
+		if !parser.accept("(") {
+			return nil, parser.errorf("expected ( after in")
+		}
+		values := []queryValue{}
+		for {
+			value, err := parser.parseValue(name, field)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if parser.accept(")") {
+				break
+			}
+			if !parser.accept(",") {
+				return nil, parser.errorf("expected , or )")
+			}
+		}
+		return func(t *queryTile) bool {
+			actual := field.value(t)
+			for _, value := range values {
+				if compareQueryValues(field.kind, actual, value) == 0 {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+
+	operator := parser.peek()
+	if operator.quoted || !QueryOperators[operator.text] {
+		// A boolean field on its own tests whether it is true
+		if field.kind == QueryBool {
+			return func(t *queryTile) bool { return field.value(t).boolean }, nil
+		}
+		return nil, parser.errorf("expected a comparison after %s", name)
+	}
+	if field.kind == QueryBool && operator.text != "=" && operator.text != "!=" {
+		return nil, parser.errorf("%s can only be compared with = or !=", name)
+	}
+	parser.next++
+	value, err := parser.parseValue(name, field)
+	if err != nil {
+		return nil, err
+	}
+	return func(t *queryTile) bool {
+		order := compareQueryValues(field.kind, field.value(t), value)
+		switch operator.text {
+		case "=":
+			return order == 0
+		case "!=":
+			return order != 0
+		case "<":
+			return order < 0
+		case "<=":
+			return order <= 0
+		case ">":
+			return order > 0
+		default:
+			return order >= 0
+		}
+	}, nil
+}
+
+// parseValue reads a literal of the kind the field holds
+func (parser *queryParser) parseValue(name string, field queryField) (queryValue, error) {
+	token := parser.peek()
+	if token.text == "" && !token.quoted {
+		return queryValue{}, parser.errorf("expected a value for %s", name)
+	}
+	switch field.kind {
+	case QueryNumber:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if token.quoted || err != nil {
+			return queryValue{}, parser.errorf("%s needs a number, got %q", name, token.text)
+		}
+		parser.next++
+		// Heights are stored as float32, so 0.3 has to match the height saved as 0.3
+		if name == "height" {
+			number = float64(float32(number))
+		}
+		return numberValue(number), nil
+	case QueryString:
+		parser.next++
+		return queryValue{text: token.text}, nil
+	case QueryBool:
+		boolean, err := strconv.ParseBool(token.text)
+		if token.quoted || err != nil {
+			return queryValue{}, parser.errorf("%s needs true or false, got %q", name, token.text)
+		}
+		parser.next++
+		return boolValue(boolean), nil
+	default:
+		fieldType, ok := fileio.ParseFieldType(token.text)
+		if !ok {
+			return queryValue{}, parser.errorf("unknown tile type %q", token.text)
+		}
+		parser.next++
+		return numberValue(float64(fieldType)), nil
+	}
+}
+
+// compareQueryValues returns -1, 0 or 1 like strings.Compare
+func compareQueryValues(kind int, a queryValue, b queryValue) int {
+	switch kind {
+	case QueryString:
+		return strings.Compare(strings.ToLower(a.text), strings.ToLower(b.text))
+	case QueryBool:
+		if a.boolean == b.boolean {
+			return 0
+		}
+		return 1
+	default:
+		if a.number < b.number {
+			return -1
+		} else if a.number > b.number {
+			return 1
+		}
+		return 0
+	}
+}
+
+// seaDistances returns the number of hexes from each tile to the nearest sea tile,
+// or -1 everywhere if the map has no sea
+func seaDistances(mapData *MapData) [][]int {
+	distances := make([][]int, mapData.Width)
+	queue := [][2]int{}
+	for x := range distances {
+		distances[x] = make([]int, mapData.Depth)
+		for z := range distances[x] {
+			distances[x][z] = -1
+			if mapData.At(x, z).IsSea {
+				distances[x][z] = 0
+				queue = append(queue, [2]int{x, z})
+			}
+		}
+	}
+	for len(queue) > 0 {
+		x, z := queue[0][0], queue[0][1]
+		queue = queue[1:]
+		for _, neighbor := range getNeighbors(x, z) {
+			newX, newZ := neighbor[0], neighbor[1]
+			if isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) && distances[newX][newZ] < 0 {
+				distances[newX][newZ] = distances[x][z] + 1
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	return distances
+}
+
+type QueryMatch struct {
+	X      int     `json:"x"`
+	Z      int     `json:"z"`
+	Type   string  `json:"type"`
+	Party  int     `json:"party"`
+	Height float32 `json:"height"`
+	Name   string  `json:"name,omitempty"`
+}
+
+type QueryReport struct {
+	Query   string       `json:"query"`
+	Matches []QueryMatch `json:"matches"`
+}
+
+func runTileQuery(mapData *MapData, text string) (*QueryReport, error) {
+	query, err := parseQuery(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	report := &QueryReport{Query: text, Matches: []QueryMatch{}}
+	t := &queryTile{mapData: mapData, seaDistance: seaDistances(mapData)}
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			t.x, t.z, t.tile = x, z, mapData.At(x, z)
+			if !query(t) {
+				continue
+			}
+			report.Matches = append(report.Matches, QueryMatch{
+				X:      x,
+				Z:      z,
+				Type:   t.tile.TileType.String(),
+				Party:  t.tile.Party,
+				Height: t.tile.Height,
+				Name:   t.tile.CityName,
+			})
+		}
+	}
+	return report, nil
+}
+
+func writeQueryText(w io.Writer, report *QueryReport) error {
+	fmt.Fprintln(w, "    X      Z  Type      Party  Height  Name")
+	for _, match := range report.Matches {
+		fmt.Fprintf(w, "%5d  %5d  %-8s  %5d  %6.3f  %s\n",
+			match.X, match.Z, match.Type, match.Party, match.Height, match.Name)
+	}
+	_, err := fmt.Fprintf(w, "%d matching tiles\n", len(report.Matches))
+	return err
+}
+
+// drawQueryMatches outlines the matching tiles in yellow
+func drawQueryMatches(mapData *MapData, report *QueryReport, outputFilename string) error {
+	dc := renderMap(mapData, func(dc *gg.Context) {
+		for _, match := range report.Matches {
+			drawHexOutline(dc, match.X, match.Z, 255, 230, 0)
+		}
+	})
+	return dc.SavePNG(outputFilename)
+}
+
+func runQuery(inputFilename, text, format, reportFilename, highlightFilename string) error {
+	mapData := newMapData(fileio.ReadCompactHE3Map(inputFilename))
+	report, err := runTileQuery(mapData, text)
+	if err != nil {
+		return err
+	}
+	err = writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, report)
+		}
+		return writeQueryText(w, report)
+	})
+	if err != nil {
+		return err
+	}
+	if highlightFilename == "" {
+		return nil
+	}
+	return drawQueryMatches(mapData, report, highlightFilename)
+}
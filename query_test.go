@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// queryTestMap is a 4x2 map with sea in the first column:
+//
+//	z=1: sea  Forest(0, infantry)  mountain      Grass(1, road)
+//	z=0: sea  Paris(2, road, flag) Saint-Denis(2) Berlin(1, flag)
+func queryTestMap() *MapData {
+	he3Map := fileio.NewHE3Map(4, 2)
+	for x := 0; x < 4; x++ {
+		for z := 0; z < 2; z++ {
+			he3Map.At(x, z).SetHeight(0.3)
+		}
+	}
+	he3Map.At(0, 0).SetHeight(0)
+	he3Map.At(0, 1).SetHeight(0)
+	he3Map.Set(1, 0, fileio.MapTile{TileType: fileio.City, CityName: "Paris", Party: 2, HasRoad: true, HasFlag: true, Height: 0.3})
+	he3Map.Set(2, 0, fileio.MapTile{TileType: fileio.Town, CityName: "Saint-Denis", Party: 2, Height: 0.3})
+	he3Map.Set(3, 0, fileio.MapTile{TileType: fileio.Capital, CityName: "Berlin", Party: 1, HasFlag: true, Height: 0.3})
+	he3Map.Set(1, 1, fileio.MapTile{TileType: fileio.Forest, Party: 0, Height: 0.3, HasInfantry: true, Infantry: &fileio.Army{X: 1, Y: 1, UnitInfantry: 10}})
+	he3Map.At(2, 1).SetHeight(0.8)
+	he3Map.At(3, 1).Party = 1
+	he3Map.At(3, 1).HasRoad = true
+	return newMapData(he3Map)
+}
+
+func TestQueryMatches(t *testing.T) {
+	mapData := queryTestMap()
+	tests := []struct {
+		query   string
+		matches [][2]int
+	}{
+		// not binds tighter than and, and and binds tighter than or
+		{"not sea and party = 2", [][2]int{{1, 0}, {2, 0}}},
+		{"party = 1 or party = 2 and flag", [][2]int{{1, 0}, {3, 0}, {3, 1}}},
+		{"(party = 1 or party = 2) and flag", [][2]int{{1, 0}, {3, 0}}},
+		{"not party = 2 and not sea", [][2]int{{1, 1}, {2, 1}, {3, 0}, {3, 1}}},
+		{"not (party = 2 or sea)", [][2]int{{1, 1}, {2, 1}, {3, 0}, {3, 1}}},
+		{"not not flag", [][2]int{{1, 0}, {3, 0}}},
+		{"NOT sea AND Party=2", [][2]int{{1, 0}, {2, 0}}},
+		{"x >= 2 and z < 1 or x = 0 and z = 1", [][2]int{{0, 1}, {2, 0}, {3, 0}}},
+
+		{"type in (City, capital)", [][2]int{{1, 0}, {3, 0}}},
+		{"party in (0, 1)", [][2]int{{1, 1}, {3, 0}, {3, 1}}},
+		{"type > Town", [][2]int{{1, 0}, {3, 0}}},
+		{"height > 0.5", [][2]int{{2, 1}}},
+		// Heights are float32, so literals are compared at the same precision
+		{"height = 0.3", [][2]int{{1, 0}, {1, 1}, {2, 0}, {3, 0}, {3, 1}}},
+		{"height > 0.3", [][2]int{{2, 1}}},
+		{"height in (0.3) and party = 1", [][2]int{{3, 0}, {3, 1}}},
+
+		{`name = "Saint-Denis"`, [][2]int{{2, 0}}},
+		{`name = 'paris'`, [][2]int{{1, 0}}},
+		{`name in ("Berlin", 'Paris')`, [][2]int{{1, 0}, {3, 0}}},
+		{`name = "and" or name = "not"`, nil},
+		{`name = "Paris, France"`, nil},
+
+		// A boolean field on its own tests whether it is true
+		{"road", [][2]int{{1, 0}, {3, 1}}},
+		{"road and not flag", [][2]int{{3, 1}}},
+		{"infantry or mountain", [][2]int{{1, 1}, {2, 1}}},
+		{"road = false and sea != true and not mountain", [][2]int{{1, 1}, {2, 0}, {3, 0}}},
+
+		{"sea_distance = 0", [][2]int{{0, 0}, {0, 1}}},
+		{"sea_distance = 1", [][2]int{{1, 0}, {1, 1}}},
+		{"sea_distance >= 3", [][2]int{{3, 0}, {3, 1}}},
+		{"port", [][2]int{{1, 0}}},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			report, err := runTileQuery(mapData, test.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			matches := [][2]int{}
+			for _, match := range report.Matches {
+				matches = append(matches, [2]int{match.X, match.Z})
+			}
+			if test.matches == nil {
+				test.matches = [][2]int{}
+			}
+			if !reflect.DeepEqual(matches, test.matches) {
+				t.Errorf("got %v, expected %v", matches, test.matches)
+			}
+		})
+	}
+}
+
+func TestSeaDistanceWithoutSea(t *testing.T) {
+	mapData := newMapData(fileio.NewHE3Map(3, 3))
+	for x := 0; x < 3; x++ {
+		for z := 0; z < 3; z++ {
+			mapData.At(x, z).SetHeight(0.3)
+		}
+	}
+	report, err := runTileQuery(mapData, "sea_distance = -1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Matches) != 9 {
+		t.Errorf("got %d tiles with sea_distance -1, expected every tile", len(report.Matches))
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		error string
+	}{
+		{"", "query is empty"},
+		{"   ", "query is empty"},
+		{"foo = 1", `unknown field "foo" at 1`},
+		{`"party" = 1`, `unknown field "party" at 1`},
+		{"type in (City, Foo)", `unknown tile type "Foo" at 16`},
+		{"party =", "expected a value for party at the end of the query"},
+		{"party = x", `party needs a number, got "x" at 9`},
+		{`party = "2"`, `party needs a number, got "2" at 9`},
+		{"road > true", "road can only be compared with = or != at 6"},
+		{"road = yes", `road needs true or false, got "yes" at 8`},
+		{"(sea or road", "expected ) at the end of the query"},
+		{"name = 'Paris", "unterminated string at 8"},
+		{"x ! 1", "expected != at 3"},
+		{"x = 1 sea", `unexpected "sea" at 7`},
+		{"x = 1 and", "expected a field at the end of the query"},
+		{"party 2", "expected a comparison after party at 7"},
+		{"party in 1, 2", "expected ( after in at 10"},
+		{"party in (1 2)", "expected , or ) at 13"},
+		{"x = 1 & z = 1", `unexpected character '&' at 7`},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%q", test.query), func(t *testing.T) {
+			_, err := parseQuery(test.query)
+			if err == nil || err.Error() != test.error {
+				t.Errorf("got error %v, expected %q", err, test.error)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+	"github.com/samuelyuan/HexEmpire3Map/sim"
+)
+
+// runRecord saves a sequence of maps as a replay, one frame per map after the first
+func runRecord(inputFilenames []string, outputFilename string) error {
+	if len(inputFilenames) == 0 {
+		return fmt.Errorf("no maps to record")
+	}
+	recorder := fileio.NewReplayRecorder(fileio.ReadHE3Map(inputFilenames[0]))
+	for _, inputFilename := range inputFilenames[1:] {
+		label := strings.TrimSuffix(filepath.Base(inputFilename), filepath.Ext(inputFilename))
+		if err := recorder.Record(label, fileio.ReadHE3Map(inputFilename)); err != nil {
+			return err
+		}
+	}
+	return fileio.WriteReplayFile(outputFilename, recorder.Replay())
+}
+
+// runSimulate plays one AI game on the map and saves a replay with a frame after every round.
+// The strategies take the parties in turn order.
+func runSimulate(inputFilename, outputFilename string, strategies []string, seed int64, maxTurns int) error {
+	if len(strategies) == 0 {
+		return fmt.Errorf("no strategies given")
+	}
+	mapData := fileio.ReadHE3Map(inputFilename)
+	game := sim.NewGame(mapData, seed)
+	var players [sim.MaxParties]sim.AIPlayer
+	for i, party := range game.ActiveParties() {
+		player, err := sim.NewAIPlayer(strategies[i%len(strategies)])
+		if err != nil {
+			return err
+		}
+		players[party] = player
+	}
+
+	recorder := fileio.NewReplayRecorder(game.Map)
+	for !game.IsOver() && game.Turn < maxTurns {
+		turn := game.Turn
+		if err := sim.PlayTurn(game, players[game.CurrentParty]); err != nil {
+			return err
+		}
+		if game.Turn != turn || game.IsOver() {
+			if err := recorder.Record(fmt.Sprintf("Turn %d", turn+1), game.Map); err != nil {
+				return err
+			}
+		}
+	}
+
+	if winner := game.Winner(); winner >= 0 {
+		fmt.Println("Party", winner, "won after", game.Turn, "turns")
+	} else {
+		fmt.Println("No winner after", game.Turn, "turns, settlements per party:", game.Scores())
+	}
+	return fileio.WriteReplayFile(outputFilename, recorder.Replay())
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fogleman/gg"
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	RoadNodeSettlement = "settlement"
+	RoadNodeJunction   = "junction"
+	RoadNodeDeadEnd    = "dead-end"
+)
+
+// RoadNode is a settlement, a junction where three or more roads meet, or the end of a
+// road that doesn't lead to a settlement
+type RoadNode struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+	X    int    `json:"x"`
+	Z    int    `json:"z"`
+	Name string `json:"name,omitempty"`
+	// Tile type of settlements
+	TileType string `json:"tileType,omitempty"`
+	// Connected part of the road network the node belongs to
+	Network int `json:"network"`
+}
+
+// RoadEdge is a stretch of road between two nodes. Length is the number of hexes travelled.
+type RoadEdge struct {
+	From   int `json:"from"`
+	To     int `json:"to"`
+	Length int `json:"length"`
+	// Bridge is true if removing this road splits its network
+	Bridge bool `json:"bridge"`
+	// Tiles along the road, including both nodes
+	Path [][2]int `json:"path"`
+}
+
+type RoadReport struct {
+	Nodes []RoadNode `json:"nodes"`
+	Edges []RoadEdge `json:"edges"`
+	// Number of connected road networks
+	Networks int `json:"networks"`
+	// Settlements that can't reach the network with the most settlements on their landmass.
+	// Settlements on different landmasses can't be connected by road, so they aren't reported.
+	Disconnected []int `json:"disconnected"`
+	DeadEnds     []int `json:"deadEnds"`
+	// Nodes that split their network when removed
+	ArticulationPoints []int `json:"articulationPoints"`
+}
+
+// roadNeighbors returns the tiles connected to (x, z) by road. Like drawRoads, a road runs
+// between two neighbors when both are road tiles and at least one of them has a road.
+func roadNeighbors(mapData *MapData, x, z int) [][2]int {
+	tile := mapData.At(x, z)
+	if !shouldDrawRoad(tile) {
+		return nil
+	}
+	neighbors := [][2]int{}
+	for _, neighbor := range getNeighbors(x, z) {
+		newX, newZ := neighbor[0], neighbor[1]
+		if !isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) {
+			continue
+		}
+		neighborTile := mapData.At(newX, newZ)
+		if shouldDrawRoad(neighborTile) && (tile.HasRoad || neighborTile.HasRoad) {
+			neighbors = append(neighbors, neighbor)
+		}
+	}
+	return neighbors
+}
+
+func isSettlement(tile *fileio.MapTile) bool {
+	return tile.TileType >= fileio.Factory
+}
+
+// buildRoadGraph turns the road tiles into a graph. Every settlement is a node, as are road
+// tiles with one or three or more connections. Roads that form a loop without any node get
+// a junction on their first tile.
+func buildRoadGraph(mapData *MapData) *RoadReport {
+	report := &RoadReport{
+		Nodes:              []RoadNode{},
+		Edges:              []RoadEdge{},
+		Disconnected:       []int{},
+		DeadEnds:           []int{},
+		ArticulationPoints: []int{},
+	}
+	nodeAt := map[[2]int]int{}
+	addNode := func(x, z int, kind string) {
+		tile := mapData.At(x, z)
+		node := RoadNode{ID: len(report.Nodes), Kind: kind, X: x, Z: z}
+		if kind == RoadNodeSettlement {
+			node.Name = tile.CityName
+			node.TileType = tile.TileType.String()
+		}
+		nodeAt[[2]int{x, z}] = node.ID
+		report.Nodes = append(report.Nodes, node)
+	}
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			tile := mapData.At(x, z)
+			if !shouldDrawRoad(tile) {
+				continue
+			}
+			degree := len(roadNeighbors(mapData, x, z))
+			if isSettlement(tile) {
+				addNode(x, z, RoadNodeSettlement)
+			} else if degree >= 3 {
+				addNode(x, z, RoadNodeJunction)
+			} else if degree <= 1 {
+				addNode(x, z, RoadNodeDeadEnd)
+			}
+		}
+	}
+
+	// Follow each road from a node until it reaches the next node
+	visited := map[[2][2]int]bool{}
+	followRoads := func(start [2]int) {
+		for _, next := range roadNeighbors(mapData, start[0], start[1]) {
+			if visited[[2][2]int{start, next}] {
+				continue
+			}
+			path := [][2]int{start}
+			previous, current := start, next
+			for {
+				visited[[2][2]int{previous, current}] = true
+				visited[[2][2]int{current, previous}] = true
+				path = append(path, current)
+				if _, ok := nodeAt[current]; ok {
+					break
+				}
+				// Tiles that aren't nodes have exactly two connections
+				for _, neighbor := range roadNeighbors(mapData, current[0], current[1]) {
+					if neighbor != previous {
+						previous, current = current, neighbor
+						break
+					}
+				}
+			}
+			report.Edges = append(report.Edges, RoadEdge{
+				From:   nodeAt[start],
+				To:     nodeAt[current],
+				Length: len(path) - 1,
+				Path:   path,
+			})
+		}
+	}
+	for i := 0; i < len(report.Nodes); i++ {
+		followRoads([2]int{report.Nodes[i].X, report.Nodes[i].Z})
+	}
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			position := [2]int{x, z}
+			if _, ok := nodeAt[position]; ok || !shouldDrawRoad(mapData.At(x, z)) {
+				continue
+			}
+			neighbors := roadNeighbors(mapData, x, z)
+			if len(neighbors) > 0 && !visited[[2][2]int{position, neighbors[0]}] {
+				addNode(x, z, RoadNodeJunction)
+				followRoads(position)
+			}
+		}
+	}
+
+	landLabels, _ := labelComponents(mapData, func(tile *fileio.MapTile) bool { return !tile.IsSea })
+	analyzeRoadGraph(report, landLabels)
+	return report
+}
+
+// analyzeRoadGraph finds the networks, disconnected settlements, dead ends, bridges
+// and articulation points of the graph
+func analyzeRoadGraph(report *RoadReport, landLabels [][]int) {
+	adjacent := make([][]int, len(report.Nodes))
+	for i, edge := range report.Edges {
+		adjacent[edge.From] = append(adjacent[edge.From], i)
+		if edge.To != edge.From {
+			adjacent[edge.To] = append(adjacent[edge.To], i)
+		}
+	}
+	other := func(edge RoadEdge, node int) int {
+		if edge.From == node {
+			return edge.To
+		}
+		return edge.From
+	}
+
+	// Depth first search with low links, which finds bridges and articulation points
+	order := make([]int, len(report.Nodes))
+	low := make([]int, len(report.Nodes))
+	for i := range order {
+		order[i] = -1
+	}
+	counter := 0
+	articulation := map[int]bool{}
+	var visit func(node, parentEdge, network int)
+	visit = func(node, parentEdge, network int) {
+		report.Nodes[node].Network = network
+		order[node] = counter
+		low[node] = counter
+		counter++
+		children := 0
+		for _, edgeIndex := range adjacent[node] {
+			if edgeIndex == parentEdge {
+				continue
+			}
+			next := other(report.Edges[edgeIndex], node)
+			if order[next] >= 0 {
+				low[node] = min(low[node], order[next])
+				continue
+			}
+			children++
+			visit(next, edgeIndex, network)
+			low[node] = min(low[node], low[next])
+			if low[next] > order[node] {
+				report.Edges[edgeIndex].Bridge = true
+			}
+			if parentEdge >= 0 && low[next] >= order[node] {
+				articulation[node] = true
+			}
+		}
+		if parentEdge < 0 && children > 1 {
+			articulation[node] = true
+		}
+	}
+	for node := range report.Nodes {
+		if order[node] < 0 {
+			visit(node, -1, report.Networks)
+			report.Networks++
+		}
+	}
+
+	// Count the settlements of each network on each landmass
+	settlements := map[[2]int]int{}
+	mainNetwork := map[int]int{}
+	for _, node := range report.Nodes {
+		if node.Kind != RoadNodeSettlement {
+			continue
+		}
+		landmass := landLabels[node.X][node.Z]
+		settlements[[2]int{landmass, node.Network}]++
+		main, ok := mainNetwork[landmass]
+		if !ok || settlements[[2]int{landmass, node.Network}] > settlements[[2]int{landmass, main}] {
+			mainNetwork[landmass] = node.Network
+		}
+	}
+	for _, node := range report.Nodes {
+		if node.Kind == RoadNodeSettlement && node.Network != mainNetwork[landLabels[node.X][node.Z]] {
+			report.Disconnected = append(report.Disconnected, node.ID)
+		}
+		if node.Kind == RoadNodeDeadEnd {
+			report.DeadEnds = append(report.DeadEnds, node.ID)
+		}
+		if articulation[node.ID] {
+			report.ArticulationPoints = append(report.ArticulationPoints, node.ID)
+		}
+	}
+}
+
+func (node RoadNode) String() string {
+	if node.Name != "" {
+		return fmt.Sprintf("%s (%d,%d)", node.Name, node.X, node.Z)
+	}
+	return fmt.Sprintf("%s (%d,%d)", node.Kind, node.X, node.Z)
+}
+
+func writeRoadText(w io.Writer, report *RoadReport) error {
+	bridges := 0
+	for _, edge := range report.Edges {
+		if edge.Bridge {
+			bridges++
+		}
+	}
+	fmt.Fprintf(w, "%d nodes, %d roads, %d networks, %d bridges\n",
+		len(report.Nodes), len(report.Edges), report.Networks, bridges)
+	for _, node := range report.Disconnected {
+		fmt.Fprintf(w, "disconnected: %s\n", report.Nodes[node])
+	}
+	for _, node := range report.DeadEnds {
+		fmt.Fprintf(w, "dead end: %s\n", report.Nodes[node])
+	}
+	for _, node := range report.ArticulationPoints {
+		fmt.Fprintf(w, "articulation point: %s\n", report.Nodes[node])
+	}
+	for _, edge := range report.Edges {
+		if edge.Bridge {
+			fmt.Fprintf(w, "bridge: %s - %s, length %d\n", report.Nodes[edge.From], report.Nodes[edge.To], edge.Length)
+		}
+	}
+	if len(report.Disconnected) == 0 {
+		_, err := fmt.Fprintln(w, "Every settlement is reachable by road")
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d settlements are not reachable by road\n", len(report.Disconnected))
+	return err
+}
+
+// writeRoadDOT writes the graph in GraphViz DOT format. Nodes are pinned to their map
+// position, so "neato -n" draws them like the map.
+func writeRoadDOT(w io.Writer, report *RoadReport) error {
+	fmt.Fprintln(w, "graph roads {")
+	fmt.Fprintln(w, "  node [shape=point];")
+	for _, node := range report.Nodes {
+		x, y := getImagePosition(node.Z, node.X)
+		attributes := fmt.Sprintf("pos=\"%.0f,%.0f!\"", x, y)
+		switch node.Kind {
+		case RoadNodeSettlement:
+			attributes += fmt.Sprintf(", shape=box, label=%q", node.Name)
+		case RoadNodeDeadEnd:
+			attributes += ", color=red"
+		}
+		fmt.Fprintf(w, "  n%d [%s];\n", node.ID, attributes)
+	}
+	for _, edge := range report.Edges {
+		attributes := fmt.Sprintf("label=\"%d\"", edge.Length)
+		if edge.Bridge {
+			attributes += ", color=red"
+		}
+		fmt.Fprintf(w, "  n%d -- n%d [%s];\n", edge.From, edge.To, attributes)
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// drawRoadProblems outlines disconnected settlements in red, dead ends in orange and
+// articulation points in magenta
+func drawRoadProblems(mapData *MapData, report *RoadReport, outputFilename string) error {
+	dc := renderMap(mapData, func(dc *gg.Context) {
+		for _, node := range report.ArticulationPoints {
+			drawHexOutline(dc, report.Nodes[node].X, report.Nodes[node].Z, 255, 0, 255)
+		}
+		for _, node := range report.DeadEnds {
+			drawHexOutline(dc, report.Nodes[node].X, report.Nodes[node].Z, 255, 140, 0)
+		}
+		for _, node := range report.Disconnected {
+			drawHexOutline(dc, report.Nodes[node].X, report.Nodes[node].Z, 255, 0, 0)
+		}
+	})
+	return dc.SavePNG(outputFilename)
+}
+
+// runRoads reports on the road network and writes it as a DOT graph if dotFilename is set.
+// Returns false if some settlements can't be reached by road.
+func runRoads(inputFilename, dotFilename, format, reportFilename, highlightFilename string) (bool, error) {
+	mapData := newMapData(fileio.ReadCompactHE3Map(inputFilename))
+	report := buildRoadGraph(mapData)
+	err := writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, report)
+		}
+		return writeRoadText(w, report)
+	})
+	if err != nil {
+		return false, err
+	}
+	if dotFilename != "" {
+		outputFile, err := os.Create(dotFilename)
+		if err != nil {
+			return false, err
+		}
+		defer outputFile.Close()
+		if err := writeRoadDOT(outputFile, report); err != nil {
+			return false, err
+		}
+		if err := outputFile.Close(); err != nil {
+			return false, err
+		}
+	}
+	if highlightFilename != "" {
+		if err := drawRoadProblems(mapData, report, highlightFilename); err != nil {
+			return false, err
+		}
+	}
+	return len(report.Disconnected) == 0, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+func TestBuildRoadGraph(t *testing.T) {
+	// A road from a town at (0,0) to a factory at (3,0), and a city at (6,0) without roads
+	he3Map := fileio.NewHE3Map(8, 2)
+	for x := 0; x < 8; x++ {
+		for z := 0; z < 2; z++ {
+			he3Map.At(x, z).SetHeight(0.3)
+		}
+	}
+	he3Map.Set(0, 0, fileio.MapTile{TileType: fileio.Town, CityName: "Start", Party: 0, Height: 0.3})
+	he3Map.Set(3, 0, fileio.MapTile{TileType: fileio.Factory, CityName: "Works", Party: 0, Height: 0.3})
+	he3Map.Set(6, 0, fileio.MapTile{TileType: fileio.City, CityName: "Alone", Party: 1, Height: 0.3})
+	he3Map.At(1, 0).HasRoad = true
+	he3Map.At(2, 0).HasRoad = true
+	mapData := newMapData(he3Map)
+
+	report := buildRoadGraph(mapData)
+	kinds := []string{}
+	for _, node := range report.Nodes {
+		kinds = append(kinds, node.Kind+" "+node.Name)
+	}
+	expectedKinds := []string{"settlement Start", "settlement Works", "settlement Alone"}
+	if !reflect.DeepEqual(kinds, expectedKinds) {
+		t.Fatalf("got nodes %v, expected %v", kinds, expectedKinds)
+	}
+	expectedEdges := []RoadEdge{{From: 0, To: 1, Length: 3, Bridge: true, Path: [][2]int{{0, 0}, {1, 0}, {2, 0}, {3, 0}}}}
+	if !reflect.DeepEqual(report.Edges, expectedEdges) {
+		t.Errorf("got edges %+v, expected %+v", report.Edges, expectedEdges)
+	}
+	if report.Networks != 2 {
+		t.Errorf("got %d networks, expected 2", report.Networks)
+	}
+	if !reflect.DeepEqual(report.Disconnected, []int{2}) {
+		t.Errorf("got disconnected settlements %v, expected [2]", report.Disconnected)
+	}
+
+	// The landmass analysis counts the same settlements as the road network
+	if settlements := analyzeLandmasses(mapData).Landmasses[0].Settlements; settlements != 3 {
+		t.Errorf("landmass has %d settlements, expected 3", settlements)
+	}
+}
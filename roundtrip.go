@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+type RoundTripResult struct {
+	Filename string `json:"filename"`
+	OK       bool   `json:"ok"`
+	// Offset of the first byte that differs, or -1
+	Offset  int    `json:"offset"`
+	Message string `json:"message,omitempty"`
+}
+
+type RoundTripReport struct {
+	Passed  int               `json:"passed"`
+	Failed  int               `json:"failed"`
+	Results []RoundTripResult `json:"results"`
+}
+
+// checkRoundTrip reads a map and checks that saving it gives back the same data before compression.
+// The compressed data may differ, because Compress doesn't produce the same output as the game.
+func checkRoundTrip(filename string) RoundTripResult {
+	result := RoundTripResult{Filename: filename, Offset: -1}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	original, err := fileio.DecodeData(bytes.TrimSpace(content))
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	mapData, err := fileio.DeserializeData(original)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	saved, err := fileio.SerializeData(mapData)
+	if err != nil {
+		result.Message = err.Error()
+		return result
+	}
+	if bytes.Equal(original, saved) {
+		result.OK = true
+		return result
+	}
+	result.Offset = min(len(original), len(saved))
+	for i := 0; i < min(len(original), len(saved)); i++ {
+		if original[i] != saved[i] {
+			result.Offset = i
+			break
+		}
+	}
+	result.Message = fmt.Sprintf("saved data differs at byte %d (%d bytes read, %d bytes saved)",
+		result.Offset, len(original), len(saved))
+	return result
+}
+
+// roundTripFiles returns the .he3 files in a directory and its subdirectories, or the file itself
+func roundTripFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	filenames := []string{}
+	err = filepath.WalkDir(path, func(filename string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(filename), ".he3") {
+			filenames = append(filenames, filename)
+		}
+		return nil
+	})
+	return filenames, err
+}
+
+func writeRoundTripText(w io.Writer, report *RoundTripReport) error {
+	for _, result := range report.Results {
+		if result.OK {
+			fmt.Fprintf(w, "ok    %s\n", result.Filename)
+		} else {
+			fmt.Fprintf(w, "FAIL  %s: %s\n", result.Filename, result.Message)
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d passed, %d failed\n", report.Passed, report.Failed)
+	return err
+}
+
+// runRoundTripCheck checks every map in a directory. Returns false if any map fails.
+func runRoundTripCheck(path, format, reportFilename string) (bool, error) {
+	filenames, err := roundTripFiles(path)
+	if err != nil {
+		return false, err
+	}
+	if len(filenames) == 0 {
+		return false, fmt.Errorf("no .he3 files in %s", path)
+	}
+	report := &RoundTripReport{Results: []RoundTripResult{}}
+	for _, filename := range filenames {
+		result := checkRoundTrip(filename)
+		if result.OK {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+	}
+	err = writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, report)
+		}
+		return writeRoundTripText(w, report)
+	})
+	return report.Failed == 0, err
+}
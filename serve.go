@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+type ServerConfig struct {
+	// Largest accepted upload in bytes
+	MaxUploadBytes int64
+	// Time limit for reading, processing and writing a request
+	Timeout time.Duration
+	// Number of uploads processed at the same time. Other requests wait for a
+	// free slot until they time out.
+	MaxConcurrent int
+	// Largest image /render draws in pixels, because the image size grows with the map
+	// size rather than the upload size
+	MaxRenderPixels int64
+}
+
+type MapMetadata struct {
+	Title     string          `json:"title"`
+	Author    string          `json:"author"`
+	Width     int32           `json:"width"`
+	Depth     int32           `json:"depth"`
+	Style     fileio.MapStyle `json:"style"`
+	Cities    []CityMetadata  `json:"cities"`
+	Armies    int             `json:"armies"`
+	Territory map[string]int  `json:"territory"`
+}
+
+type CityMetadata struct {
+	Name    string `json:"name"`
+	X       int    `json:"x"`
+	Z       int    `json:"z"`
+	Type    string `json:"type"`
+	Party   int    `json:"party"`
+	Capital bool   `json:"capital"`
+}
+
+type endpointMetrics struct {
+	statusCounts    map[int]int64
+	durationSeconds float64
+}
+
+type serverMetrics struct {
+	mutex     sync.Mutex
+	endpoints map[string]*endpointMetrics
+	inFlight  atomic.Int64
+	rejected  atomic.Int64
+}
+
+type MapServer struct {
+	config  ServerConfig
+	slots   chan struct{}
+	metrics *serverMetrics
+}
+
+// httpError is an error with the status code to send to the client
+type httpError struct {
+	status int
+	err    error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+func newMapServer(config ServerConfig) *MapServer {
+	return &MapServer{
+		config: config,
+		slots:  make(chan struct{}, config.MaxConcurrent),
+		metrics: &serverMetrics{
+			endpoints: map[string]*endpointMetrics{},
+		},
+	}
+}
+
+func (metrics *serverMetrics) record(endpoint string, status int, duration time.Duration) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	endpointData, ok := metrics.endpoints[endpoint]
+	if !ok {
+		endpointData = &endpointMetrics{statusCounts: map[int]int64{}}
+		metrics.endpoints[endpoint] = endpointData
+	}
+	endpointData.statusCounts[status]++
+	endpointData.durationSeconds += duration.Seconds()
+}
+
+// writePrometheus writes the metrics in the Prometheus text format
+func (metrics *serverMetrics) writePrometheus(w io.Writer) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	endpoints := make([]string, 0, len(metrics.endpoints))
+	for endpoint := range metrics.endpoints {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+
+	fmt.Fprintln(w, "# TYPE hexmap_requests_total counter")
+	for _, endpoint := range endpoints {
+		statuses := make([]int, 0, len(metrics.endpoints[endpoint].statusCounts))
+		for status := range metrics.endpoints[endpoint].statusCounts {
+			statuses = append(statuses, status)
+		}
+		sort.Ints(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(w, "hexmap_requests_total{endpoint=%q,code=\"%d\"} %d\n",
+				endpoint, status, metrics.endpoints[endpoint].statusCounts[status])
+		}
+	}
+	fmt.Fprintln(w, "# TYPE hexmap_request_duration_seconds_sum counter")
+	for _, endpoint := range endpoints {
+		fmt.Fprintf(w, "hexmap_request_duration_seconds_sum{endpoint=%q} %g\n",
+			endpoint, metrics.endpoints[endpoint].durationSeconds)
+	}
+	fmt.Fprintln(w, "# TYPE hexmap_in_flight_requests gauge")
+	fmt.Fprintf(w, "hexmap_in_flight_requests %d\n", metrics.inFlight.Load())
+	fmt.Fprintln(w, "# TYPE hexmap_rejected_requests_total counter")
+	fmt.Fprintf(w, "hexmap_rejected_requests_total %d\n", metrics.rejected.Load())
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// instrument records metrics for the endpoint and turns panics into a 500 response
+func (server *MapServer) instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		server.metrics.inFlight.Add(1)
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				log.Printf("Panic while handling %s: %v", r.URL.Path, recovered)
+				recorder.status = http.StatusInternalServerError
+				http.Error(w, "internal error", http.StatusInternalServerError)
+			}
+			server.metrics.inFlight.Add(-1)
+			server.metrics.record(endpoint, recorder.status, time.Since(start))
+		}()
+		next(recorder, r)
+	}
+}
+
+// limit waits for a free processing slot, or rejects the request if it times out first
+func (server *MapServer) limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case server.slots <- struct{}{}:
+			defer func() { <-server.slots }()
+		case <-r.Context().Done():
+			server.metrics.rejected.Add(1)
+			http.Error(w, "server busy", http.StatusServiceUnavailable)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleMap wraps an endpoint that takes an uploaded map
+func (server *MapServer) handleMap(handle func(w http.ResponseWriter, r *http.Request, mapData *fileio.HE3Map) error) http.HandlerFunc {
+	return server.limit(func(w http.ResponseWriter, r *http.Request) {
+		mapData, err := server.readUpload(w, r)
+		if err == nil {
+			err = handle(w, r, mapData)
+		}
+		if err != nil {
+			status := http.StatusInternalServerError
+			var statusError *httpError
+			if errors.As(err, &statusError) {
+				status = statusError.status
+			}
+			http.Error(w, err.Error(), status)
+		}
+	})
+}
+
+// readUpload reads a .he3 map from the request body, or from the "map" field of a multipart form
+func (server *MapServer) readUpload(w http.ResponseWriter, r *http.Request) (*fileio.HE3Map, error) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		return nil, &httpError{http.StatusMethodNotAllowed, errors.New("upload a map with POST")}
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, server.config.MaxUploadBytes)
+
+	var content []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, formErr := r.FormFile("map")
+		if formErr != nil {
+			err = formErr
+		} else {
+			defer file.Close()
+			content, err = io.ReadAll(file)
+		}
+	} else {
+		content, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) || strings.Contains(err.Error(), "request body too large") {
+			return nil, &httpError{http.StatusRequestEntityTooLarge, fmt.Errorf("upload is larger than %d bytes", server.config.MaxUploadBytes)}
+		}
+		return nil, &httpError{http.StatusBadRequest, fmt.Errorf("failed to read upload: %w", err)}
+	}
+
+	mapData, err := fileio.Deserialize(bytes.TrimSpace(content))
+	if err != nil {
+		return nil, &httpError{http.StatusBadRequest, fmt.Errorf("invalid map: %w", err)}
+	}
+	if mapData.Width <= 0 || mapData.Depth <= 0 {
+		return nil, &httpError{http.StatusBadRequest, fmt.Errorf("invalid map size %dx%d", mapData.Width, mapData.Depth)}
+	}
+	return mapData, nil
+}
+
+func newMapMetadata(mapData *fileio.HE3Map) *MapMetadata {
+	metadata := &MapMetadata{
+		Title:     mapData.MapTitle,
+		Author:    mapData.MapAuthor,
+		Width:     mapData.Width,
+		Depth:     mapData.Depth,
+		Style:     mapData.MapStyle,
+		Cities:    []CityMetadata{},
+		Territory: map[string]int{},
+	}
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			tile := mapData.At(x, z)
+			if tile.TileType >= fileio.Airport {
+				metadata.Cities = append(metadata.Cities, CityMetadata{
+					Name:    tile.CityName,
+					X:       x,
+					Z:       z,
+					Type:    tile.TileType.String(),
+					Party:   tile.Party,
+					Capital: tile.HasFlag,
+				})
+			}
+			if tile.Infantry != nil {
+				metadata.Armies++
+			}
+			if tile.Artillery != nil {
+				metadata.Armies++
+			}
+			metadata.Territory[fmt.Sprint(tile.Party)]++
+		}
+	}
+	return metadata
+}
+
+// checkMap rejects maps that fail validation, because the renderers and converters
+// expect a valid map. /validate reports the problems.
+func checkMap(mapData *fileio.HE3Map) error {
+	report := validateMap(mapData)
+	if report.Valid {
+		return nil
+	}
+	for _, issue := range report.Issues {
+		if issue.Severity != SeverityError {
+			continue
+		}
+		if issue.X < 0 {
+			return &httpError{http.StatusBadRequest, fmt.Errorf("invalid map: %s", issue.Message)}
+		}
+		return &httpError{http.StatusBadRequest, fmt.Errorf("invalid map: tile (%d,%d): %s", issue.X, issue.Z, issue.Message)}
+	}
+	return &httpError{http.StatusBadRequest, errors.New("invalid map")}
+}
+
+func (server *MapServer) handleRender(w http.ResponseWriter, r *http.Request, mapData *fileio.HE3Map) error {
+	if err := checkMap(mapData); err != nil {
+		return err
+	}
+	width, height := renderSize(newMapData(mapData))
+	if int64(width)*int64(height) > server.config.MaxRenderPixels {
+		return &httpError{http.StatusRequestEntityTooLarge,
+			fmt.Errorf("map renders to %dx%d pixels, more than the limit of %d", width, height, server.config.MaxRenderPixels)}
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "png":
+		w.Header().Set("Content-Type", "image/png")
+		return renderMap(newMapData(mapData), nil).EncodePNG(w)
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		return writeSVG(w, newMapData(mapData))
+	default:
+		return &httpError{http.StatusBadRequest, fmt.Errorf("unknown render format %q", format)}
+	}
+}
+
+func (server *MapServer) handleMetadata(w http.ResponseWriter, r *http.Request, mapData *fileio.HE3Map) error {
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, newMapMetadata(mapData))
+}
+
+func (server *MapServer) handleValidate(w http.ResponseWriter, r *http.Request, mapData *fileio.HE3Map) error {
+	w.Header().Set("Content-Type", "application/json")
+	return writeJSON(w, validateMap(mapData))
+}
+
+func (server *MapServer) handleConvert(w http.ResponseWriter, r *http.Request, mapData *fileio.HE3Map) error {
+	if err := checkMap(mapData); err != nil {
+		return err
+	}
+	switch format := r.URL.Query().Get("format"); format {
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return fileio.WriteTextMap(w, mapData)
+	case "textconv":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		return writeCanonicalText(w, mapData)
+	case "geojson":
+		w.Header().Set("Content-Type", "application/geo+json")
+		return writeJSON(w, exportGeoJSON(mapData, GeoReference{HexSize: 1}))
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		return writeViewer(w, mapData)
+	case "he3":
+		content, err := fileio.Serialize(mapData)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, err = io.WriteString(w, content)
+		return err
+	default:
+		return &httpError{http.StatusBadRequest, fmt.Errorf("unknown convert format %q", format)}
+	}
+}
+
+// Handler returns the HTTP handler with all endpoints
+func (server *MapServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	uploadEndpoints := map[string]func(w http.ResponseWriter, r *http.Request, mapData *fileio.HE3Map) error{
+		"/render":   server.handleRender,
+		"/metadata": server.handleMetadata,
+		"/validate": server.handleValidate,
+		"/convert":  server.handleConvert,
+	}
+	for endpoint, handle := range uploadEndpoints {
+		handler := http.TimeoutHandler(server.handleMap(handle), server.config.Timeout, "request timed out")
+		mux.HandleFunc(endpoint, server.instrument(endpoint, handler.ServeHTTP))
+	}
+	mux.HandleFunc("/healthz", server.instrument("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	}))
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		server.metrics.writePrometheus(w)
+	})
+	return mux
+}
+
+// runServer serves the map endpoints until the process is interrupted
+func runServer(addr string, config ServerConfig) error {
+	if config.MaxConcurrent <= 0 || config.MaxUploadBytes <= 0 || config.Timeout <= 0 || config.MaxRenderPixels <= 0 {
+		return fmt.Errorf("upload limit, timeout, concurrency and pixel limit must be positive")
+	}
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           newMapServer(config).Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       config.Timeout,
+		WriteTimeout:      config.Timeout + 5*time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Println("Listening on", addr)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
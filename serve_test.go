@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+func testServerConfig() ServerConfig {
+	return ServerConfig{
+		MaxUploadBytes:  8 << 20,
+		Timeout:         10 * time.Second,
+		MaxConcurrent:   2,
+		MaxRenderPixels: 16 << 20,
+	}
+}
+
+func readTestMap(t *testing.T) []byte {
+	t.Helper()
+	content, err := os.ReadFile("maps/Stalingrad.he3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func serializeTestMap(t *testing.T, mapData *fileio.HE3Map) []byte {
+	t.Helper()
+	content, err := fileio.Serialize(mapData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(content)
+}
+
+func post(t *testing.T, handler http.Handler, target string, body []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body)))
+	return recorder
+}
+
+func TestServerEndpoints(t *testing.T) {
+	handler := newMapServer(testServerConfig()).Handler()
+	content := readTestMap(t)
+	tests := []struct {
+		target      string
+		contentType string
+		// Text the response must contain
+		body string
+	}{
+		{"/render", "image/png", "\x89PNG"},
+		{"/render?format=svg", "image/svg+xml", "<svg"},
+		{"/metadata", "application/json", `"title": "Stalingrad"`},
+		{"/validate", "application/json", `"valid": true`},
+		{"/convert?format=text", "text/plain; charset=utf-8", fileio.TEXT_MAP_HEADER},
+		{"/convert?format=textconv", "text/plain; charset=utf-8", "Stalingrad"},
+		{"/convert?format=geojson", "application/geo+json", "FeatureCollection"},
+		{"/convert?format=html", "text/html; charset=utf-8", "<html"},
+		{"/convert?format=he3", "text/plain", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			response := post(t, handler, test.target, content)
+			if response.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", response.Code, response.Body.String())
+			}
+			if contentType := response.Header().Get("Content-Type"); contentType != test.contentType {
+				t.Errorf("got content type %q, expected %q", contentType, test.contentType)
+			}
+			if !strings.Contains(response.Body.String(), test.body) {
+				t.Errorf("response doesn't contain %q", test.body)
+			}
+		})
+	}
+}
+
+func TestServerConvertHE3(t *testing.T) {
+	handler := newMapServer(testServerConfig()).Handler()
+	content := readTestMap(t)
+	response := post(t, handler, "/convert?format=he3", content)
+	original, err := fileio.Deserialize(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := fileio.Deserialize(response.Body.Bytes())
+	if err != nil {
+		t.Fatalf("converted map can't be read: %v", err)
+	}
+	originalData, err := fileio.SerializeData(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	convertedData, err := fileio.SerializeData(converted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(originalData, convertedData) {
+		t.Error("converted map differs from the upload")
+	}
+}
+
+func TestServerMultipartUpload(t *testing.T) {
+	handler := newMapServer(testServerConfig()).Handler()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("map", "Stalingrad.he3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(readTestMap(t))
+	form.Close()
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/metadata", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestServerRejectsRequests(t *testing.T) {
+	content := readTestMap(t)
+	badParty, err := fileio.Deserialize(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badParty.At(3, 4).Party = 7
+	badPartyContent := serializeTestMap(t, badParty)
+
+	smallUpload := testServerConfig()
+	smallUpload.MaxUploadBytes = 100
+	smallRender := testServerConfig()
+	smallRender.MaxRenderPixels = 1000
+
+	tests := []struct {
+		name   string
+		config ServerConfig
+		method string
+		target string
+		body   []byte
+		status int
+		error  string
+	}{
+		{"upload too large", smallUpload, http.MethodPost, "/render", content, http.StatusRequestEntityTooLarge, "upload is larger than 100 bytes"},
+		{"not a map", testServerConfig(), http.MethodPost, "/metadata", []byte("hello"), http.StatusBadRequest, "invalid map"},
+		{"bad party render", testServerConfig(), http.MethodPost, "/render", badPartyContent, http.StatusBadRequest, "tile (3,4): party 7 is not between -1 and 5"},
+		{"bad party svg", testServerConfig(), http.MethodPost, "/render?format=svg", badPartyContent, http.StatusBadRequest, "party 7"},
+		{"bad party convert", testServerConfig(), http.MethodPost, "/convert?format=text", badPartyContent, http.StatusBadRequest, "party 7"},
+		{"render too large", smallRender, http.MethodPost, "/render", content, http.StatusRequestEntityTooLarge, "more than the limit of 1000"},
+		{"unknown render format", testServerConfig(), http.MethodPost, "/render?format=gif", content, http.StatusBadRequest, `unknown render format "gif"`},
+		{"unknown convert format", testServerConfig(), http.MethodPost, "/convert?format=pdf", content, http.StatusBadRequest, `unknown convert format "pdf"`},
+		{"GET upload", testServerConfig(), http.MethodGet, "/render", nil, http.StatusMethodNotAllowed, "upload a map with POST"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(test.method, test.target, bytes.NewReader(test.body))
+			newMapServer(test.config).Handler().ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, expected %d", recorder.Code, test.status)
+			}
+			if !strings.Contains(recorder.Body.String(), test.error) {
+				t.Errorf("got %q, expected it to contain %q", recorder.Body.String(), test.error)
+			}
+		})
+	}
+}
+
+func TestServerValidateReportsBadParty(t *testing.T) {
+	mapData, err := fileio.Deserialize(readTestMap(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapData.At(3, 4).Party = 7
+	response := post(t, newMapServer(testServerConfig()).Handler(), "/validate", serializeTestMap(t, mapData))
+	if response.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", response.Code, response.Body.String())
+	}
+	report := ValidationReport{}
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Valid {
+		t.Error("expected the map to be invalid")
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	config := testServerConfig()
+	config.MaxConcurrent = 1
+	config.Timeout = 50 * time.Millisecond
+	server := newMapServer(config)
+	handler := server.Handler()
+
+	// Take the only processing slot, so the request waits until it times out
+	server.slots <- struct{}{}
+	defer func() { <-server.slots }()
+	response := post(t, handler, "/metadata", readTestMap(t))
+	if response.Code != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, expected %d", response.Code, http.StatusServiceUnavailable)
+	}
+
+	metrics := httptest.NewRecorder()
+	handler.ServeHTTP(metrics, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `hexmap_requests_total{endpoint="/metadata",code="503"} 1`) {
+		t.Errorf("timed out request is missing from the metrics:\n%s", metrics.Body.String())
+	}
+}
+
+func TestServerHealthAndMetrics(t *testing.T) {
+	handler := newMapServer(testServerConfig()).Handler()
+	post(t, handler, "/metadata", readTestMap(t))
+
+	health := httptest.NewRecorder()
+	handler.ServeHTTP(health, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if health.Code != http.StatusOK || health.Body.String() != "ok\n" {
+		t.Errorf("got %d %q from /healthz", health.Code, health.Body.String())
+	}
+
+	metrics := httptest.NewRecorder()
+	handler.ServeHTTP(metrics, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range []string{
+		`hexmap_requests_total{endpoint="/metadata",code="200"} 1`,
+		`hexmap_requests_total{endpoint="/healthz",code="200"} 1`,
+		"hexmap_in_flight_requests 0",
+	} {
+		if !strings.Contains(metrics.Body.String(), line) {
+			t.Errorf("metrics are missing %q:\n%s", line, metrics.Body.String())
+		}
+	}
+}
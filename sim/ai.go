@@ -0,0 +1,188 @@
+package sim
+
+import (
+	"fmt"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// AIPlayer chooses moves for one party. Players should only use game.Rand() for
+// randomness so that games stay reproducible.
+type AIPlayer interface {
+	Name() string
+	// ChooseMove picks one of the legal moves, or returns false to end the turn
+	ChooseMove(game *Game, moves []Move) (Move, bool)
+}
+
+const (
+	StrategyRandom      = "random"
+	StrategyGreedy      = "greedy"
+	StrategyCapitalRush = "capitalrush"
+
+	// Attacks are only made when the attacker is this much stronger than the defender
+	AttackMargin = 1.1
+)
+
+var Strategies = []string{StrategyRandom, StrategyGreedy, StrategyCapitalRush}
+
+// NewAIPlayer returns the built-in player for a strategy name
+func NewAIPlayer(strategy string) (AIPlayer, error) {
+	switch strategy {
+	case StrategyRandom:
+		return RandomPlayer{}, nil
+	case StrategyGreedy:
+		return GreedyPlayer{}, nil
+	case StrategyCapitalRush:
+		return CapitalRushPlayer{}, nil
+	}
+	return nil, fmt.Errorf("unknown strategy %q, expected one of %v", strategy, Strategies)
+}
+
+// RandomPlayer makes random legal moves
+type RandomPlayer struct{}
+
+func (player RandomPlayer) Name() string {
+	return StrategyRandom
+}
+
+func (player RandomPlayer) ChooseMove(game *Game, moves []Move) (Move, bool) {
+	if len(moves) == 0 {
+		return Move{}, false
+	}
+	return moves[game.Rand().Intn(len(moves))], true
+}
+
+// GreedyPlayer grabs as many tiles and settlements as it can each turn and moves
+// idle stacks towards the nearest settlement it doesn't own
+type GreedyPlayer struct{}
+
+func (player GreedyPlayer) Name() string {
+	return StrategyGreedy
+}
+
+func (player GreedyPlayer) ChooseMove(game *Game, moves []Move) (Move, bool) {
+	distance := game.distanceField(func(tile *fileio.MapTile) bool {
+		return isSettlement(tile) && tile.Party != game.CurrentParty
+	})
+	return chooseBestMove(game, moves, func(move Move) float64 {
+		score := captureValue(game, move)
+		if score < 0 {
+			return score
+		}
+		return score + approach(game, move, distance)
+	})
+}
+
+// CapitalRushPlayer sends every stack towards the nearest enemy capital and only
+// stops to fight or capture on the way
+type CapitalRushPlayer struct{}
+
+func (player CapitalRushPlayer) Name() string {
+	return StrategyCapitalRush
+}
+
+func (player CapitalRushPlayer) ChooseMove(game *Game, moves []Move) (Move, bool) {
+	distance := game.distanceField(func(tile *fileio.MapTile) bool {
+		return tile.HasFlag && isSettlement(tile) && tile.Party != game.CurrentParty && game.IsActive(tile.Party)
+	})
+	return chooseBestMove(game, moves, func(move Move) float64 {
+		score := captureValue(game, move)
+		if score < 0 {
+			return score
+		}
+		return score/10 + 10*approach(game, move, distance)
+	})
+}
+
+// chooseBestMove picks the move with the highest positive score, breaking ties at random
+func chooseBestMove(game *Game, moves []Move, score func(Move) float64) (Move, bool) {
+	bestScore := 0.0
+	best := []Move{}
+	for _, move := range moves {
+		moveScore := score(move)
+		if moveScore > bestScore {
+			bestScore = moveScore
+			best = best[:0]
+		}
+		if moveScore == bestScore && moveScore > 0 {
+			best = append(best, move)
+		}
+	}
+	if len(best) == 0 {
+		return Move{}, false
+	}
+	return best[game.Rand().Intn(len(best))], true
+}
+
+// captureValue scores what a move takes. Attacks the stack is unlikely to win score -1.
+// Joining another friendly stack scores a little, so that stacks gather for attacks they
+// can't win alone.
+func captureValue(game *Game, move Move) float64 {
+	from := game.Tile(move.FromX, move.FromZ)
+	to := game.Tile(move.ToX, move.ToZ)
+	if to.Party == game.CurrentParty {
+		if hasArmy(to) {
+			return 1
+		}
+		return 0
+	}
+
+	score := 0.0
+	if hasArmy(to) {
+		defense := StackStrength(to) * defenseMultiplier(to)
+		if StackStrength(from) < defense*AttackMargin {
+			return -1
+		}
+		score += 20
+	}
+	switch {
+	case to.HasFlag && isSettlement(to):
+		score += 1000
+	case isSettlement(to):
+		score += 100
+	case to.Party >= 0:
+		score += 10
+	default:
+		score += 5
+	}
+	return score
+}
+
+// distanceField returns the number of moves from every tile to the nearest target over
+// passable tiles, or -1 for tiles that can't reach a target. Indexed by x*depth+z.
+func (game *Game) distanceField(match func(tile *fileio.MapTile) bool) []int {
+	depth := int(game.Map.Depth)
+	distance := make([]int, int(game.Map.Width)*depth)
+	queue := [][2]int{}
+	game.forEachTile(func(x, z int, tile *fileio.MapTile) {
+		distance[x*depth+z] = -1
+		if match(tile) {
+			distance[x*depth+z] = 0
+			queue = append(queue, [2]int{x, z})
+		}
+	})
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		game.forEachNeighbor(current[0], current[1], func(nextX, nextZ int) {
+			index := nextX*depth + nextZ
+			if distance[index] >= 0 || !IsPassable(game.Tile(nextX, nextZ)) {
+				return
+			}
+			distance[index] = distance[current[0]*depth+current[1]] + 1
+			queue = append(queue, [2]int{nextX, nextZ})
+		})
+	}
+	return distance
+}
+
+// approach returns how many moves closer the move gets to the nearest target
+func approach(game *Game, move Move, distance []int) float64 {
+	depth := int(game.Map.Depth)
+	before := distance[move.FromX*depth+move.FromZ]
+	after := distance[move.ToX*depth+move.ToZ]
+	if before < 0 || after < 0 {
+		return 0
+	}
+	return float64(before - after)
+}
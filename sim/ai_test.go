@@ -0,0 +1,58 @@
+package sim
+
+import "testing"
+
+func TestPlayersChooseLegalMoves(t *testing.T) {
+	mapData := readTestMap(t)
+	for _, strategy := range Strategies {
+		t.Run(strategy, func(t *testing.T) {
+			player, err := NewAIPlayer(strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if player.Name() != strategy {
+				t.Errorf("got name %q", player.Name())
+			}
+			game := NewGame(mapData, 3)
+			for game.Turn < 5 && !game.IsOver() {
+				moves := game.LegalMoves()
+				move, ok := player.ChooseMove(game, moves)
+				if !ok {
+					game.EndTurn()
+					continue
+				}
+				if _, err := game.Apply(move); err != nil {
+					t.Fatalf("turn %d: %v", game.Turn, err)
+				}
+			}
+		})
+	}
+}
+
+func TestChooseBestMove(t *testing.T) {
+	game := NewGame(readTestMap(t), 1)
+	moves := []Move{{ToX: 1}, {ToX: 2}, {ToX: 3}}
+	scores := map[int]float64{1: 5, 2: 10, 3: -1}
+	move, ok := chooseBestMove(game, moves, func(move Move) float64 { return scores[move.ToX] })
+	if !ok || move.ToX != 2 {
+		t.Errorf("got %+v %v, expected the move with the highest score", move, ok)
+	}
+	if _, ok := chooseBestMove(game, moves, func(move Move) float64 { return 0 }); ok {
+		t.Error("expected no move when no move has a positive score")
+	}
+}
+
+func TestCaptureValueAvoidsLosingAttacks(t *testing.T) {
+	game := NewGame(readTestMap(t), 1)
+	from := game.Tile(0, 0)
+	to := game.Tile(1, 0)
+	*from = *stackTile(from.TileType, game.CurrentParty, 10)
+	*to = *stackTile(to.TileType, game.CurrentParty+1, 1000)
+	if score := captureValue(game, Move{FromX: 0, FromZ: 0, ToX: 1, ToZ: 0}); score != -1 {
+		t.Errorf("got score %v for an attack on a much stronger stack, expected -1", score)
+	}
+	*to = *stackTile(to.TileType, game.CurrentParty+1, 1)
+	if score := captureValue(game, Move{FromX: 0, FromZ: 0, ToX: 1, ToZ: 0}); score <= 0 {
+		t.Errorf("got score %v for an attack on a much weaker stack, expected a positive score", score)
+	}
+}
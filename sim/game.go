@@ -0,0 +1,350 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// Number of parties in a game
+const MaxParties = fileio.MAX_PARTIES
+
+type Move struct {
+	FromX int `json:"fromX"`
+	FromZ int `json:"fromZ"`
+	ToX   int `json:"toX"`
+	ToZ   int `json:"toZ"`
+}
+
+// MoveResult describes what happened when a move was applied
+type MoveResult struct {
+	Move     Move `json:"move"`
+	Party    int  `json:"party"`
+	Battle   bool `json:"battle"`
+	Won      bool `json:"won"`
+	Captured bool `json:"captured"`
+	// Party eliminated by capturing its capital, or -1
+	Eliminated int `json:"eliminated"`
+}
+
+type Game struct {
+	Map  *fileio.HE3Map
+	Seed int64
+	// Turn counts completed rounds, where every active party has moved once
+	Turn         int
+	CurrentParty int
+	MovesLeft    int
+	active       [MaxParties]bool
+	moved        map[[2]int]bool
+	rng          *rand.Rand
+}
+
+// NewGame starts a game from a copy of the map. Parties that own at least one tile take part.
+// The same map and seed always give the same results for the same moves.
+func NewGame(mapData *fileio.HE3Map, seed int64) *Game {
+	game := &Game{
+		Map:          mapData.Clone(),
+		Seed:         seed,
+		CurrentParty: -1,
+		rng:          rand.New(rand.NewSource(seed)),
+	}
+	game.forEachTile(func(x, z int, tile *fileio.MapTile) {
+		if tile.Party >= 0 && tile.Party < MaxParties {
+			game.active[tile.Party] = true
+		}
+	})
+	game.nextParty()
+	return game
+}
+
+func (game *Game) forEachTile(visit func(x, z int, tile *fileio.MapTile)) {
+	for x := 0; x < int(game.Map.Width); x++ {
+		for z := 0; z < int(game.Map.Depth); z++ {
+			visit(x, z, game.Map.At(x, z))
+		}
+	}
+}
+
+// Tile returns the tile at (x, z), or nil if it is outside the map
+func (game *Game) Tile(x, z int) *fileio.MapTile {
+	if x < 0 || z < 0 || x >= int(game.Map.Width) || z >= int(game.Map.Depth) {
+		return nil
+	}
+	return game.Map.At(x, z)
+}
+
+// Rand returns the game's random source so players can make reproducible choices
+func (game *Game) Rand() *rand.Rand {
+	return game.rng
+}
+
+func (game *Game) IsActive(party int) bool {
+	return party >= 0 && party < MaxParties && game.active[party]
+}
+
+// ActiveParties returns the parties still in the game in turn order
+func (game *Game) ActiveParties() []int {
+	parties := make([]int, 0, MaxParties)
+	for party := 0; party < MaxParties; party++ {
+		if game.active[party] {
+			parties = append(parties, party)
+		}
+	}
+	return parties
+}
+
+// IsOver returns true when at most one party is left
+func (game *Game) IsOver() bool {
+	return len(game.ActiveParties()) <= 1
+}
+
+// Winner returns the last party left, or -1 if the game is not over
+func (game *Game) Winner() int {
+	parties := game.ActiveParties()
+	if len(parties) != 1 {
+		return -1
+	}
+	return parties[0]
+}
+
+// Scores counts the settlements owned by each party
+func (game *Game) Scores() [MaxParties]int {
+	var scores [MaxParties]int
+	game.forEachTile(func(x, z int, tile *fileio.MapTile) {
+		if isSettlement(tile) && tile.Party >= 0 && tile.Party < MaxParties {
+			scores[tile.Party]++
+		}
+	})
+	return scores
+}
+
+func (game *Game) forEachNeighbor(x, z int, visit func(x, z int)) {
+	offsets := NeighborEven
+	if z%2 == 1 {
+		offsets = NeighborOdd
+	}
+	for _, offset := range offsets {
+		newX, newZ := x+offset[0], z+offset[1]
+		if game.Tile(newX, newZ) != nil {
+			visit(newX, newZ)
+		}
+	}
+}
+
+// Neighbors returns the tiles next to (x, z) that are inside the map
+func (game *Game) Neighbors(x, z int) [][2]int {
+	neighbors := make([][2]int, 0, 6)
+	game.forEachNeighbor(x, z, func(newX, newZ int) {
+		neighbors = append(neighbors, [2]int{newX, newZ})
+	})
+	return neighbors
+}
+
+// Destinations returns the tiles a stack at (x, z) can move to: any passable neighbor,
+// or further along roads through tiles without enemy armies
+func (game *Game) Destinations(x, z int) [][2]int {
+	start := game.Tile(x, z)
+	if start == nil {
+		return nil
+	}
+	party := start.Party
+	distance := map[[2]int]int{{x, z}: 0}
+	queue := [][2]int{{x, z}}
+	destinations := [][2]int{}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		currentTile := game.Tile(current[0], current[1])
+		game.forEachNeighbor(current[0], current[1], func(nextX, nextZ int) {
+			next := [2]int{nextX, nextZ}
+			if _, seen := distance[next]; seen {
+				return
+			}
+			nextTile := game.Tile(nextX, nextZ)
+			if !IsPassable(nextTile) {
+				return
+			}
+			// Stacks leave the road when they step off it or run into another stack
+			if distance[current] > 0 && (!currentTile.HasRoad || !nextTile.HasRoad) {
+				return
+			}
+			distance[next] = distance[current] + 1
+			destinations = append(destinations, next)
+			blocked := hasArmy(nextTile) && nextTile.Party != party
+			if distance[next] < RoadRange && nextTile.HasRoad && !blocked {
+				queue = append(queue, next)
+			}
+		})
+	}
+	return destinations
+}
+
+// LegalMoves returns every move the current party can make
+func (game *Game) LegalMoves() []Move {
+	moves := []Move{}
+	if game.MovesLeft <= 0 || game.IsOver() {
+		return moves
+	}
+	game.forEachTile(func(x, z int, tile *fileio.MapTile) {
+		if tile.Party != game.CurrentParty || !hasArmy(tile) || game.moved[[2]int{x, z}] {
+			return
+		}
+		for _, destination := range game.Destinations(x, z) {
+			moves = append(moves, Move{FromX: x, FromZ: z, ToX: destination[0], ToZ: destination[1]})
+		}
+	})
+	return moves
+}
+
+func (game *Game) isLegal(move Move) bool {
+	from := game.Tile(move.FromX, move.FromZ)
+	if from == nil || from.Party != game.CurrentParty || !hasArmy(from) || game.moved[[2]int{move.FromX, move.FromZ}] {
+		return false
+	}
+	for _, destination := range game.Destinations(move.FromX, move.FromZ) {
+		if destination[0] == move.ToX && destination[1] == move.ToZ {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply moves a stack of the current party, fighting any enemy armies on the destination
+func (game *Game) Apply(move Move) (*MoveResult, error) {
+	if game.IsOver() {
+		return nil, fmt.Errorf("game is over")
+	}
+	if game.MovesLeft <= 0 {
+		return nil, fmt.Errorf("party %d has no moves left this turn", game.CurrentParty)
+	}
+	if !game.isLegal(move) {
+		return nil, fmt.Errorf("illegal move from (%d,%d) to (%d,%d) for party %d",
+			move.FromX, move.FromZ, move.ToX, move.ToZ, game.CurrentParty)
+	}
+
+	result := &MoveResult{Move: move, Party: game.CurrentParty, Eliminated: -1}
+	from := game.Tile(move.FromX, move.FromZ)
+	to := game.Tile(move.ToX, move.ToZ)
+	game.MovesLeft--
+
+	if hasArmy(to) && to.Party != game.CurrentParty {
+		result.Battle = true
+		result.Won = game.fight(from, to)
+		if !result.Won {
+			return result, nil
+		}
+		removeStack(to)
+	}
+
+	moveStack(from, to, move.ToX, move.ToZ)
+	game.moved[[2]int{move.ToX, move.ToZ}] = true
+	if to.Party != game.CurrentParty {
+		result.Captured = true
+		result.Eliminated = game.capture(to)
+	}
+	return result, nil
+}
+
+// fight resolves a battle between the attacking and defending stacks and returns true if the
+// attacker won. Losses follow Lanchester's square law, so the winner keeps
+// sqrt(1 - (loser/winner)^2) of its units and the loser is destroyed. The defender wins ties,
+// including battles between stacks without units.
+func (game *Game) fight(attacker *fileio.MapTile, defender *fileio.MapTile) bool {
+	attackStrength := StackStrength(attacker) * game.luck()
+	defenseStrength := StackStrength(defender) * defenseMultiplier(defender) * game.luck()
+
+	if attackStrength > defenseStrength {
+		scaleStack(attacker, survivingFraction(attackStrength, defenseStrength), MoraleWin)
+		return true
+	}
+	scaleStack(defender, survivingFraction(defenseStrength, attackStrength), MoraleWin)
+	removeStack(attacker)
+	return false
+}
+
+// survivingFraction returns the fraction of the winner's units left after a battle
+func survivingFraction(winnerStrength, loserStrength float64) float64 {
+	if winnerStrength <= 0 {
+		return 0
+	}
+	ratio := loserStrength / winnerStrength
+	return math.Sqrt(1 - ratio*ratio)
+}
+
+func (game *Game) luck() float64 {
+	return 1 - CombatLuck + 2*CombatLuck*game.rng.Float64()
+}
+
+// capture gives the tile to the current party. Taking a capital eliminates its owner and
+// hands over all of their tiles; their remaining armies are disbanded.
+// Returns the eliminated party, or -1.
+func (game *Game) capture(tile *fileio.MapTile) int {
+	previousParty := tile.Party
+	tile.Party = game.CurrentParty
+	if !tile.HasFlag || !isSettlement(tile) || !game.IsActive(previousParty) {
+		return -1
+	}
+
+	tile.HasFlag = false
+	game.forEachTile(func(x, z int, other *fileio.MapTile) {
+		if other.Party != previousParty {
+			return
+		}
+		other.Party = game.CurrentParty
+		if hasArmy(other) {
+			removeStack(other)
+		}
+	})
+	game.active[previousParty] = false
+	return previousParty
+}
+
+// eliminateDefeated removes parties that have no tiles left
+func (game *Game) eliminateDefeated() {
+	var owned [MaxParties]bool
+	game.forEachTile(func(x, z int, tile *fileio.MapTile) {
+		if tile.Party >= 0 && tile.Party < MaxParties {
+			owned[tile.Party] = true
+		}
+	})
+	for party := 0; party < MaxParties; party++ {
+		if !owned[party] {
+			game.active[party] = false
+		}
+	}
+}
+
+// EndTurn passes play to the next active party, which recruits units in its settlements
+func (game *Game) EndTurn() {
+	game.eliminateDefeated()
+	if game.IsOver() {
+		return
+	}
+	game.nextParty()
+}
+
+func (game *Game) nextParty() {
+	for i := 1; i <= MaxParties; i++ {
+		party := game.CurrentParty + i
+		if party >= MaxParties {
+			party -= MaxParties
+		}
+		if !game.active[party] {
+			continue
+		}
+		if game.CurrentParty >= 0 && party <= game.CurrentParty {
+			game.Turn++
+		}
+		game.CurrentParty = party
+		break
+	}
+	game.MovesLeft = MovesPerTurn
+	game.moved = map[[2]int]bool{}
+	game.forEachTile(func(x, z int, tile *fileio.MapTile) {
+		if tile.Party == game.CurrentParty {
+			recruit(tile, x, z)
+		}
+	})
+}
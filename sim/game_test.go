@@ -0,0 +1,161 @@
+package sim
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+func readTestMap(t *testing.T) *fileio.HE3Map {
+	t.Helper()
+	content, err := os.ReadFile("../maps/Stalingrad.he3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapData, err := fileio.Deserialize(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mapData
+}
+
+func serializeMap(t *testing.T, mapData *fileio.HE3Map) []byte {
+	t.Helper()
+	data, err := fileio.SerializeData(mapData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func stackTile(tileType fileio.FieldType, party int, infantry int32) *fileio.MapTile {
+	return &fileio.MapTile{
+		TileType:    tileType,
+		Party:       party,
+		HasInfantry: true,
+		Infantry:    &fileio.Army{UnitInfantry: infantry, Morale: RecruitMorale},
+	}
+}
+
+func TestFight(t *testing.T) {
+	tests := []struct {
+		name         string
+		attack       int32
+		defend       int32
+		defenderType fileio.FieldType
+		attackerWins bool
+		// Range of units the winner has left
+		minLeft, maxLeft int32
+	}{
+		{"stronger attacker", 1000, 10, fileio.Grass, true, 999, 1000},
+		{"stronger defender", 10, 1000, fileio.Grass, false, 999, 1000},
+		// Luck is at most 25%, which can't beat the 50% settlement defense
+		{"settlement defense", 100, 100, fileio.City, false, 1, 100},
+		{"empty attacker", 0, 100, fileio.Grass, false, 100, 100},
+		{"empty defender", 100, 0, fileio.Grass, true, 100, 100},
+		{"both empty", 0, 0, fileio.Grass, false, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for seed := int64(0); seed < 20; seed++ {
+				game := &Game{rng: rand.New(rand.NewSource(seed))}
+				attacker := stackTile(fileio.Grass, 0, test.attack)
+				defender := stackTile(test.defenderType, 1, test.defend)
+				won := game.fight(attacker, defender)
+				if won != test.attackerWins {
+					t.Fatalf("seed %d: attacker won is %v, expected %v", seed, won, test.attackerWins)
+				}
+
+				winner := defender
+				if won {
+					winner = attacker
+				} else if hasArmy(attacker) {
+					t.Errorf("seed %d: losing attacker still has armies", seed)
+				}
+				left, _, _ := stackUnits(winner)
+				if left < test.minLeft || left > test.maxLeft {
+					t.Errorf("seed %d: winner has %d units left, expected %d to %d", seed, left, test.minLeft, test.maxLeft)
+				}
+				if winner.Infantry != nil && winner.Infantry.Morale != RecruitMorale+MoraleWin {
+					t.Errorf("seed %d: winner morale is %v, expected %v", seed, winner.Infantry.Morale, RecruitMorale+MoraleWin)
+				}
+			}
+		})
+	}
+}
+
+func TestSurvivingFraction(t *testing.T) {
+	tests := []struct {
+		winner, loser float64
+		expected      float64
+	}{
+		{100, 0, 1},
+		{100, 100, 0},
+		{5, 3, 0.8},
+		{0, 0, 0},
+	}
+	for _, test := range tests {
+		fraction := survivingFraction(test.winner, test.loser)
+		if math.IsNaN(fraction) || math.Abs(fraction-test.expected) > 1e-9 {
+			t.Errorf("survivingFraction(%v, %v) = %v, expected %v", test.winner, test.loser, fraction, test.expected)
+		}
+	}
+}
+
+func playTestGame(t *testing.T, mapData *fileio.HE3Map, seed int64) (*GameResult, []byte) {
+	t.Helper()
+	players := [MaxParties]AIPlayer{}
+	for party := range players {
+		player, err := NewAIPlayer(Strategies[party%len(Strategies)])
+		if err != nil {
+			t.Fatal(err)
+		}
+		players[party] = player
+	}
+	game := NewGame(mapData, seed)
+	result, err := PlayGame(game, players, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, serializeMap(t, game.Map)
+}
+
+func TestGameIsDeterministic(t *testing.T) {
+	mapData := readTestMap(t)
+	original := serializeMap(t, mapData)
+	for _, seed := range []int64{1, 42} {
+		firstResult, firstMap := playTestGame(t, mapData, seed)
+		secondResult, secondMap := playTestGame(t, mapData, seed)
+		if !reflect.DeepEqual(firstResult, secondResult) {
+			t.Errorf("seed %d: results differ: %+v and %+v", seed, firstResult, secondResult)
+		}
+		if !bytes.Equal(firstMap, secondMap) {
+			t.Errorf("seed %d: final maps differ", seed)
+		}
+	}
+	if !bytes.Equal(original, serializeMap(t, mapData)) {
+		t.Error("playing a game changed the input map")
+	}
+}
+
+func TestApplyRejectsIllegalMoves(t *testing.T) {
+	game := NewGame(readTestMap(t), 1)
+	if _, err := game.Apply(Move{FromX: -1, FromZ: 0, ToX: 0, ToZ: 0}); err == nil {
+		t.Error("expected an error for a move from outside the map")
+	}
+	moves := game.LegalMoves()
+	if len(moves) == 0 {
+		t.Fatal("expected the first party to have moves")
+	}
+	if _, err := game.Apply(moves[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := game.Apply(moves[0]); err == nil {
+		t.Error("expected an error for moving the same stack twice")
+	}
+}
@@ -0,0 +1,72 @@
+package sim
+
+import "fmt"
+
+type GameResult struct {
+	Seed int64 `json:"seed"`
+	// Last party left, or the party with the most settlements when the turn limit was reached.
+	// -1 if the leaders were tied.
+	Winner int `json:"winner"`
+	// True if every other party was eliminated
+	Conquest bool            `json:"conquest"`
+	Turns    int             `json:"turns"`
+	Scores   [MaxParties]int `json:"scores"`
+}
+
+// PlayTurn lets the player make moves for the current party, then ends the turn
+func PlayTurn(game *Game, player AIPlayer) error {
+	for {
+		moves := game.LegalMoves()
+		if len(moves) == 0 {
+			break
+		}
+		move, ok := player.ChooseMove(game, moves)
+		if !ok {
+			break
+		}
+		if _, err := game.Apply(move); err != nil {
+			return fmt.Errorf("%s player: %w", player.Name(), err)
+		}
+		if game.IsOver() {
+			return nil
+		}
+	}
+	game.EndTurn()
+	return nil
+}
+
+// PlayGame plays a game until one party is left or maxTurns rounds have been played.
+// players is indexed by party; every active party needs a player.
+func PlayGame(game *Game, players [MaxParties]AIPlayer, maxTurns int) (*GameResult, error) {
+	for _, party := range game.ActiveParties() {
+		if players[party] == nil {
+			return nil, fmt.Errorf("no player for party %d", party)
+		}
+	}
+
+	for !game.IsOver() && game.Turn < maxTurns {
+		if err := PlayTurn(game, players[game.CurrentParty]); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &GameResult{
+		Seed:     game.Seed,
+		Winner:   game.Winner(),
+		Conquest: game.IsOver(),
+		Turns:    game.Turn,
+		Scores:   game.Scores(),
+	}
+	if !result.Conquest {
+		bestScore := -1
+		for party, score := range result.Scores {
+			if score > bestScore {
+				bestScore = score
+				result.Winner = party
+			} else if score == bestScore {
+				result.Winner = -1
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,180 @@
+package sim
+
+import (
+	"math"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	// Number of stacks a party may move in one turn
+	MovesPerTurn = 5
+	// Number of hexes a stack can travel along connected roads in one move
+	RoadRange = 3
+
+	InfantryStrength  = 1.0
+	ArtilleryStrength = 1.5
+
+	// Defense multipliers for the tile being attacked
+	SettlementDefense = 1.5
+	ForestDefense     = 1.25
+
+	// Morale gained by the winner of a battle. Morale stays between MinMorale and MaxMorale.
+	MoraleWin = 0.1
+	MinMorale = 0.5
+	MaxMorale = 2.0
+	// Morale of newly recruited units
+	RecruitMorale = 1.0
+	// Settlements stop recruiting once the armies on them have this many units
+	MaxGarrison = 500
+
+	// Each side's strength is multiplied by a random factor in [1 - CombatLuck, 1 + CombatLuck]
+	CombatLuck = 0.25
+)
+
+// Units recruited each turn by the owner of a settlement
+var (
+	RecruitInfantry = map[fileio.FieldType]int32{
+		fileio.Town:    50,
+		fileio.City:    100,
+		fileio.Capital: 100,
+	}
+	RecruitArtillery = map[fileio.FieldType]int32{
+		fileio.Factory: 50,
+	}
+)
+
+// NeighborOdd and NeighborEven are the neighbor offsets for odd and even z rows,
+// using the same layout as the map renderer
+var (
+	NeighborOdd  = [6][2]int{{-1, 0}, {0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}}
+	NeighborEven = [6][2]int{{-1, 0}, {-1, -1}, {0, -1}, {1, 0}, {0, 1}, {-1, 1}}
+)
+
+func isSettlement(tile *fileio.MapTile) bool {
+	return tile.TileType >= fileio.Factory
+}
+
+// IsPassable returns true if armies can enter the tile
+func IsPassable(tile *fileio.MapTile) bool {
+	return !tile.IsSea && !tile.IsMountain
+}
+
+func hasArmy(tile *fileio.MapTile) bool {
+	return tile.Infantry != nil || tile.Artillery != nil
+}
+
+// stackUnits returns the total infantry and artillery on a tile and their average morale
+func stackUnits(tile *fileio.MapTile) (infantry, artillery int32, morale float64) {
+	total := 0.0
+	for _, army := range []*fileio.Army{tile.Infantry, tile.Artillery} {
+		if army == nil {
+			continue
+		}
+		infantry += army.UnitInfantry
+		artillery += army.UnitArtillery
+		units := float64(army.UnitInfantry + army.UnitArtillery)
+		morale += float64(army.Morale) * units
+		total += units
+	}
+	if total > 0 {
+		morale /= total
+	} else {
+		morale = RecruitMorale
+	}
+	return infantry, artillery, morale
+}
+
+// StackStrength is the combat strength of the armies on a tile before terrain and luck
+func StackStrength(tile *fileio.MapTile) float64 {
+	infantry, artillery, morale := stackUnits(tile)
+	return (float64(infantry)*InfantryStrength + float64(artillery)*ArtilleryStrength) * morale
+}
+
+func defenseMultiplier(tile *fileio.MapTile) float64 {
+	if isSettlement(tile) {
+		return SettlementDefense
+	}
+	if tile.TileType == fileio.Forest {
+		return ForestDefense
+	}
+	return 1
+}
+
+func clampMorale(morale float32) float32 {
+	return float32(math.Max(MinMorale, math.Min(MaxMorale, float64(morale))))
+}
+
+// scaleStack keeps the given fraction of every army on the tile, removing armies with no units left
+func scaleStack(tile *fileio.MapTile, fraction float64, moraleChange float32) {
+	for _, army := range []*fileio.Army{tile.Infantry, tile.Artillery} {
+		if army == nil {
+			continue
+		}
+		army.UnitInfantry = int32(math.Round(float64(army.UnitInfantry) * fraction))
+		army.UnitArtillery = int32(math.Round(float64(army.UnitArtillery) * fraction))
+		army.Morale = clampMorale(army.Morale + moraleChange)
+	}
+	if tile.Infantry != nil && tile.Infantry.UnitInfantry+tile.Infantry.UnitArtillery <= 0 {
+		tile.Infantry = nil
+		tile.HasInfantry = false
+	}
+	if tile.Artillery != nil && tile.Artillery.UnitInfantry+tile.Artillery.UnitArtillery <= 0 {
+		tile.Artillery = nil
+		tile.HasArtillery = false
+	}
+}
+
+func removeStack(tile *fileio.MapTile) {
+	tile.Infantry = nil
+	tile.Artillery = nil
+	tile.HasInfantry = false
+	tile.HasArtillery = false
+}
+
+// mergeArmy adds the units of army into the slot, averaging morale by unit count
+func mergeArmy(slot **fileio.Army, army *fileio.Army, x, z int) {
+	if army == nil {
+		return
+	}
+	if *slot == nil {
+		moved := *army
+		*slot = &moved
+	} else {
+		existing := *slot
+		existingUnits := float32(existing.UnitInfantry + existing.UnitArtillery)
+		addedUnits := float32(army.UnitInfantry + army.UnitArtillery)
+		if existingUnits+addedUnits > 0 {
+			existing.Morale = (existing.Morale*existingUnits + army.Morale*addedUnits) / (existingUnits + addedUnits)
+		}
+		existing.UnitInfantry += army.UnitInfantry
+		existing.UnitArtillery += army.UnitArtillery
+	}
+	(*slot).X = int32(x)
+	(*slot).Y = int32(z)
+}
+
+// moveStack moves all armies from one tile onto another tile, merging with any armies there
+func moveStack(from *fileio.MapTile, to *fileio.MapTile, toX, toZ int) {
+	mergeArmy(&to.Infantry, from.Infantry, toX, toZ)
+	mergeArmy(&to.Artillery, from.Artillery, toX, toZ)
+	to.HasInfantry = to.Infantry != nil
+	to.HasArtillery = to.Artillery != nil
+	removeStack(from)
+}
+
+// recruit adds units to the tile's armies, creating them if needed
+func recruit(tile *fileio.MapTile, x, z int) {
+	infantry, artillery, _ := stackUnits(tile)
+	if infantry+artillery >= MaxGarrison {
+		return
+	}
+	if infantry := RecruitInfantry[tile.TileType]; infantry > 0 {
+		mergeArmy(&tile.Infantry, &fileio.Army{UnitInfantry: infantry, Morale: RecruitMorale}, x, z)
+		tile.HasInfantry = true
+	}
+	if artillery := RecruitArtillery[tile.TileType]; artillery > 0 {
+		mergeArmy(&tile.Artillery, &fileio.Army{UnitArtillery: artillery, Morale: RecruitMorale}, x, z)
+		tile.HasArtillery = true
+	}
+}
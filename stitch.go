@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// parseStitchPiece reads a map argument such as "maps/India.he3@60,0". The offset defaults to 0,0.
+func parseStitchPiece(argument string) (fileio.StitchPiece, error) {
+	filename, offset, found := strings.Cut(argument, "@")
+	piece := fileio.StitchPiece{}
+	if found {
+		values, err := parseInts("offset of "+filename, offset, 2)
+		if err != nil {
+			return piece, err
+		}
+		piece.X, piece.Z = values[0], values[1]
+	}
+	piece.Map = fileio.ReadHE3Map(filename)
+	return piece, nil
+}
+
+// parsePartyTable reads a party mapping such as "1:0=2,1:1=3", which gives parties 0 and 1
+// of the second map the numbers 2 and 3. Maps are numbered from 0 in the order they are given.
+func parsePartyTable(text string, pieces []fileio.StitchPiece) error {
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	for _, entry := range strings.Split(text, ",") {
+		entry = strings.TrimSpace(entry)
+		mapIndex, mapping, found := strings.Cut(entry, ":")
+		party, newParty, found2 := strings.Cut(mapping, "=")
+		if !found || !found2 {
+			return fmt.Errorf("party mapping %q must look like map:party=newparty", entry)
+		}
+		values := [3]int{}
+		for i, part := range []string{mapIndex, party, newParty} {
+			value, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil {
+				return fmt.Errorf("party mapping %q must look like map:party=newparty", entry)
+			}
+			values[i] = value
+		}
+		if values[0] < 0 || values[0] >= len(pieces) {
+			return fmt.Errorf("party mapping %q refers to map %d, but there are %d maps", entry, values[0], len(pieces))
+		}
+		if pieces[values[0]].Parties == nil {
+			pieces[values[0]].Parties = map[int]int{}
+		}
+		pieces[values[0]].Parties[values[1]] = values[2]
+	}
+	return nil
+}
+
+// runStitch joins maps into one map and checks that the result is valid
+func runStitch(arguments []string, partyTable string, outputFilename string) error {
+	if len(arguments) == 0 {
+		return fmt.Errorf("no maps to stitch")
+	}
+	pieces := make([]fileio.StitchPiece, 0, len(arguments))
+	for _, argument := range arguments {
+		piece, err := parseStitchPiece(argument)
+		if err != nil {
+			return err
+		}
+		pieces = append(pieces, piece)
+	}
+	if err := parsePartyTable(partyTable, pieces); err != nil {
+		return err
+	}
+	mapData, err := fileio.Stitch(pieces)
+	if err != nil {
+		return err
+	}
+
+	report := validateMap(mapData)
+	if err := writeValidationText(os.Stdout, report); err != nil {
+		return err
+	}
+	if !report.Valid {
+		return fmt.Errorf("stitched map is not valid")
+	}
+	fmt.Printf("Stitched %d maps into a %dx%d map\n", len(pieces), mapData.Width, mapData.Depth)
+	return fileio.WriteHE3File(outputFilename, mapData)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/fogleman/gg"
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	ChokepointPass       = "pass"
+	ChokepointLandBridge = "land-bridge"
+	ChokepointCorridor   = "corridor"
+	ChokepointStrait     = "strait"
+	// MinChokepointSide is the fewest tiles that must be cut off on each side of a
+	// chokepoint, so that the tips of peninsulas and bays aren't reported
+	MinChokepointSide = 3
+	// FrontlineAlpha is the opacity of the party colors on frontline hexes
+	FrontlineAlpha = 170
+)
+
+// Frontline is the border between the territories of two parties
+type Frontline struct {
+	Parties [2]int `json:"parties"`
+	// Tiles of either party next to a tile of the other party
+	Tiles [][2]int `json:"tiles"`
+}
+
+// Chokepoint is a tile that splits land or sea into separate areas when it is blocked.
+// Land chokepoints are found on the tiles armies can cross, which excludes sea and mountains.
+type Chokepoint struct {
+	X    int    `json:"x"`
+	Z    int    `json:"z"`
+	Kind string `json:"kind"`
+	// Sizes of the areas the tile separates, largest first
+	Areas []int `json:"areas"`
+	// Tiles in the second largest area, which is how much the chokepoint cuts off
+	CutOff int `json:"cutOff"`
+}
+
+type StrategicReport struct {
+	Frontlines  []Frontline  `json:"frontlines"`
+	Chokepoints []Chokepoint `json:"chokepoints"`
+}
+
+func isPassableLand(tile *fileio.MapTile) bool {
+	return !tile.IsSea && !tile.IsMountain
+}
+
+// findFrontlines returns the borders between each pair of parties that touch over land.
+// Tiles owned by parties the game doesn't have are skipped.
+func findFrontlines(mapData *MapData) []Frontline {
+	tiles := map[[2]int]map[[2]int]bool{}
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			tile := mapData.At(x, z)
+			if tile.IsSea || tile.Party < 0 || tile.Party >= len(PartyColors) {
+				continue
+			}
+			for _, neighbor := range getNeighbors(x, z) {
+				newX, newZ := neighbor[0], neighbor[1]
+				if !isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) {
+					continue
+				}
+				other := mapData.At(newX, newZ)
+				if other.IsSea || other.Party < 0 || other.Party >= len(PartyColors) || other.Party == tile.Party {
+					continue
+				}
+				parties := [2]int{min(tile.Party, other.Party), max(tile.Party, other.Party)}
+				if tiles[parties] == nil {
+					tiles[parties] = map[[2]int]bool{}
+				}
+				tiles[parties][[2]int{x, z}] = true
+			}
+		}
+	}
+
+	frontlines := []Frontline{}
+	for parties, tileSet := range tiles {
+		frontline := Frontline{Parties: parties, Tiles: [][2]int{}}
+		for tile := range tileSet {
+			frontline.Tiles = append(frontline.Tiles, tile)
+		}
+		sort.Slice(frontline.Tiles, func(i, j int) bool {
+			a, b := frontline.Tiles[i], frontline.Tiles[j]
+			return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+		})
+		frontlines = append(frontlines, frontline)
+	}
+	sort.Slice(frontlines, func(i, j int) bool {
+		a, b := frontlines[i].Parties, frontlines[j].Parties
+		return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+	})
+	return frontlines
+}
+
+// articulationFrame is a tile on the depth first search stack of findArticulationTiles
+type articulationFrame struct {
+	x, z             int
+	parentX, parentZ int
+	// Index of the next neighbor to look at
+	next int
+	// Sizes of the subtrees that only connect to the rest of the area through this tile
+	cutOff []int
+}
+
+// findArticulationTiles returns the tiles that split the tiles where include is true into
+// separate areas, with the sizes of those areas
+func findArticulationTiles(mapData *MapData, include func(tile *fileio.MapTile) bool) map[[2]int][]int {
+	order := make([][]int, mapData.Width)
+	low := make([][]int, mapData.Width)
+	size := make([][]int, mapData.Width)
+	for x := range order {
+		order[x] = make([]int, mapData.Depth)
+		low[x] = make([]int, mapData.Depth)
+		size[x] = make([]int, mapData.Depth)
+		for z := range order[x] {
+			order[x][z] = -1
+		}
+	}
+	areas := map[[2]int][]int{}
+	counter := 0
+
+	// Depth first search with low links. size is the number of tiles below each tile in
+	// the search tree. A child whose subtree can't reach above the tile is cut off by it.
+	// The search keeps its own stack, since a large map is too deep to recurse through.
+	stack := []articulationFrame{}
+	enter := func(x, z, parentX, parentZ int) {
+		order[x][z] = counter
+		low[x][z] = counter
+		size[x][z] = 1
+		counter++
+		stack = append(stack, articulationFrame{x: x, z: z, parentX: parentX, parentZ: parentZ})
+	}
+	// visit searches the area that contains (x, z) and returns its articulation tiles
+	visit := func(x, z int) [][2]int {
+		found := [][2]int{}
+		enter(x, z, -1, -1)
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.next < 6 {
+				neighbor := getNeighbors(top.x, top.z)[top.next]
+				top.next++
+				newX, newZ := neighbor[0], neighbor[1]
+				if !isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) || !include(mapData.At(newX, newZ)) ||
+					(newX == top.parentX && newZ == top.parentZ) {
+					continue
+				}
+				if order[newX][newZ] >= 0 {
+					low[top.x][top.z] = min(low[top.x][top.z], order[newX][newZ])
+					continue
+				}
+				enter(newX, newZ, top.x, top.z)
+				continue
+			}
+
+			child := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(child.cutOff) > 0 {
+				areas[[2]int{child.x, child.z}] = child.cutOff
+				found = append(found, [2]int{child.x, child.z})
+			}
+			if len(stack) == 0 {
+				break
+			}
+			parent := &stack[len(stack)-1]
+			low[parent.x][parent.z] = min(low[parent.x][parent.z], low[child.x][child.z])
+			size[parent.x][parent.z] += size[child.x][child.z]
+			if low[child.x][child.z] >= order[parent.x][parent.z] {
+				parent.cutOff = append(parent.cutOff, size[child.x][child.z])
+			}
+		}
+		return found
+	}
+
+	for x := 0; x < mapData.Width; x++ {
+		for z := 0; z < mapData.Depth; z++ {
+			if order[x][z] >= 0 || !include(mapData.At(x, z)) {
+				continue
+			}
+			found := visit(x, z)
+			root := [2]int{x, z}
+			total := size[x][z]
+			// The first tile has every other tile below it, so it only splits the area
+			// when more than one subtree hangs off it
+			if len(areas[root]) < 2 {
+				delete(areas, root)
+			}
+			// The rest of the area is on the other side of the tiles found in this search
+			for _, position := range found {
+				cutOff, ok := areas[position]
+				if position == root || !ok {
+					continue
+				}
+				rest := total - 1
+				for _, area := range cutOff {
+					rest -= area
+				}
+				if rest > 0 {
+					areas[position] = append(cutOff, rest)
+				}
+			}
+		}
+	}
+	return areas
+}
+
+func chokepointKind(mapData *MapData, x, z int) string {
+	if mapData.At(x, z).IsSea {
+		return ChokepointStrait
+	}
+	kind := ChokepointCorridor
+	for _, neighbor := range getNeighbors(x, z) {
+		newX, newZ := neighbor[0], neighbor[1]
+		if !isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) {
+			continue
+		}
+		if mapData.At(newX, newZ).IsMountain {
+			return ChokepointPass
+		}
+		if mapData.At(newX, newZ).IsSea {
+			kind = ChokepointLandBridge
+		}
+	}
+	return kind
+}
+
+// findChokepoints returns the land and sea tiles that cut off at least MinChokepointSide
+// tiles, sorted by how much they cut off
+func findChokepoints(mapData *MapData) []Chokepoint {
+	chokepoints := []Chokepoint{}
+	for _, include := range []func(tile *fileio.MapTile) bool{
+		isPassableLand,
+		func(tile *fileio.MapTile) bool { return tile.IsSea },
+	} {
+		for position, areas := range findArticulationTiles(mapData, include) {
+			sort.Sort(sort.Reverse(sort.IntSlice(areas)))
+			if len(areas) < 2 || areas[1] < MinChokepointSide {
+				continue
+			}
+			chokepoints = append(chokepoints, Chokepoint{
+				X:      position[0],
+				Z:      position[1],
+				Kind:   chokepointKind(mapData, position[0], position[1]),
+				Areas:  areas,
+				CutOff: areas[1],
+			})
+		}
+	}
+	sort.Slice(chokepoints, func(i, j int) bool {
+		a, b := chokepoints[i], chokepoints[j]
+		if a.CutOff != b.CutOff {
+			return a.CutOff > b.CutOff
+		}
+		return a.X < b.X || (a.X == b.X && a.Z < b.Z)
+	})
+	return chokepoints
+}
+
+func analyzeStrategic(mapData *MapData) *StrategicReport {
+	return &StrategicReport{
+		Frontlines:  findFrontlines(mapData),
+		Chokepoints: findChokepoints(mapData),
+	}
+}
+
+func writeStrategicText(w io.Writer, report *StrategicReport) error {
+	for _, frontline := range report.Frontlines {
+		fmt.Fprintf(w, "frontline between party %d and party %d: %d tiles\n",
+			frontline.Parties[0], frontline.Parties[1], len(frontline.Tiles))
+	}
+	for _, chokepoint := range report.Chokepoints {
+		fmt.Fprintf(w, "%s (%d,%d) separates areas of %v tiles\n", chokepoint.Kind, chokepoint.X, chokepoint.Z, chokepoint.Areas)
+	}
+	_, err := fmt.Fprintf(w, "%d frontlines, %d chokepoints\n", len(report.Frontlines), len(report.Chokepoints))
+	return err
+}
+
+// drawStrategic colors frontline hexes with their party and outlines chokepoints, yellow
+// on land and white at sea
+func drawStrategic(mapData *MapData, report *StrategicReport, outputFilename string) error {
+	dc := renderMap(mapData, func(dc *gg.Context) {
+		for _, frontline := range report.Frontlines {
+			for _, position := range frontline.Tiles {
+				color := PartyColors[mapData.At(position[0], position[1]).Party]
+				fillHex(dc, position[0], position[1], color[0], color[1], color[2], FrontlineAlpha)
+			}
+		}
+		for _, chokepoint := range report.Chokepoints {
+			if chokepoint.Kind == ChokepointStrait {
+				drawHexOutline(dc, chokepoint.X, chokepoint.Z, 255, 255, 255)
+			} else {
+				drawHexOutline(dc, chokepoint.X, chokepoint.Z, 255, 230, 0)
+			}
+		}
+	})
+	return dc.SavePNG(outputFilename)
+}
+
+func runStrategic(inputFilename, outputFilename, format, reportFilename string) error {
+	mapData := newMapData(fileio.ReadCompactHE3Map(inputFilename))
+	report := analyzeStrategic(mapData)
+	err := writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, report)
+		}
+		return writeStrategicText(w, report)
+	})
+	if err != nil {
+		return err
+	}
+	if outputFilename == "" {
+		return nil
+	}
+	return drawStrategic(mapData, report, outputFilename)
+}
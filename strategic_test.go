@@ -0,0 +1,85 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+func TestFrontlinesSkipUnknownParties(t *testing.T) {
+	he3Map := fileio.NewHE3Map(3, 2)
+	for x := 0; x < 3; x++ {
+		for z := 0; z < 2; z++ {
+			he3Map.At(x, z).SetHeight(0.3)
+		}
+	}
+	he3Map.At(0, 0).Party = 0
+	he3Map.At(1, 0).Party = 1
+	// Made with fromtext or a transform, which can write parties the game doesn't have
+	he3Map.At(2, 0).Party = 7
+	mapData := newMapData(he3Map)
+
+	report := analyzeStrategic(mapData)
+	expected := []Frontline{{Parties: [2]int{0, 1}, Tiles: [][2]int{{0, 0}, {1, 0}}}}
+	if !reflect.DeepEqual(report.Frontlines, expected) {
+		t.Errorf("got frontlines %+v, expected %+v", report.Frontlines, expected)
+	}
+	if err := drawStrategic(mapData, report, filepath.Join(t.TempDir(), "strategic.png")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// chokepointTestMap is a 7x4 map of the filler height, split by column x=3 of the wall
+// height except at (3,0), which joins the two halves
+func chokepointTestMap(filler, wall float32) *MapData {
+	he3Map := fileio.NewHE3Map(7, 4)
+	for x := 0; x < 7; x++ {
+		for z := 0; z < 4; z++ {
+			he3Map.At(x, z).SetHeight(filler)
+		}
+	}
+	for z := 1; z < 4; z++ {
+		he3Map.At(3, z).SetHeight(wall)
+	}
+	return newMapData(he3Map)
+}
+
+func TestChokepoints(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapData *MapData
+		kind    string
+	}{
+		{"land bridge", chokepointTestMap(0.3, 0), ChokepointLandBridge},
+		{"pass", chokepointTestMap(0.3, 0.8), ChokepointPass},
+		{"strait", chokepointTestMap(0, 0.3), ChokepointStrait},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// (3,0) joins both halves of 12 tiles. (4,0) is the only tile of the right half
+			// next to it, so it cuts the other 11 tiles of that half off from the rest.
+			expected := []Chokepoint{
+				{X: 3, Z: 0, Kind: test.kind, Areas: []int{12, 12}, CutOff: 12},
+				{X: 4, Z: 0, Kind: test.kind, Areas: []int{13, 11}, CutOff: 11},
+			}
+			if chokepoints := findChokepoints(test.mapData); !reflect.DeepEqual(chokepoints, expected) {
+				t.Errorf("got chokepoints %+v, expected %+v", chokepoints, expected)
+			}
+		})
+	}
+}
+
+func TestChokepointsOnLargeMap(t *testing.T) {
+	// Every tile is one deeper in the search, which is too deep to recurse through
+	he3Map := fileio.NewHE3Map(fileio.MAX_MAP_DIMENSION, fileio.MAX_MAP_DIMENSION)
+	for x := 0; x < fileio.MAX_MAP_DIMENSION; x++ {
+		for z := 0; z < fileio.MAX_MAP_DIMENSION; z++ {
+			he3Map.At(x, z).SetHeight(0.3)
+		}
+	}
+	if chokepoints := findChokepoints(newMapData(he3Map)); len(chokepoints) != 0 {
+		t.Errorf("got %d chokepoints on a map without any", len(chokepoints))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// svgPosition returns the position of tile (x, z) with y inverted like the PNG render
+func svgPosition(x, z int, imageHeight float64) (float64, float64) {
+	imageX, imageY := getImagePosition(z, x)
+	return imageX, imageHeight - imageY
+}
+
+func svgPolygon(writer *bufio.Writer, sides int, x, y, radius, rotation float64, fill string) {
+	writer.WriteString(`<polygon points="`)
+	for i := 0; i < sides; i++ {
+		angle := rotation + 2*math.Pi*float64(i)/float64(sides)
+		fmt.Fprintf(writer, "%.2f,%.2f ", x+radius*math.Cos(angle), y+radius*math.Sin(angle))
+	}
+	fmt.Fprintf(writer, `" fill="%s"/>`+"\n", fill)
+}
+
+func svgColor(r, g, b int) string {
+	return fmt.Sprintf("rgb(%d,%d,%d)", r, g, b)
+}
+
+// writeSVG renders the map as an SVG image with the same layers as drawMap
+func writeSVG(w io.Writer, mapData *MapData) error {
+	imageWidth, imageHeight := getImagePosition(mapData.Depth, mapData.Width)
+	writer := bufio.NewWriter(w)
+	fmt.Fprintf(writer, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		int(imageWidth), int(imageHeight), int(imageWidth), int(imageHeight))
+
+	writer.WriteString(`<g id="tiles">` + "\n")
+	for i := 0; i < mapData.Depth; i++ {
+		for j := 0; j < mapData.Width; j++ {
+			x, y := svgPosition(j, i, imageHeight)
+			tile := mapData.At(j, i)
+			r, g, b := getTileColor(tile, mapData, j, i)
+			// The PNG is drawn with y inverted, which mirrors the rotation of each shape
+			svgPolygon(writer, 6, x, y, HexRadius, -math.Pi/2, svgColor(r, g, b))
+
+			if tile.IsMountain {
+				svgPolygon(writer, 3, x, y, HexRadius, -math.Pi, svgColor(89, 90, 86))
+				svgPolygon(writer, 3, x, y-(HexRadius/2), HexRadius/2, -math.Pi, svgColor(234, 244, 253))
+			}
+
+			if tile.TileType == fileio.Factory || tile.TileType == fileio.City || tile.TileType == fileio.Town {
+				if tile.HasFlag && tile.Party >= 0 && tile.Party < len(PartyColors) {
+					color := PartyColors[tile.Party]
+					fmt.Fprintf(writer, `<circle cx="%.2f" cy="%.2f" r="%.2f" fill="%s"/>`+"\n",
+						x, y, HexRadius/2, svgColor(color[0], color[1], color[2]))
+				} else {
+					fmt.Fprintf(writer, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="white"/>`+"\n",
+						x-2.0, y+2.0-HexRadius/2, HexRadius/2, HexRadius/2)
+				}
+			}
+		}
+	}
+	writer.WriteString("</g>\n")
+
+	writer.WriteString(`<g id="roads" stroke="rgb(78,53,36)" stroke-width="1">` + "\n")
+	for i := 0; i < mapData.Depth; i++ {
+		for j := 0; j < mapData.Width; j++ {
+			if !mapData.At(j, i).HasRoad {
+				continue
+			}
+			x1, y1 := svgPosition(j, i, imageHeight)
+			neighbors := getNeighbors(j, i)
+			for n := 0; n < len(neighbors); n++ {
+				newX := neighbors[n][0]
+				newZ := neighbors[n][1]
+				if isValidNeighbor(newX, newZ, mapData.Width, mapData.Depth) && shouldDrawRoad(mapData.At(newX, newZ)) {
+					x2, y2 := svgPosition(newX, newZ, imageHeight)
+					fmt.Fprintf(writer, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f"/>`+"\n", x1, y1, x2, y2)
+				}
+			}
+		}
+	}
+	writer.WriteString("</g>\n")
+
+	writer.WriteString(`<g id="names" fill="white" font-family="sans-serif" font-size="10">` + "\n")
+	for i := 0; i < mapData.Depth; i++ {
+		for j := 0; j < mapData.Width; j++ {
+			tile := mapData.At(j, i)
+			if tile.CityName == "" {
+				continue
+			}
+			x, y := svgPosition(j, i, imageHeight)
+			fmt.Fprintf(writer, `<text x="%.2f" y="%.2f">%s</text>`+"\n",
+				x-(5.0*float64(len(tile.CityName))/2.0), y-HexRadius, html.EscapeString(tile.CityName))
+		}
+	}
+	writer.WriteString("</g>\n</svg>\n")
+	return writer.Flush()
+}
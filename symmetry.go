@@ -0,0 +1,398 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/fogleman/gg"
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	SymmetryMirrorH   = "mirror-h"
+	SymmetryMirrorV   = "mirror-v"
+	SymmetryRotate180 = "rotate180"
+	SymmetryRotate3   = "rotate3"
+	SymmetryRotate6   = "rotate6"
+)
+
+// Symmetry describes how the designed part of a map is copied to fill the rest of it
+type Symmetry struct {
+	Name string
+	// Number of copies including the designed part
+	Copies int
+	// inBase returns true for the tiles of the designed part
+	inBase func(x, z int) bool
+	// image returns the position of copy k (1 to Copies-1) of tile (x, z)
+	image func(x, z, k int) (int, int)
+}
+
+func offsetToAxial(x, z int) (int, int) {
+	return x - (z-(z&1))/2, z
+}
+
+func axialToOffset(q, r int) (int, int) {
+	return q + (r-(r&1))/2, r
+}
+
+// rotateHex turns tile (x, z) by steps sixths of a turn around tile (centerX, centerZ)
+func rotateHex(x, z, centerX, centerZ, steps int) (int, int) {
+	q, r := offsetToAxial(x, z)
+	centerQ, centerR := offsetToAxial(centerX, centerZ)
+	dq, dr := q-centerQ, r-centerR
+	ds := -dq - dr
+	for i := 0; i < steps%6; i++ {
+		dq, dr, ds = -dr, -ds, -dq
+	}
+	return axialToOffset(centerQ+dq, centerR+dr)
+}
+
+// hexAngle returns the angle from the center tile to tile (x, z) between 0 and 2 pi,
+// using the drawn positions of the hexes
+func hexAngle(x, z, centerX, centerZ int) float64 {
+	dx := float64(x) + 0.5*float64(z&1) - float64(centerX) - 0.5*float64(centerZ&1)
+	dy := float64(z-centerZ) * math.Sqrt(3) / 2
+	angle := math.Atan2(dy, dx)
+	// Tiles on the start of a sector belong to it, even with rounding errors
+	if angle < -1e-9 {
+		angle += 2 * math.Pi
+	}
+	return math.Max(angle, 0)
+}
+
+// newSymmetry returns the symmetry for a map size. The rotations with 3 and 6 copies turn
+// around the center tile, the other symmetries use the middle of the map like the transforms.
+func newSymmetry(name string, width, depth, centerX, centerZ int) (*Symmetry, error) {
+	switch name {
+	case SymmetryMirrorH:
+		return &Symmetry{
+			Name:   name,
+			Copies: 2,
+			inBase: func(x, z int) bool {
+				return 2*x+(z&1) <= width-1
+			},
+			image: func(x, z, k int) (int, int) {
+				return width - 1 - x - (z & 1), z
+			},
+		}, nil
+	case SymmetryMirrorV:
+		return &Symmetry{
+			Name:   name,
+			Copies: 2,
+			inBase: func(x, z int) bool {
+				return 2*z <= depth-1
+			},
+			image: func(x, z, k int) (int, int) {
+				if depth%2 == 0 {
+					return x + (z & 1), depth - 1 - z
+				}
+				return x, depth - 1 - z
+			},
+		}, nil
+	case SymmetryRotate180:
+		return &Symmetry{
+			Name:   name,
+			Copies: 2,
+			inBase: func(x, z int) bool {
+				if 2*z == depth-1 {
+					return 2*x+(z&1) <= width-1
+				}
+				return 2*z < depth-1
+			},
+			image: func(x, z, k int) (int, int) {
+				if depth%2 == 1 {
+					return width - 1 - x - (z & 1), depth - 1 - z
+				}
+				return width - 1 - x, depth - 1 - z
+			},
+		}, nil
+	case SymmetryRotate3, SymmetryRotate6:
+		copies := 3
+		if name == SymmetryRotate6 {
+			copies = 6
+		}
+		sector := 2 * math.Pi / float64(copies)
+		return &Symmetry{
+			Name:   name,
+			Copies: copies,
+			inBase: func(x, z int) bool {
+				return (x == centerX && z == centerZ) || hexAngle(x, z, centerX, centerZ) < sector-1e-9
+			},
+			image: func(x, z, k int) (int, int) {
+				return rotateHex(x, z, centerX, centerZ, k*6/copies)
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown symmetry %q, expected mirror-h, mirror-v, rotate180, rotate3 or rotate6", name)
+}
+
+func isInsideMap(mapData *fileio.HE3Map, x, z int) bool {
+	return isValidNeighbor(x, z, int(mapData.Width), int(mapData.Depth))
+}
+
+// copySymmetricTile copies a tile to (x, z) with its armies moved along
+func copySymmetricTile(tile *fileio.MapTile, x, z int) *fileio.MapTile {
+	copied := tile.Clone()
+	for _, army := range []*fileio.Army{copied.Infantry, copied.Artillery} {
+		if army != nil {
+			army.X = int32(x)
+			army.Y = int32(z)
+		}
+	}
+	return copied
+}
+
+// copyCityName adds the copy number to a city name, keeping it short enough to save
+func copyCityName(name string, copyIndex int) string {
+	if name == "" {
+		return ""
+	}
+	suffix := fmt.Sprintf(" %d", copyIndex+1)
+	if len(name)+len(suffix) > MaxStringLength {
+		name = name[:MaxStringLength-len(suffix)]
+	}
+	return name + suffix
+}
+
+// generateSymmetric fills the map from its designed part. The parties of the designed part
+// are numbered from 0, and each copy gets the next parties. City names on the copies get
+// the copy number as a suffix. Returns warnings for land that didn't fit on the map.
+func generateSymmetric(base *fileio.HE3Map, symmetry *Symmetry) (*fileio.HE3Map, []string, error) {
+	width, depth := int(base.Width), int(base.Depth)
+	partyIndex := map[int]int{}
+	parties := []int{}
+	for x := 0; x < width; x++ {
+		for z := 0; z < depth; z++ {
+			party := base.At(x, z).Party
+			if _, ok := partyIndex[party]; symmetry.inBase(x, z) && party >= 0 && !ok {
+				partyIndex[party] = -1
+				parties = append(parties, party)
+			}
+		}
+	}
+	sort.Ints(parties)
+	for i, party := range parties {
+		partyIndex[party] = i
+	}
+	if len(parties)*symmetry.Copies > len(PartyColors) {
+		return nil, nil, fmt.Errorf("%d parties in %d copies need %d parties, but the game has %d",
+			len(parties), symmetry.Copies, len(parties)*symmetry.Copies, len(PartyColors))
+	}
+
+	result := fileio.CopyMetadata(base, base.Width, base.Depth)
+	filled := map[[2]int]int{}
+	warnings := []string{}
+	for x := 0; x < width; x++ {
+		for z := 0; z < depth; z++ {
+			if !symmetry.inBase(x, z) {
+				continue
+			}
+			tile := base.At(x, z)
+			for k := 0; k < symmetry.Copies; k++ {
+				newX, newZ := x, z
+				if k > 0 {
+					newX, newZ = symmetry.image(x, z, k)
+				}
+				if !isInsideMap(base, newX, newZ) {
+					if !tile.IsSea {
+						warnings = append(warnings, fmt.Sprintf("copy %d of land tile (%d,%d) is outside the map", k+1, x, z))
+					}
+					continue
+				}
+				if k > 0 && symmetry.inBase(newX, newZ) {
+					// Tiles on a mirror axis are their own copy
+					continue
+				}
+				if other, ok := filled[[2]int{newX, newZ}]; ok && other != k {
+					warnings = append(warnings, fmt.Sprintf("copies %d and %d both cover tile (%d,%d)", other+1, k+1, newX, newZ))
+				}
+				filled[[2]int{newX, newZ}] = k
+
+				copied := copySymmetricTile(tile, newX, newZ)
+				if tile.Party >= 0 {
+					copied.Party = partyIndex[tile.Party] + k*len(parties)
+				}
+				if k > 0 {
+					copied.CityName = copyCityName(tile.CityName, k)
+				}
+				result.Set(newX, newZ, *copied)
+			}
+		}
+	}
+	return result, warnings, nil
+}
+
+type SymmetryIssue struct {
+	X int `json:"x"`
+	Z int `json:"z"`
+	// Designed tile that this tile should be a copy of
+	BaseX   int           `json:"baseX"`
+	BaseZ   int           `json:"baseZ"`
+	Message string        `json:"message"`
+	Changes []FieldChange `json:"changes"`
+}
+
+type SymmetryReport struct {
+	Symmetry  string          `json:"symmetry"`
+	Symmetric bool            `json:"symmetric"`
+	Issues    []SymmetryIssue `json:"issues"`
+}
+
+// checkSymmetry compares every copied tile with the tile it is a copy of. Each copy may
+// use different parties as long as every designed party always becomes the same party.
+// City names are not compared.
+func checkSymmetry(mapData *fileio.HE3Map, symmetry *Symmetry) *SymmetryReport {
+	report := &SymmetryReport{Symmetry: symmetry.Name, Symmetric: true, Issues: []SymmetryIssue{}}
+	addIssue := func(issue SymmetryIssue) {
+		report.Issues = append(report.Issues, issue)
+		report.Symmetric = false
+	}
+
+	width, depth := int(mapData.Width), int(mapData.Depth)
+	partyMapping := make([]map[int]int, symmetry.Copies)
+	covered := map[[2]int]bool{}
+	for x := 0; x < width; x++ {
+		for z := 0; z < depth; z++ {
+			if !symmetry.inBase(x, z) {
+				continue
+			}
+			tile := mapData.At(x, z)
+			for k := 1; k < symmetry.Copies; k++ {
+				if partyMapping[k] == nil {
+					partyMapping[k] = map[int]int{}
+				}
+				newX, newZ := symmetry.image(x, z, k)
+				if !isInsideMap(mapData, newX, newZ) {
+					if !tile.IsSea {
+						addIssue(SymmetryIssue{X: x, Z: z, BaseX: x, BaseZ: z,
+							Message: fmt.Sprintf("copy %d of this land tile is outside the map", k+1)})
+					}
+					continue
+				}
+				if symmetry.inBase(newX, newZ) {
+					continue
+				}
+				covered[[2]int{newX, newZ}] = true
+
+				copied := mapData.At(newX, newZ)
+				expected := copySymmetricTile(tile, newX, newZ)
+				expected.CityName = copied.CityName
+				if tile.Party >= 0 {
+					if party, ok := partyMapping[k][tile.Party]; ok {
+						expected.Party = party
+					} else if copied.Party >= 0 {
+						partyMapping[k][tile.Party] = copied.Party
+						expected.Party = copied.Party
+					}
+				}
+				if changes := diffTiles(expected, copied); len(changes) > 0 {
+					addIssue(SymmetryIssue{X: newX, Z: newZ, BaseX: x, BaseZ: z,
+						Message: fmt.Sprintf("copy %d differs", k+1), Changes: changes})
+				}
+			}
+		}
+	}
+
+	for x := 0; x < width; x++ {
+		for z := 0; z < depth; z++ {
+			if !symmetry.inBase(x, z) && !covered[[2]int{x, z}] && !mapData.At(x, z).IsSea {
+				addIssue(SymmetryIssue{X: x, Z: z, BaseX: -1, BaseZ: -1,
+					Message: "land tile is not a copy of any designed tile"})
+			}
+		}
+	}
+	return report
+}
+
+func writeSymmetryText(w io.Writer, report *SymmetryReport) error {
+	for _, issue := range report.Issues {
+		if issue.BaseX >= 0 && (issue.BaseX != issue.X || issue.BaseZ != issue.Z) {
+			fmt.Fprintf(w, "(%d,%d) from (%d,%d): %s\n", issue.X, issue.Z, issue.BaseX, issue.BaseZ, issue.Message)
+		} else {
+			fmt.Fprintf(w, "(%d,%d): %s\n", issue.X, issue.Z, issue.Message)
+		}
+		for _, change := range issue.Changes {
+			fmt.Fprintf(w, "  %s: expected %s, found %s\n", change.Field, change.Old, change.New)
+		}
+	}
+	if report.Symmetric {
+		_, err := fmt.Fprintf(w, "Map has %s symmetry\n", report.Symmetry)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%d tiles break %s symmetry\n", len(report.Issues), report.Symmetry)
+	return err
+}
+
+func drawSymmetryIssues(mapData *fileio.HE3Map, report *SymmetryReport, outputFilename string) error {
+	dc := renderMap(newMapData(mapData), func(dc *gg.Context) {
+		for _, issue := range report.Issues {
+			drawHexOutline(dc, issue.X, issue.Z, 255, 0, 255)
+		}
+	})
+	return dc.SavePNG(outputFilename)
+}
+
+// symmetryCenter returns the tile that rotations turn around, which defaults to the middle of the map
+func symmetryCenter(mapData *fileio.HE3Map, center string) (int, int, error) {
+	if center == "" {
+		return int(mapData.Width) / 2, int(mapData.Depth) / 2, nil
+	}
+	values, err := parseInts("center", center, 2)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !isInsideMap(mapData, int(values[0]), int(values[1])) {
+		return 0, 0, fmt.Errorf("center (%d,%d) is outside the map", values[0], values[1])
+	}
+	return int(values[0]), int(values[1]), nil
+}
+
+func runSymmetrize(inputFilename, outputFilename, symmetryName, center string) error {
+	base := fileio.ReadHE3Map(inputFilename)
+	centerX, centerZ, err := symmetryCenter(base, center)
+	if err != nil {
+		return err
+	}
+	symmetry, err := newSymmetry(symmetryName, int(base.Width), int(base.Depth), centerX, centerZ)
+	if err != nil {
+		return err
+	}
+	result, warnings, err := generateSymmetric(base, symmetry)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Println("Warning:", warning)
+	}
+	return fileio.WriteHE3File(outputFilename, result)
+}
+
+func runSymmetryCheck(inputFilename, symmetryName, center, format, reportFilename, highlightFilename string) (bool, error) {
+	mapData := fileio.ReadHE3Map(inputFilename)
+	centerX, centerZ, err := symmetryCenter(mapData, center)
+	if err != nil {
+		return false, err
+	}
+	symmetry, err := newSymmetry(symmetryName, int(mapData.Width), int(mapData.Depth), centerX, centerZ)
+	if err != nil {
+		return false, err
+	}
+	report := checkSymmetry(mapData, symmetry)
+	err = writeReport(reportFilename, func(w io.Writer) error {
+		if format == "json" {
+			return writeJSON(w, report)
+		}
+		return writeSymmetryText(w, report)
+	})
+	if err != nil {
+		return false, err
+	}
+	if highlightFilename != "" {
+		if err := drawSymmetryIssues(mapData, report, highlightFilename); err != nil {
+			return false, err
+		}
+	}
+	return report.Symmetric, nil
+}
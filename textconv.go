@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+func formatBit(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+// formatArmyFields writes an army as x,y,infantry,artillery,morale or - if there is no army
+func formatArmyFields(army *fileio.Army) string {
+	if army == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d,%d,%d,%d,%s",
+		army.X, army.Y, army.UnitInfantry, army.UnitArtillery, formatHeight(army.Morale))
+}
+
+// writeCanonicalText prints a stable line-oriented form of the map: a header
+// followed by one line per tile in file order, so that line diffs point at tiles
+func writeCanonicalText(w io.Writer, mapData *fileio.HE3Map) error {
+	writer := bufio.NewWriter(w)
+	fmt.Fprintf(writer, "title %s\n", strconv.Quote(mapData.MapTitle))
+	fmt.Fprintf(writer, "author %s\n", strconv.Quote(mapData.MapAuthor))
+	fmt.Fprintf(writer, "size %d %d\n", mapData.Width, mapData.Depth)
+	fmt.Fprintf(writer, "style %d %d %d %d %d\n",
+		mapData.MapStyle.Grass, mapData.MapStyle.Mountains, mapData.MapStyle.Desert,
+		mapData.MapStyle.Sea, mapData.MapStyle.Light)
+
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			tile := mapData.At(x, z)
+			fmt.Fprintf(writer, "tile %d %d %s height=%s road=%s flag=%s party=%d name=%s inf=%s art=%s\n",
+				x, z,
+				tile.TileType,
+				formatHeight(tile.Height),
+				formatBit(tile.HasRoad),
+				formatBit(tile.HasFlag),
+				tile.Party,
+				strconv.Quote(tile.CityName),
+				formatArmyFields(tile.Infantry),
+				formatArmyFields(tile.Artillery))
+		}
+	}
+	return writer.Flush()
+}
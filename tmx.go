@@ -0,0 +1,650 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+// Tiled stores hex maps with row 0 at the top, so rows are flipped to match the
+// rendered PNG. Odd z rows are shifted right (see getNeighbors), which becomes
+// the odd or even Tiled rows depending on the map depth.
+
+const (
+	TmxTileWidth      = 28
+	TmxTileHeight     = 32
+	TmxHexSideLength  = 16
+	TmxTilesetName    = "hexempire3"
+	TmxTilesetColumns = 16
+	// Tile IDs in the shared tileset
+	TmxRoadTile       = 10
+	TmxFirstPartyTile = 11
+	TmxTileCount      = TmxFirstPartyTile + len(PartyColors)
+	// Tiled stores tile flips in the top bits of a gid
+	TmxGIDMask = 0x0fffffff
+)
+
+type tmxProperty struct {
+	Name  string `xml:"name,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:"value,attr"`
+}
+
+type tmxImage struct {
+	Source string `xml:"source,attr"`
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+}
+
+type tmxTile struct {
+	ID         int           `xml:"id,attr"`
+	Properties []tmxProperty `xml:"properties>property"`
+}
+
+type tmxTileset struct {
+	XMLName    xml.Name  `xml:"tileset"`
+	FirstGID   int       `xml:"firstgid,attr,omitempty"`
+	Source     string    `xml:"source,attr,omitempty"`
+	Version    string    `xml:"version,attr,omitempty"`
+	Name       string    `xml:"name,attr,omitempty"`
+	TileWidth  int       `xml:"tilewidth,attr,omitempty"`
+	TileHeight int       `xml:"tileheight,attr,omitempty"`
+	TileCount  int       `xml:"tilecount,attr,omitempty"`
+	Columns    int       `xml:"columns,attr,omitempty"`
+	Image      *tmxImage `xml:"image"`
+	Tiles      []tmxTile `xml:"tile"`
+}
+
+type tmxData struct {
+	Encoding string `xml:"encoding,attr"`
+	Text     string `xml:",chardata"`
+}
+
+type tmxLayer struct {
+	ID     int     `xml:"id,attr"`
+	Name   string  `xml:"name,attr"`
+	Width  int     `xml:"width,attr"`
+	Height int     `xml:"height,attr"`
+	Data   tmxData `xml:"data"`
+}
+
+type tmxObject struct {
+	ID         int           `xml:"id,attr"`
+	Name       string        `xml:"name,attr"`
+	X          float64       `xml:"x,attr"`
+	Y          float64       `xml:"y,attr"`
+	Point      *struct{}     `xml:"point"`
+	Properties []tmxProperty `xml:"properties>property"`
+}
+
+type tmxObjectGroup struct {
+	ID      int         `xml:"id,attr"`
+	Name    string      `xml:"name,attr"`
+	Objects []tmxObject `xml:"object"`
+}
+
+type tmxMap struct {
+	XMLName       xml.Name         `xml:"map"`
+	Version       string           `xml:"version,attr"`
+	Orientation   string           `xml:"orientation,attr"`
+	RenderOrder   string           `xml:"renderorder,attr"`
+	Width         int              `xml:"width,attr"`
+	Height        int              `xml:"height,attr"`
+	TileWidth     int              `xml:"tilewidth,attr"`
+	TileHeight    int              `xml:"tileheight,attr"`
+	HexSideLength int              `xml:"hexsidelength,attr"`
+	StaggerAxis   string           `xml:"staggeraxis,attr"`
+	StaggerIndex  string           `xml:"staggerindex,attr"`
+	NextLayerID   int              `xml:"nextlayerid,attr"`
+	NextObjectID  int              `xml:"nextobjectid,attr"`
+	Properties    []tmxProperty    `xml:"properties>property"`
+	Tilesets      []tmxTileset     `xml:"tileset"`
+	Layers        []tmxLayer       `xml:"layer"`
+	ObjectGroups  []tmxObjectGroup `xml:"objectgroup"`
+}
+
+// tmxStaggerIndex returns which Tiled rows are shifted right for a map of the given depth
+func tmxStaggerIndex(depth int) string {
+	// Row r holds z = depth - 1 - r, and odd z rows are shifted
+	if (depth-1)%2 == 1 {
+		return "even"
+	}
+	return "odd"
+}
+
+func tmxRowToZ(row int, depth int) int {
+	return depth - 1 - row
+}
+
+// tmxObjectPosition returns the pixel position of the hex center for tile (x, z)
+func tmxObjectPosition(x, z, depth int) (float64, float64) {
+	row := depth - 1 - z
+	rowHeight := float64(TmxTileHeight+TmxHexSideLength) / 2
+	px := float64(x*TmxTileWidth) + TmxTileWidth/2
+	if z%2 == 1 {
+		px += TmxTileWidth / 2
+	}
+	py := float64(row)*rowHeight + TmxTileHeight/2
+	return px, py
+}
+
+// tmxObjectTile returns the tile closest to a pixel position
+func tmxObjectTile(px, py float64, depth int) (int, int) {
+	rowHeight := float64(TmxTileHeight+TmxHexSideLength) / 2
+	row := int(math.Round((py - TmxTileHeight/2) / rowHeight))
+	z := tmxRowToZ(row, depth)
+	if z%2 != 0 {
+		px -= TmxTileWidth / 2
+	}
+	x := int(math.Round((px - TmxTileWidth/2) / TmxTileWidth))
+	return x, z
+}
+
+func tmxLayerData(mapData *fileio.HE3Map, gid func(tile *fileio.MapTile) (int, error)) (tmxData, error) {
+	builder := strings.Builder{}
+	builder.WriteString("\n")
+	for row := 0; row < int(mapData.Depth); row++ {
+		z := tmxRowToZ(row, int(mapData.Depth))
+		for x := 0; x < int(mapData.Width); x++ {
+			value, err := gid(mapData.At(x, z))
+			if err != nil {
+				return tmxData{}, fmt.Errorf("tile (%d,%d): %w", x, z, err)
+			}
+			builder.WriteString(strconv.Itoa(value))
+			if x < int(mapData.Width)-1 || row < int(mapData.Depth)-1 {
+				builder.WriteString(",")
+			}
+		}
+		builder.WriteString("\n")
+	}
+	return tmxData{Encoding: "csv", Text: builder.String()}, nil
+}
+
+func tmxArmyObject(id int, name string, army *fileio.Army, px, py float64) tmxObject {
+	return tmxObject{
+		ID:    id,
+		Name:  name,
+		X:     px,
+		Y:     py,
+		Point: &struct{}{},
+		Properties: []tmxProperty{
+			{Name: "armyX", Type: "int", Value: strconv.Itoa(int(army.X))},
+			{Name: "armyY", Type: "int", Value: strconv.Itoa(int(army.Y))},
+			{Name: "infantry", Type: "int", Value: strconv.Itoa(int(army.UnitInfantry))},
+			{Name: "artillery", Type: "int", Value: strconv.Itoa(int(army.UnitArtillery))},
+			{Name: "morale", Type: "float", Value: formatHeight(army.Morale)},
+		},
+	}
+}
+
+func drawTilesetHex(dc *gg.Context, index int, r, g, b, a int) {
+	column := index % TmxTilesetColumns
+	row := index / TmxTilesetColumns
+	x := float64(column*TmxTileWidth) + TmxTileWidth/2
+	y := float64(row*TmxTileHeight) + TmxTileHeight/2
+	dc.DrawRegularPolygon(6, x, y, TmxTileHeight/2, math.Pi/2)
+	dc.SetRGBA255(r, g, b, a)
+	dc.Fill()
+}
+
+func newTilesetImage(tileCount int) (*gg.Context, int) {
+	rows := (tileCount + TmxTilesetColumns - 1) / TmxTilesetColumns
+	return gg.NewContext(TmxTilesetColumns*TmxTileWidth, rows*TmxTileHeight), rows
+}
+
+// writeTmxTileset writes the tileset shared by all maps for terrain, roads and parties
+func writeTmxTileset(tsxFilename string) error {
+	imageFilename := strings.TrimSuffix(tsxFilename, filepath.Ext(tsxFilename)) + ".png"
+	dc, rows := newTilesetImage(TmxTileCount)
+	tiles := []tmxTile{}
+
+	for tileType := fileio.Grass; tileType <= fileio.Capital; tileType++ {
+		tile := &fileio.MapTile{TileType: tileType}
+		tile.SetHeight(fileio.ELEVATION_MOUNTAIN / 2)
+		r, g, b := getTileColor(tile, singleTileMapData(tile), 0, 0)
+		drawTilesetHex(dc, int(tileType), r, g, b, 255)
+		tiles = append(tiles, tmxTile{
+			ID:         int(tileType),
+			Properties: []tmxProperty{{Name: "type", Value: tileType.String()}},
+		})
+	}
+
+	column := TmxRoadTile % TmxTilesetColumns
+	row := TmxRoadTile / TmxTilesetColumns
+	dc.DrawCircle(float64(column*TmxTileWidth)+TmxTileWidth/2, float64(row*TmxTileHeight)+TmxTileHeight/2, TmxTileWidth/5)
+	dc.SetRGB255(78, 53, 36)
+	dc.Fill()
+	tiles = append(tiles, tmxTile{ID: TmxRoadTile, Properties: []tmxProperty{{Name: "road", Type: "bool", Value: "true"}}})
+
+	for party := 0; party < len(PartyColors); party++ {
+		color := PartyColors[party]
+		drawTilesetHex(dc, TmxFirstPartyTile+party, color[0], color[1], color[2], 128)
+		tiles = append(tiles, tmxTile{
+			ID:         TmxFirstPartyTile + party,
+			Properties: []tmxProperty{{Name: "party", Type: "int", Value: strconv.Itoa(party)}},
+		})
+	}
+	if err := dc.SavePNG(imageFilename); err != nil {
+		return err
+	}
+
+	tileset := tmxTileset{
+		Version:    "1.10",
+		Name:       TmxTilesetName,
+		TileWidth:  TmxTileWidth,
+		TileHeight: TmxTileHeight,
+		TileCount:  TmxTileCount,
+		Columns:    TmxTilesetColumns,
+		Image: &tmxImage{
+			Source: filepath.Base(imageFilename),
+			Width:  TmxTilesetColumns * TmxTileWidth,
+			Height: rows * TmxTileHeight,
+		},
+		Tiles: tiles,
+	}
+	return writeXMLFile(tsxFilename, tileset)
+}
+
+// heightTileColor shades sea heights in blue and land heights from green to white
+func heightTileColor(height float32) (int, int, int) {
+	if height <= 0 {
+		shade := math.Min(1, float64(-height)/(-ImportMinHeight))
+		return int(95 - 60*shade), int(149 - 80*shade), int(149 - 60*shade)
+	}
+	shade := math.Min(1, float64(height)/ImportMaxHeight)
+	return int(105 + 150*shade), int(125 + 130*shade), int(54 + 201*shade)
+}
+
+// newTmxHeightTileset creates a tileset with one tile per distinct height in the map
+func newTmxHeightTileset(mapData *fileio.HE3Map, imageFilename string, firstGID int) (tmxTileset, map[float32]int, error) {
+	heightSet := map[float32]bool{}
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			heightSet[mapData.At(x, z).Height] = true
+		}
+	}
+	heights := make([]float32, 0, len(heightSet))
+	for height := range heightSet {
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+
+	dc, rows := newTilesetImage(len(heights))
+	tileIDs := map[float32]int{}
+	tiles := []tmxTile{}
+	for i, height := range heights {
+		r, g, b := heightTileColor(height)
+		drawTilesetHex(dc, i, r, g, b, 255)
+		tileIDs[height] = i
+		tiles = append(tiles, tmxTile{
+			ID:         i,
+			Properties: []tmxProperty{{Name: "height", Type: "float", Value: formatHeight(height)}},
+		})
+	}
+	if err := dc.SavePNG(imageFilename); err != nil {
+		return tmxTileset{}, nil, err
+	}
+
+	tileset := tmxTileset{
+		FirstGID:   firstGID,
+		Name:       "heights",
+		TileWidth:  TmxTileWidth,
+		TileHeight: TmxTileHeight,
+		TileCount:  len(heights),
+		Columns:    TmxTilesetColumns,
+		Image: &tmxImage{
+			Source: filepath.Base(imageFilename),
+			Width:  TmxTilesetColumns * TmxTileWidth,
+			Height: rows * TmxTileHeight,
+		},
+		Tiles: tiles,
+	}
+	return tileset, tileIDs, nil
+}
+
+func writeXMLFile(filename string, value interface{}) error {
+	content, err := xml.MarshalIndent(value, "", " ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append([]byte(xml.Header), append(content, '\n')...), 0644)
+}
+
+// exportTmx writes the map as a Tiled map. The shared tileset and a height tileset
+// for this map are written next to it.
+func exportTmx(mapData *fileio.HE3Map, outputFilename string) error {
+	outputDir := filepath.Dir(outputFilename)
+	tsxFilename := filepath.Join(outputDir, TmxTilesetName+".tsx")
+	if err := writeTmxTileset(tsxFilename); err != nil {
+		return err
+	}
+
+	heightsFilename := strings.TrimSuffix(outputFilename, filepath.Ext(outputFilename)) + "-heights.png"
+	heightTileset, heightTiles, err := newTmxHeightTileset(mapData, heightsFilename, TmxTileCount+1)
+	if err != nil {
+		return err
+	}
+
+	width := int(mapData.Width)
+	depth := int(mapData.Depth)
+	tiledMap := tmxMap{
+		Version:       "1.10",
+		Orientation:   "hexagonal",
+		RenderOrder:   "right-down",
+		Width:         width,
+		Height:        depth,
+		TileWidth:     TmxTileWidth,
+		TileHeight:    TmxTileHeight,
+		HexSideLength: TmxHexSideLength,
+		StaggerAxis:   "y",
+		StaggerIndex:  tmxStaggerIndex(depth),
+		Properties: []tmxProperty{
+			{Name: "title", Value: mapData.MapTitle},
+			{Name: "author", Value: mapData.MapAuthor},
+			{Name: "styleGrass", Type: "int", Value: strconv.Itoa(int(mapData.MapStyle.Grass))},
+			{Name: "styleMountains", Type: "int", Value: strconv.Itoa(int(mapData.MapStyle.Mountains))},
+			{Name: "styleDesert", Type: "int", Value: strconv.Itoa(int(mapData.MapStyle.Desert))},
+			{Name: "styleSea", Type: "int", Value: strconv.Itoa(int(mapData.MapStyle.Sea))},
+			{Name: "styleLight", Type: "int", Value: strconv.Itoa(int(mapData.MapStyle.Light))},
+		},
+		Tilesets: []tmxTileset{
+			{FirstGID: 1, Source: filepath.Base(tsxFilename)},
+			heightTileset,
+		},
+	}
+
+	layerGIDs := []struct {
+		name string
+		gid  func(tile *fileio.MapTile) (int, error)
+	}{
+		{"terrain", func(tile *fileio.MapTile) (int, error) {
+			if tile.TileType > fileio.Capital {
+				return 0, fmt.Errorf("unknown tile type %d", tile.TileType)
+			}
+			return 1 + int(tile.TileType), nil
+		}},
+		{"height", func(tile *fileio.MapTile) (int, error) {
+			return heightTileset.FirstGID + heightTiles[tile.Height], nil
+		}},
+		{"party", func(tile *fileio.MapTile) (int, error) {
+			if tile.Party == -1 {
+				return 0, nil
+			}
+			if tile.Party < 0 || tile.Party >= len(PartyColors) {
+				return 0, fmt.Errorf("party %d has no tile", tile.Party)
+			}
+			return 1 + TmxFirstPartyTile + tile.Party, nil
+		}},
+		{"roads", func(tile *fileio.MapTile) (int, error) {
+			if tile.HasRoad {
+				return 1 + TmxRoadTile, nil
+			}
+			return 0, nil
+		}},
+	}
+	for i, layer := range layerGIDs {
+		data, err := tmxLayerData(mapData, layer.gid)
+		if err != nil {
+			return err
+		}
+		tiledMap.Layers = append(tiledMap.Layers, tmxLayer{
+			ID:     i + 1,
+			Name:   layer.name,
+			Width:  width,
+			Height: depth,
+			Data:   data,
+		})
+	}
+
+	cities := tmxObjectGroup{ID: len(tiledMap.Layers) + 1, Name: "cities"}
+	armies := tmxObjectGroup{ID: len(tiledMap.Layers) + 2, Name: "armies"}
+	objectID := 1
+	for x := 0; x < width; x++ {
+		for z := 0; z < depth; z++ {
+			tile := mapData.At(x, z)
+			px, py := tmxObjectPosition(x, z, depth)
+			if tile.CityName != "" || tile.HasFlag {
+				cities.Objects = append(cities.Objects, tmxObject{
+					ID:         objectID,
+					Name:       tile.CityName,
+					X:          px,
+					Y:          py,
+					Point:      &struct{}{},
+					Properties: []tmxProperty{{Name: "flag", Type: "bool", Value: strconv.FormatBool(tile.HasFlag)}},
+				})
+				objectID++
+			}
+			if tile.Infantry != nil {
+				armies.Objects = append(armies.Objects, tmxArmyObject(objectID, "infantry", tile.Infantry, px, py))
+				objectID++
+			}
+			if tile.Artillery != nil {
+				armies.Objects = append(armies.Objects, tmxArmyObject(objectID, "artillery", tile.Artillery, px, py))
+				objectID++
+			}
+		}
+	}
+	tiledMap.ObjectGroups = []tmxObjectGroup{cities, armies}
+	tiledMap.NextLayerID = len(tiledMap.Layers) + len(tiledMap.ObjectGroups) + 1
+	tiledMap.NextObjectID = objectID
+
+	return writeXMLFile(outputFilename, tiledMap)
+}
+
+func tmxPropertyValue(properties []tmxProperty, name string) (string, bool) {
+	for _, property := range properties {
+		if property.Name == name {
+			return property.Value, true
+		}
+	}
+	return "", false
+}
+
+func parseTmxLayer(layer tmxLayer, width, depth int) ([][]int, error) {
+	if layer.Data.Encoding != "csv" {
+		return nil, fmt.Errorf("layer %s uses %q encoding, only csv is supported", layer.Name, layer.Data.Encoding)
+	}
+	values := strings.FieldsFunc(layer.Data.Text, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' ' || r == '\t'
+	})
+	if len(values) != width*depth {
+		return nil, fmt.Errorf("layer %s has %d tiles, expected %d", layer.Name, len(values), width*depth)
+	}
+
+	gids := make([][]int, width)
+	for x := range gids {
+		gids[x] = make([]int, depth)
+	}
+	for i, value := range values {
+		gid, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s has invalid tile %q", layer.Name, value)
+		}
+		x := i % width
+		z := tmxRowToZ(i/width, depth)
+		gids[x][z] = int(gid & TmxGIDMask)
+	}
+	return gids, nil
+}
+
+func parseTmxArmy(object tmxObject) (*fileio.Army, error) {
+	army := &fileio.Army{}
+	fields := []struct {
+		name  string
+		value *int32
+	}{
+		{"armyX", &army.X},
+		{"armyY", &army.Y},
+		{"infantry", &army.UnitInfantry},
+		{"artillery", &army.UnitArtillery},
+	}
+	for _, field := range fields {
+		text, _ := tmxPropertyValue(object.Properties, field.name)
+		value, err := strconv.ParseInt(text, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("army %d has invalid %s %q", object.ID, field.name, text)
+		}
+		*field.value = int32(value)
+	}
+	text, _ := tmxPropertyValue(object.Properties, "morale")
+	morale, err := strconv.ParseFloat(text, 32)
+	if err != nil {
+		return nil, fmt.Errorf("army %d has invalid morale %q", object.ID, text)
+	}
+	army.Morale = float32(morale)
+	return army, nil
+}
+
+// importTmx reads a Tiled map written by exportTmx
+func importTmx(inputFilename string) (*fileio.HE3Map, error) {
+	content, err := os.ReadFile(inputFilename)
+	if err != nil {
+		return nil, err
+	}
+	tiledMap := tmxMap{}
+	if err := xml.Unmarshal(content, &tiledMap); err != nil {
+		return nil, err
+	}
+
+	width := tiledMap.Width
+	depth := tiledMap.Height
+	if tiledMap.Orientation != "hexagonal" || tiledMap.StaggerAxis != "y" {
+		return nil, fmt.Errorf("expected a hexagonal map staggered along y, got %s staggered along %s",
+			tiledMap.Orientation, tiledMap.StaggerAxis)
+	}
+	if tiledMap.StaggerIndex != tmxStaggerIndex(depth) {
+		return nil, fmt.Errorf("a map with %d rows must use stagger index %s, got %s",
+			depth, tmxStaggerIndex(depth), tiledMap.StaggerIndex)
+	}
+	if width <= 0 || depth <= 0 || width > fileio.MAX_MAP_DIMENSION || depth > fileio.MAX_MAP_DIMENSION {
+		return nil, fmt.Errorf("invalid map size %dx%d, width and depth must be between 1 and %d", width, depth, fileio.MAX_MAP_DIMENSION)
+	}
+
+	mapData := fileio.NewHE3Map(int32(width), int32(depth))
+	mapData.MapTitle, _ = tmxPropertyValue(tiledMap.Properties, "title")
+	mapData.MapAuthor, _ = tmxPropertyValue(tiledMap.Properties, "author")
+	if len(mapData.MapTitle) > fileio.MAX_STRING_LENGTH || len(mapData.MapAuthor) > fileio.MAX_STRING_LENGTH {
+		return nil, fmt.Errorf("title and author can be at most %d bytes", fileio.MAX_STRING_LENGTH)
+	}
+	styleFields := []struct {
+		name  string
+		value *byte
+	}{
+		{"styleGrass", &mapData.MapStyle.Grass},
+		{"styleMountains", &mapData.MapStyle.Mountains},
+		{"styleDesert", &mapData.MapStyle.Desert},
+		{"styleSea", &mapData.MapStyle.Sea},
+		{"styleLight", &mapData.MapStyle.Light},
+	}
+	for _, field := range styleFields {
+		if text, ok := tmxPropertyValue(tiledMap.Properties, field.name); ok {
+			value, err := strconv.ParseUint(text, 10, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q", field.name, text)
+			}
+			*field.value = byte(value)
+		}
+	}
+
+	sharedFirstGID := 0
+	heights := map[int]float32{}
+	for _, tileset := range tiledMap.Tilesets {
+		if tileset.Source != "" && strings.TrimSuffix(filepath.Base(tileset.Source), ".tsx") == TmxTilesetName {
+			sharedFirstGID = tileset.FirstGID
+		}
+		for _, tile := range tileset.Tiles {
+			if text, ok := tmxPropertyValue(tile.Properties, "height"); ok {
+				height, err := strconv.ParseFloat(text, 32)
+				if err != nil {
+					return nil, fmt.Errorf("invalid height %q in tileset %s", text, tileset.Name)
+				}
+				heights[tileset.FirstGID+tile.ID] = float32(height)
+			}
+		}
+	}
+	if sharedFirstGID == 0 {
+		return nil, fmt.Errorf("map does not use the %s tileset", TmxTilesetName)
+	}
+
+	layers := map[string][][]int{}
+	for _, layer := range tiledMap.Layers {
+		gids, err := parseTmxLayer(layer, width, depth)
+		if err != nil {
+			return nil, err
+		}
+		layers[layer.Name] = gids
+	}
+	for _, name := range []string{"terrain", "height"} {
+		if layers[name] == nil {
+			return nil, fmt.Errorf("map has no %s layer", name)
+		}
+	}
+
+	for x := 0; x < width; x++ {
+		for z := 0; z < depth; z++ {
+			tile := mapData.At(x, z)
+			tileType := layers["terrain"][x][z] - sharedFirstGID
+			if tileType < int(fileio.Grass) || tileType > int(fileio.Capital) {
+				return nil, fmt.Errorf("tile (%d,%d) has no terrain", x, z)
+			}
+			tile.TileType = fileio.FieldType(tileType)
+
+			height, ok := heights[layers["height"][x][z]]
+			if !ok {
+				return nil, fmt.Errorf("tile (%d,%d) has no height", x, z)
+			}
+			tile.SetHeight(height)
+
+			if layers["party"] != nil && layers["party"][x][z] != 0 {
+				party := layers["party"][x][z] - sharedFirstGID - TmxFirstPartyTile
+				if party < 0 || party >= len(PartyColors) {
+					return nil, fmt.Errorf("tile (%d,%d) has an unknown party tile", x, z)
+				}
+				tile.Party = party
+			}
+			if layers["roads"] != nil {
+				tile.HasRoad = layers["roads"][x][z] == sharedFirstGID+TmxRoadTile
+			}
+		}
+	}
+
+	for _, group := range tiledMap.ObjectGroups {
+		for _, object := range group.Objects {
+			x, z := tmxObjectTile(object.X, object.Y, depth)
+			if x < 0 || z < 0 || x >= width || z >= depth {
+				return nil, fmt.Errorf("object %d is outside the map", object.ID)
+			}
+			tile := mapData.At(x, z)
+			switch group.Name {
+			case "cities":
+				if len(object.Name) > fileio.MAX_STRING_LENGTH {
+					return nil, fmt.Errorf("object %d has a name longer than %d bytes", object.ID, fileio.MAX_STRING_LENGTH)
+				}
+				tile.CityName = object.Name
+				flag, _ := tmxPropertyValue(object.Properties, "flag")
+				tile.HasFlag = flag == "true"
+			case "armies":
+				army, err := parseTmxArmy(object)
+				if err != nil {
+					return nil, err
+				}
+				if object.Name == "artillery" {
+					tile.Artillery = army
+					tile.HasArtillery = true
+				} else {
+					tile.Infantry = army
+					tile.HasInfantry = true
+				}
+			}
+		}
+	}
+	return mapData, nil
+}
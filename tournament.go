@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+	"github.com/samuelyuan/HexEmpire3Map/sim"
+)
+
+type TournamentSettings struct {
+	Games    int
+	MaxTurns int
+	// Game i is played with seed Seed+i
+	Seed       int64
+	Strategies []string
+	Workers    int
+}
+
+// PartyStats are the results of one party, which is tied to its starting position on the map
+type PartyStats struct {
+	Party     int            `json:"party"`
+	Capital   string         `json:"capital"`
+	X         int            `json:"x"`
+	Z         int            `json:"z"`
+	Games     int            `json:"games"`
+	Wins      int            `json:"wins"`
+	Conquests int            `json:"conquests"`
+	WinRate   float64        `json:"winRate"`
+	Strategy  map[string]int `json:"winsByStrategy"`
+}
+
+type StrategyStats struct {
+	Strategy string  `json:"strategy"`
+	Games    int     `json:"games"`
+	Wins     int     `json:"wins"`
+	WinRate  float64 `json:"winRate"`
+}
+
+type TournamentReport struct {
+	Games        int             `json:"games"`
+	MaxTurns     int             `json:"maxTurns"`
+	Draws        int             `json:"draws"`
+	Conquests    int             `json:"conquests"`
+	AverageTurns float64         `json:"averageTurns"`
+	Parties      []PartyStats    `json:"parties"`
+	Strategies   []StrategyStats `json:"strategies"`
+}
+
+// tournamentPlayers rotates the strategies between the parties so that every
+// strategy plays from every starting position
+func tournamentPlayers(parties []int, strategies []string, gameIndex int) [sim.MaxParties]string {
+	var assigned [sim.MaxParties]string
+	for i, party := range parties {
+		assigned[party] = strategies[(i+gameIndex)%len(strategies)]
+	}
+	return assigned
+}
+
+func playTournamentGame(mapData *fileio.HE3Map, strategies [sim.MaxParties]string, seed int64, maxTurns int) (*sim.GameResult, error) {
+	var players [sim.MaxParties]sim.AIPlayer
+	for party, strategy := range strategies {
+		if strategy == "" {
+			continue
+		}
+		player, err := sim.NewAIPlayer(strategy)
+		if err != nil {
+			return nil, err
+		}
+		players[party] = player
+	}
+	return sim.PlayGame(sim.NewGame(mapData, seed), players, maxTurns)
+}
+
+// runTournament plays AI matches on the map in parallel and counts wins by party and strategy
+func runTournament(mapData *fileio.HE3Map, settings TournamentSettings) (*TournamentReport, error) {
+	if settings.Games <= 0 {
+		return nil, fmt.Errorf("number of games must be positive, got %d", settings.Games)
+	}
+	if len(settings.Strategies) == 0 {
+		return nil, fmt.Errorf("no strategies given")
+	}
+	for _, strategy := range settings.Strategies {
+		if _, err := sim.NewAIPlayer(strategy); err != nil {
+			return nil, err
+		}
+	}
+	parties := sim.NewGame(mapData, settings.Seed).ActiveParties()
+	if len(parties) < 2 {
+		return nil, fmt.Errorf("map needs at least two parties, found %d", len(parties))
+	}
+
+	assignments := make([][sim.MaxParties]string, settings.Games)
+	for i := range assignments {
+		assignments[i] = tournamentPlayers(parties, settings.Strategies, i)
+	}
+
+	results := make([]*sim.GameResult, settings.Games)
+	errs := make([]error, settings.Games)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for worker := 0; worker < max(1, settings.Workers); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i], errs[i] = playTournamentGame(mapData, assignments[i], settings.Seed+int64(i), settings.MaxTurns)
+			}
+		}()
+	}
+	for i := 0; i < settings.Games; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("game %d: %w", i, err)
+		}
+	}
+	return newTournamentReport(mapData, parties, settings, assignments, results), nil
+}
+
+func newTournamentReport(mapData *fileio.HE3Map, parties []int, settings TournamentSettings,
+	assignments [][sim.MaxParties]string, results []*sim.GameResult) *TournamentReport {
+	report := &TournamentReport{
+		Games:    settings.Games,
+		MaxTurns: settings.MaxTurns,
+	}
+
+	partyIndex := map[int]int{}
+	for _, party := range parties {
+		stats := PartyStats{Party: party, X: -1, Z: -1, Strategy: map[string]int{}}
+		for x := 0; x < int(mapData.Width); x++ {
+			for z := 0; z < int(mapData.Depth); z++ {
+				tile := mapData.At(x, z)
+				if tile.Party == party && tile.HasFlag {
+					stats.Capital, stats.X, stats.Z = tile.CityName, x, z
+				}
+			}
+		}
+		partyIndex[party] = len(report.Parties)
+		report.Parties = append(report.Parties, stats)
+	}
+	strategyIndex := map[string]int{}
+	for _, strategy := range settings.Strategies {
+		if _, ok := strategyIndex[strategy]; !ok {
+			strategyIndex[strategy] = len(report.Strategies)
+			report.Strategies = append(report.Strategies, StrategyStats{Strategy: strategy})
+		}
+	}
+
+	totalTurns := 0
+	for i, result := range results {
+		totalTurns += result.Turns
+		for _, party := range parties {
+			report.Parties[partyIndex[party]].Games++
+			report.Strategies[strategyIndex[assignments[i][party]]].Games++
+		}
+		if result.Conquest {
+			report.Conquests++
+		}
+		if result.Winner < 0 {
+			report.Draws++
+			continue
+		}
+		stats := &report.Parties[partyIndex[result.Winner]]
+		stats.Wins++
+		if result.Conquest {
+			stats.Conquests++
+		}
+		strategy := assignments[i][result.Winner]
+		stats.Strategy[strategy]++
+		report.Strategies[strategyIndex[strategy]].Wins++
+	}
+
+	report.AverageTurns = float64(totalTurns) / float64(len(results))
+	for i := range report.Parties {
+		report.Parties[i].WinRate = float64(report.Parties[i].Wins) / float64(report.Parties[i].Games)
+	}
+	for i := range report.Strategies {
+		if report.Strategies[i].Games > 0 {
+			report.Strategies[i].WinRate = float64(report.Strategies[i].Wins) / float64(report.Strategies[i].Games)
+		}
+	}
+	return report
+}
+
+func writeTournamentText(w io.Writer, report *TournamentReport) error {
+	fmt.Fprintf(w, "Games: %d (turn limit %d), conquests: %d, draws: %d, average turns: %.1f\n",
+		report.Games, report.MaxTurns, report.Conquests, report.Draws, report.AverageTurns)
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-6s %-20s %-9s %6s %9s %8s\n", "Party", "Capital", "Position", "Wins", "Conquests", "Win rate")
+	for _, stats := range report.Parties {
+		capital := stats.Capital
+		if capital == "" {
+			capital = "-"
+		}
+		fmt.Fprintf(w, "%-6d %-20s %-9s %6d %9d %7.1f%%\n", stats.Party, capital,
+			fmt.Sprintf("%d,%d", stats.X, stats.Z), stats.Wins, stats.Conquests, 100*stats.WinRate)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "%-12s %6s %6s %8s\n", "Strategy", "Games", "Wins", "Win rate")
+	for _, stats := range report.Strategies {
+		if _, err := fmt.Fprintf(w, "%-12s %6d %6d %7.1f%%\n", stats.Strategy, stats.Games, stats.Wins, 100*stats.WinRate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseStrategies(value string) []string {
+	strategies := []string{}
+	for _, strategy := range strings.Split(value, ",") {
+		if strategy = strings.TrimSpace(strategy); strategy != "" {
+			strategies = append(strategies, strategy)
+		}
+	}
+	return strategies
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+	"github.com/samuelyuan/HexEmpire3Map/sim"
+)
+
+func TestTournamentIgnoresConcurrency(t *testing.T) {
+	mapData, err := fileio.Deserialize(readTestMap(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := TournamentSettings{
+		Games:      6,
+		MaxTurns:   15,
+		Seed:       5,
+		Strategies: sim.Strategies,
+		Workers:    1,
+	}
+	expected, err := runTournament(mapData, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{0, 2, 3, 8} {
+		settings.Workers = workers
+		report, err := runTournament(mapData, settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(report, expected) {
+			t.Errorf("%d workers gave different standings:\n%+v\nexpected:\n%+v", workers, report, expected)
+		}
+	}
+}
+
+func TestTournamentErrors(t *testing.T) {
+	mapData, err := fileio.Deserialize(readTestMap(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		settings TournamentSettings
+		error    string
+	}{
+		{"no games", TournamentSettings{Games: 0, Strategies: sim.Strategies}, "number of games must be positive, got 0"},
+		{"no strategies", TournamentSettings{Games: 1}, "no strategies given"},
+		{"unknown strategy", TournamentSettings{Games: 1, Strategies: []string{"smart"}}, `unknown strategy "smart", expected one of [random greedy capitalrush]`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := runTournament(mapData, test.settings)
+			if err == nil || err.Error() != test.error {
+				t.Errorf("got error %v, expected %q", err, test.error)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+type TransformSettings struct {
+	// Comma separated operations, applied in order
+	Operations string
+	// Crop rectangle as x,z,width,depth
+	Rect string
+	// Sea padding as left,right,bottom,top
+	Pad string
+	// Size for resample
+	Width int32
+	Depth int32
+}
+
+// parseInts reads count comma separated integers, such as "0,0,30,30"
+func parseInts(name string, text string, count int) ([]int32, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != count {
+		return nil, fmt.Errorf("%s %q must have %d comma separated numbers", name, text, count)
+	}
+	values := make([]int32, count)
+	for i, part := range parts {
+		value, err := strconv.ParseInt(strings.TrimSpace(part), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s %q must have %d comma separated numbers", name, text, count)
+		}
+		values[i] = int32(value)
+	}
+	return values, nil
+}
+
+// transformMap applies one transform operation to the map
+func transformMap(mapData *fileio.HE3Map, operation string, settings TransformSettings) (*fileio.HE3Map, error) {
+	switch operation {
+	case "crop":
+		rect, err := parseInts("rect", settings.Rect, 4)
+		if err != nil {
+			return nil, err
+		}
+		return fileio.Crop(mapData, rect[0], rect[1], rect[2], rect[3])
+	case "pad":
+		pad, err := parseInts("pad", settings.Pad, 4)
+		if err != nil {
+			return nil, err
+		}
+		return fileio.Pad(mapData, pad[0], pad[1], pad[2], pad[3])
+	case "mirror-h":
+		return fileio.MirrorHorizontal(mapData)
+	case "mirror-v":
+		return fileio.MirrorVertical(mapData)
+	case "rotate180":
+		return fileio.Rotate180(mapData)
+	case "resample":
+		return fileio.Resample(mapData, settings.Width, settings.Depth)
+	}
+	return nil, fmt.Errorf("unknown transform %q, expected crop, pad, mirror-h, mirror-v, rotate180 or resample", operation)
+}
+
+func runTransform(inputFilename, outputFilename string, settings TransformSettings) error {
+	mapData := fileio.ReadHE3Map(inputFilename)
+	for _, operation := range strings.Split(settings.Operations, ",") {
+		operation = strings.TrimSpace(operation)
+		transformed, err := transformMap(mapData, operation, settings)
+		if err != nil {
+			return fmt.Errorf("%s: %w", operation, err)
+		}
+		fmt.Printf("%s: %dx%d -> %dx%d\n", operation, mapData.Width, mapData.Depth, transformed.Width, transformed.Depth)
+		mapData = transformed
+	}
+	return fileio.WriteHE3File(outputFilename, mapData)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	// SeverityError marks problems that stop the map from saving or loading correctly
+	SeverityError = "error"
+	// SeverityWarning marks problems the game tolerates but are likely mistakes
+	SeverityWarning = "warning"
+	// Strings are saved with a one byte length
+	MaxStringLength = fileio.MAX_STRING_LENGTH
+)
+
+type ValidationIssue struct {
+	Severity string `json:"severity"`
+	// Tile position, or -1 for issues that are not about a single tile
+	X       int    `json:"x"`
+	Z       int    `json:"z"`
+	Message string `json:"message"`
+}
+
+type ValidationReport struct {
+	Valid  bool              `json:"valid"`
+	Issues []ValidationIssue `json:"issues"`
+}
+
+func (report *ValidationReport) add(severity string, x, z int, format string, args ...interface{}) {
+	report.Issues = append(report.Issues, ValidationIssue{
+		Severity: severity,
+		X:        x,
+		Z:        z,
+		Message:  fmt.Sprintf(format, args...),
+	})
+	if severity == SeverityError {
+		report.Valid = false
+	}
+}
+
+func validateArmy(report *ValidationReport, x, z int, kind string, army *fileio.Army, tile *fileio.MapTile) {
+	if army.UnitInfantry < 0 || army.UnitArtillery < 0 {
+		report.add(SeverityError, x, z, "%s has negative unit counts", kind)
+	}
+	if army.Morale < 0 {
+		report.add(SeverityError, x, z, "%s has negative morale %v", kind, army.Morale)
+	}
+	if int(army.X) != x || int(army.Y) != z {
+		report.add(SeverityWarning, x, z, "%s position (%d,%d) does not match its tile", kind, army.X, army.Y)
+	}
+	if tile.IsSea {
+		report.add(SeverityWarning, x, z, "%s is on a sea tile", kind)
+	}
+	if tile.Party == -1 {
+		report.add(SeverityWarning, x, z, "%s is on a neutral tile", kind)
+	}
+}
+
+// validateMap checks that the map can be saved and loaded by the game and
+// reports likely mistakes such as duplicate city names or missing capitals
+func validateMap(mapData *fileio.HE3Map) *ValidationReport {
+	report := &ValidationReport{Valid: true, Issues: []ValidationIssue{}}
+
+	if len(mapData.MapTitle) > MaxStringLength {
+		report.add(SeverityError, -1, -1, "title is longer than %d bytes", MaxStringLength)
+	}
+	if len(mapData.MapAuthor) > MaxStringLength {
+		report.add(SeverityError, -1, -1, "author is longer than %d bytes", MaxStringLength)
+	}
+	if mapData.Width <= 0 || mapData.Depth <= 0 || mapData.Width > fileio.MAX_MAP_DIMENSION || mapData.Depth > fileio.MAX_MAP_DIMENSION {
+		report.add(SeverityError, -1, -1, "invalid map size %dx%d, width and depth must be between 1 and %d", mapData.Width, mapData.Depth, fileio.MAX_MAP_DIMENSION)
+		return report
+	}
+	// Compact maps always have Width x Depth tiles
+	if !mapData.IsCompact() {
+		if len(mapData.MapTiles) != int(mapData.Width) {
+			report.add(SeverityError, -1, -1, "map has %d columns of tiles, expected %d", len(mapData.MapTiles), mapData.Width)
+			return report
+		}
+		for x := range mapData.MapTiles {
+			if len(mapData.MapTiles[x]) != int(mapData.Depth) {
+				report.add(SeverityError, x, -1, "column has %d tiles, expected %d", len(mapData.MapTiles[x]), mapData.Depth)
+				return report
+			}
+		}
+	}
+
+	cityNames := map[string][2]int{}
+	capitals := map[int]int{}
+	partyTiles := map[int]int{}
+	for x := 0; x < int(mapData.Width); x++ {
+		for z := 0; z < int(mapData.Depth); z++ {
+			tile := mapData.At(x, z)
+			if tile.TileType > fileio.Capital {
+				report.add(SeverityError, x, z, "unknown tile type %d", tile.TileType)
+			}
+			if tile.Party < -1 || tile.Party >= len(PartyColors) {
+				report.add(SeverityError, x, z, "party %d is not between -1 and %d", tile.Party, len(PartyColors)-1)
+			} else if tile.Party >= 0 {
+				partyTiles[tile.Party]++
+			}
+			if len(tile.CityName) > MaxStringLength {
+				report.add(SeverityError, x, z, "city name is longer than %d bytes", MaxStringLength)
+			}
+
+			if tile.TileType >= fileio.Airport {
+				if tile.CityName == "" {
+					report.add(SeverityWarning, x, z, "%s has no name", tile.TileType)
+				} else if other, ok := cityNames[tile.CityName]; ok {
+					report.add(SeverityWarning, x, z, "city name %q is also used at (%d,%d)", tile.CityName, other[0], other[1])
+				} else {
+					cityNames[tile.CityName] = [2]int{x, z}
+				}
+				if tile.IsSea {
+					report.add(SeverityWarning, x, z, "%s %q is on a sea tile", tile.TileType, tile.CityName)
+				}
+			} else if tile.CityName != "" {
+				report.add(SeverityWarning, x, z, "%s tile has city name %q, which is not saved", tile.TileType, tile.CityName)
+			}
+
+			if tile.HasFlag {
+				if tile.TileType < fileio.Factory {
+					report.add(SeverityWarning, x, z, "flag on a %s tile", tile.TileType)
+				}
+				if tile.Party >= 0 {
+					capitals[tile.Party]++
+				}
+			}
+
+			if tile.HasInfantry != (tile.Infantry != nil) || tile.HasArtillery != (tile.Artillery != nil) {
+				report.add(SeverityWarning, x, z, "army flags do not match the armies on the tile")
+			}
+			if tile.Infantry != nil {
+				validateArmy(report, x, z, "infantry", tile.Infantry, tile)
+			}
+			if tile.Artillery != nil {
+				validateArmy(report, x, z, "artillery", tile.Artillery, tile)
+			}
+		}
+	}
+
+	for party := 0; party < len(PartyColors); party++ {
+		if partyTiles[party] > 0 && capitals[party] == 0 {
+			report.add(SeverityWarning, -1, -1, "party %d owns tiles but has no capital flag", party)
+		}
+		if capitals[party] > 1 {
+			report.add(SeverityWarning, -1, -1, "party %d has %d capital flags", party, capitals[party])
+		}
+	}
+	return report
+}
+
+func writeValidationText(w io.Writer, report *ValidationReport) error {
+	for _, issue := range report.Issues {
+		if issue.X >= 0 && issue.Z >= 0 {
+			fmt.Fprintf(w, "%s (%d,%d): %s\n", issue.Severity, issue.X, issue.Z, issue.Message)
+		} else {
+			fmt.Fprintf(w, "%s: %s\n", issue.Severity, issue.Message)
+		}
+	}
+	if report.Valid {
+		_, err := fmt.Fprintf(w, "Map is valid (%d warnings)\n", len(report.Issues))
+		return err
+	}
+	_, err := fmt.Fprintln(w, "Map is invalid")
+	return err
+}
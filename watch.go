@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+const (
+	// WatchInterval is how often the input is checked for changes
+	WatchInterval = 500 * time.Millisecond
+	// WatchDebounce is how long the input must stay unchanged before it is converted, so
+	// that a burst of saves is converted once
+	WatchDebounce = 300 * time.Millisecond
+)
+
+// WatchModes are the modes that support -watch
+var WatchModes = []string{"visualize", "decompress", "totext", "geojson", "html"}
+
+// watchConverter is the conversion a watched mode runs each time the input changes. It
+// gets both the file content and the map decoded from it.
+type watchConverter func(w io.Writer, content []byte, mapData *fileio.HE3Map) error
+
+func newWatchConverter(mode string, geoReference GeoReference) (watchConverter, error) {
+	switch mode {
+	case "visualize":
+		return func(w io.Writer, content []byte, mapData *fileio.HE3Map) error {
+			return renderMap(newMapData(mapData), nil).EncodePNG(w)
+		}, nil
+	case "decompress":
+		// Write the decoded bytes as they are, like decompress does without -watch
+		return func(w io.Writer, content []byte, mapData *fileio.HE3Map) error {
+			data, err := fileio.DecodeData(content)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
+		}, nil
+	case "totext":
+		return func(w io.Writer, content []byte, mapData *fileio.HE3Map) error {
+			return fileio.WriteTextMap(w, mapData)
+		}, nil
+	case "geojson":
+		return func(w io.Writer, content []byte, mapData *fileio.HE3Map) error {
+			return writeJSON(w, exportGeoJSON(mapData, geoReference))
+		}, nil
+	case "html":
+		return func(w io.Writer, content []byte, mapData *fileio.HE3Map) error {
+			return writeViewer(w, mapData)
+		}, nil
+	}
+	return nil, fmt.Errorf("-watch is not supported for mode %q, only for %v", mode, WatchModes)
+}
+
+// watchState identifies a version of the input file
+type watchState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statWatched(filename string) (watchState, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return watchState{}, err
+	}
+	return watchState{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// writeFileAtomically writes the output to a temporary file and renames it over the
+// output, so that the output is never left half written
+func writeFileAtomically(filename string, write func(w io.Writer) error) error {
+	tempFile, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tempFile.Name())
+	if err := write(tempFile); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tempFile.Name(), filename)
+}
+
+// convertWatched decodes the input and converts it. The output is only replaced if both succeed.
+func convertWatched(inputFilename, outputFilename string, convert watchConverter) error {
+	content, err := os.ReadFile(inputFilename)
+	if err != nil {
+		return err
+	}
+	mapData, err := fileio.Deserialize(content)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(outputFilename, func(w io.Writer) error {
+		return convert(w, content, mapData)
+	})
+}
+
+// runWatch converts the input and converts it again whenever it changes, until interrupted
+func runWatch(inputFilename, outputFilename string, convert watchConverter) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("Watching %s, press Ctrl+C to stop", inputFilename)
+	watchFile(ctx, inputFilename, outputFilename, convert, WatchInterval, WatchDebounce)
+	return nil
+}
+
+// watchFile checks the input every interval until ctx is done. A change is converted once
+// the file has stopped changing for the debounce time. If the new file can't be read, for
+// example because the editor is still writing it, the previous output is kept.
+func watchFile(ctx context.Context, inputFilename, outputFilename string, convert watchConverter, interval, debounce time.Duration) {
+	var converted watchState
+	var lastError string
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		state, err := statWatched(inputFilename)
+		if err == nil && state != converted {
+			// Wait for the editor to finish saving before reading the file
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(debounce):
+			}
+			if settled, err := statWatched(inputFilename); err == nil && settled == state {
+				converted = state
+				if err := convertWatched(inputFilename, outputFilename, convert); err != nil {
+					log.Printf("Kept the previous %s: %v", outputFilename, err)
+				} else {
+					log.Printf("Updated %s", outputFilename)
+				}
+				lastError = ""
+			}
+		} else if err != nil && err.Error() != lastError {
+			log.Printf("Waiting for %s: %v", inputFilename, err)
+			lastError = err.Error()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
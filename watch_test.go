@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samuelyuan/HexEmpire3Map/fileio"
+)
+
+func TestWatchDecompressWritesDecodedBytes(t *testing.T) {
+	content := readTestMap(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "map.he3")
+	output := filepath.Join(dir, "map.bin")
+	if err := os.WriteFile(input, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	convert, err := newWatchConverter("decompress", GeoReference{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := convertWatched(input, output, convert); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := fileio.DecodeData(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(written, expected) {
+		t.Error("-watch decompress output differs from the decoded input")
+	}
+}
+
+func TestConvertWatchedKeepsPreviousOutput(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "map.he3")
+	output := filepath.Join(dir, "map.txt")
+	if err := os.WriteFile(output, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	convert, err := newWatchConverter("totext", GeoReference{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := func(w io.Writer, content []byte, mapData *fileio.HE3Map) error {
+		w.Write([]byte("half written"))
+		return errors.New("conversion failed")
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		convert watchConverter
+	}{
+		{"half saved input", readTestMap(t)[:100], convert},
+		{"empty input", []byte{}, convert},
+		{"failed conversion", readTestMap(t), failing},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.WriteFile(input, test.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := convertWatched(input, output, test.convert); err == nil {
+				t.Fatal("expected an error")
+			}
+			written, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(written) != "previous" {
+				t.Errorf("output was replaced with %q", written)
+			}
+		})
+	}
+
+	// Only the output is left in the directory, without temporary files
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "map.he3" && entry.Name() != "map.txt" {
+			t.Errorf("temporary file %s was left behind", entry.Name())
+		}
+	}
+}
+
+// watchRecorder is a converter that records the size of each input it converts
+type watchRecorder struct {
+	mutex sync.Mutex
+	sizes []int
+}
+
+func (recorder *watchRecorder) convert(w io.Writer, content []byte, mapData *fileio.HE3Map) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.sizes = append(recorder.sizes, len(content))
+	_, err := w.Write(content)
+	return err
+}
+
+func (recorder *watchRecorder) converted() []int {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]int{}, recorder.sizes...)
+}
+
+// waitFor polls until condition holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	content := readTestMap(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "map.he3")
+	output := filepath.Join(dir, "map.out")
+	if err := os.WriteFile(input, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &watchRecorder{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchFile(ctx, input, output, recorder.convert, 10*time.Millisecond, 200*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor(t, "the first conversion", func() bool { return len(recorder.converted()) == 1 })
+
+	// A burst of saves is converted once, after the last one. Base64 ignores newlines, so
+	// each save is a valid map of a different size.
+	for i := 1; i <= 10; i++ {
+		save := append(append([]byte{}, content...), strings.Repeat("\n", i)...)
+		if err := os.WriteFile(input, save, 0644); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sizes := recorder.converted(); len(sizes) != 1 {
+		t.Fatalf("converted %v during the burst of saves, expected to wait for it to end", sizes)
+	}
+	waitFor(t, "the burst of saves to be converted", func() bool { return len(recorder.converted()) == 2 })
+	if sizes := recorder.converted(); sizes[1] != len(content)+10 {
+		t.Errorf("converted a save of %d bytes, expected the last save of %d bytes", sizes[1], len(content)+10)
+	}
+
+	// A save that can't be read keeps the previous output
+	if err := os.WriteFile(input, content[:100], 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if sizes := recorder.converted(); len(sizes) != 2 {
+		t.Errorf("converted %v, expected the half saved map to be skipped", sizes)
+	}
+	written, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != len(content)+10 {
+		t.Errorf("output has %d bytes, expected the previous %d bytes", len(written), len(content)+10)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a line of YAML without its indentation and comment
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	next  int
+}
+
+// parseYAML reads the subset of YAML that batch manifests use: block mappings and
+// sequences, plain and quoted scalars, flow lists such as [a, b] and comments. Mappings
+// are returned as map[string]any, sequences as []any and scalars as strings.
+func parseYAML(text string) (any, error) {
+	parser := &yamlParser{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", i+1)
+		}
+		parser.lines = append(parser.lines, yamlLine{number: i + 1, indent: len(line) - len(trimmed), text: trimmed})
+	}
+	if len(parser.lines) == 0 {
+		return map[string]any{}, nil
+	}
+	value, err := parser.parseNode()
+	if err != nil {
+		return nil, err
+	}
+	if parser.next < len(parser.lines) {
+		return nil, parser.errorf(parser.lines[parser.next], "unexpected indentation")
+	}
+	return value, nil
+}
+
+// stripYAMLComment removes a # comment that is outside quotes
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch {
+		case quote != 0:
+			if line[i] == quote {
+				quote = 0
+			}
+		case line[i] == '"' || line[i] == '\'':
+			quote = line[i]
+		case line[i] == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func (parser *yamlParser) errorf(line yamlLine, format string, args ...any) error {
+	return fmt.Errorf("line %d: "+format, append([]any{line.number}, args...)...)
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// cutYAMLKey splits "key: value" into key and value
+func cutYAMLKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") || strings.HasPrefix(text, "[") {
+		return "", "", false
+	}
+	if key, ok := strings.CutSuffix(text, ":"); ok && !strings.Contains(key, ": ") {
+		return strings.TrimSpace(key), "", true
+	}
+	key, value, ok := strings.Cut(text, ": ")
+	return strings.TrimSpace(key), strings.TrimSpace(value), ok
+}
+
+// parseNode reads the mapping or sequence that starts at the next line
+func (parser *yamlParser) parseNode() (any, error) {
+	line := parser.lines[parser.next]
+	if isYAMLSequenceItem(line.text) {
+		return parser.parseSequence(line.indent)
+	}
+	return parser.parseMapping(line.indent)
+}
+
+func (parser *yamlParser) parseMapping(indent int) (map[string]any, error) {
+	mapping := map[string]any{}
+	for parser.next < len(parser.lines) {
+		line := parser.lines[parser.next]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, parser.errorf(line, "unexpected indentation")
+		}
+		key, value, ok := cutYAMLKey(line.text)
+		if !ok || key == "" {
+			return nil, parser.errorf(line, "expected key: value, got %q", line.text)
+		}
+		if _, ok := mapping[key]; ok {
+			return nil, parser.errorf(line, "duplicate key %q", key)
+		}
+		parser.next++
+		if value != "" {
+			scalar, err := parseYAMLScalar(value)
+			if err != nil {
+				return nil, parser.errorf(line, "%v", err)
+			}
+			mapping[key] = scalar
+			continue
+		}
+
+		// A nested block is indented further, except that a sequence may line up with its key
+		mapping[key] = ""
+		if parser.next < len(parser.lines) {
+			next := parser.lines[parser.next]
+			if next.indent > indent || (next.indent == indent && isYAMLSequenceItem(next.text)) {
+				node, err := parser.parseNode()
+				if err != nil {
+					return nil, err
+				}
+				mapping[key] = node
+			}
+		}
+	}
+	return mapping, nil
+}
+
+func (parser *yamlParser) parseSequence(indent int) ([]any, error) {
+	items := []any{}
+	for parser.next < len(parser.lines) {
+		line := parser.lines[parser.next]
+		if line.indent < indent || (line.indent == indent && !isYAMLSequenceItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, parser.errorf(line, "unexpected indentation")
+		}
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if rest == "" {
+			parser.next++
+			var item any = ""
+			if parser.next < len(parser.lines) && parser.lines[parser.next].indent > indent {
+				node, err := parser.parseNode()
+				if err != nil {
+					return nil, err
+				}
+				item = node
+			}
+			items = append(items, item)
+			continue
+		}
+		if _, _, ok := cutYAMLKey(rest); ok || isYAMLSequenceItem(rest) {
+			// The keys of a mapping in a sequence line up with the text after the dash
+			offset := len(line.text) - len(rest)
+			parser.lines[parser.next] = yamlLine{number: line.number, indent: indent + offset, text: rest}
+			node, err := parser.parseNode()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, node)
+			continue
+		}
+		parser.next++
+		scalar, err := parseYAMLScalar(rest)
+		if err != nil {
+			return nil, parser.errorf(line, "%v", err)
+		}
+		items = append(items, scalar)
+	}
+	return items, nil
+}
+
+// parseYAMLScalar reads a plain or quoted string, or a flow list of them
+func parseYAMLScalar(text string) (any, error) {
+	switch {
+	case strings.HasPrefix(text, "\""):
+		value, err := strconv.Unquote(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quoted string %s", text)
+		}
+		return value, nil
+	case strings.HasPrefix(text, "'"):
+		if len(text) < 2 || !strings.HasSuffix(text, "'") {
+			return nil, fmt.Errorf("invalid quoted string %s", text)
+		}
+		return strings.ReplaceAll(text[1:len(text)-1], "''", "'"), nil
+	case strings.HasPrefix(text, "["):
+		if !strings.HasSuffix(text, "]") {
+			return nil, fmt.Errorf("flow list %s is missing ]", text)
+		}
+		items := []any{}
+		inner := strings.TrimSpace(text[1 : len(text)-1])
+		if inner == "" {
+			return items, nil
+		}
+		for _, part := range splitYAMLFlowList(inner) {
+			item, err := parseYAMLScalar(strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case strings.HasPrefix(text, "{"):
+		return nil, fmt.Errorf("flow mappings are not supported, use one key per line")
+	}
+	return text, nil
+}
+
+// splitYAMLFlowList splits the items of a flow list on the commas that are outside quotes
+func splitYAMLFlowList(text string) []string {
+	parts := []string{}
+	start := 0
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case quote != 0:
+			if text[i] == quote {
+				quote = 0
+			}
+		case text[i] == '"' || text[i] == '\'':
+			quote = text[i]
+		case text[i] == ',':
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, text[start:])
+}
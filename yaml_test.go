@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		expected any
+	}{
+		{"empty", "# nothing here\n---\n", map[string]any{}},
+		{"scalars", "a: 1\nb: plain text\nc: \"quoted: # not a comment\"\nd: 'it''s'\n", map[string]any{
+			"a": "1", "b": "plain text", "c": "quoted: # not a comment", "d": "it's",
+		}},
+		{"comments", "a: 1 # one\n# whole line\nb: x#y\n", map[string]any{"a": "1", "b": "x#y"}},
+		{"nested mapping", "outer:\n  inner:\n    key: value\n  other: 2\n", map[string]any{
+			"outer": map[string]any{"inner": map[string]any{"key": "value"}, "other": "2"},
+		}},
+		{"empty value", "a:\nb: 1\n", map[string]any{"a": "", "b": "1"}},
+		{"sequence", "- a\n- \"b\"\n-\n", []any{"a", "b", ""}},
+		{"sequence lined up with its key", "inputs:\n- a.he3\n- b.he3\nworkers: 2\n", map[string]any{
+			"inputs": []any{"a.he3", "b.he3"}, "workers": "2",
+		}},
+		{"mappings in a sequence", "pipeline:\n  - op: validate\n  - op: visualize\n    output: \"{name}.png\"\n", map[string]any{
+			"pipeline": []any{
+				map[string]any{"op": "validate"},
+				map[string]any{"op": "visualize", "output": "{name}.png"},
+			},
+		}},
+		{"nested sequences", "- - a\n  - b\n- c\n", []any{[]any{"a", "b"}, "c"}},
+		{"flow list", "a: [x, \"y\", 'z']\nb: []\n", map[string]any{"a": []any{"x", "y", "z"}, "b": []any{}}},
+		{"flow list with quoted commas", `a: ["x, y", 'it''s, here', "say \"hi\", bye", z]`, map[string]any{
+			"a": []any{"x, y", "it's, here", `say "hi", bye`, "z"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, err := parseYAML(test.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(value, test.expected) {
+				t.Errorf("got %#v, expected %#v", value, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		error string
+	}{
+		{"tab indent", "a:\n\tb: 1\n", "line 2: indent with spaces, not tabs"},
+		{"over-indented key", "a: 1\n  b: 2\n", "line 2: unexpected indentation"},
+		{"duplicate key", "a: 1\na: 2\n", `line 2: duplicate key "a"`},
+		{"missing colon", "a: 1\njust text\n", `line 2: expected key: value, got "just text"`},
+		{"unterminated double quote", "a: \"open\n", "line 1: invalid quoted string \"open"},
+		{"unterminated single quote", "a: 'open\n", "line 1: invalid quoted string 'open"},
+		{"unterminated flow list", "a: [x, y\n", "line 1: flow list [x, y is missing ]"},
+		{"quoted item in flow list", "a: [\"x, y]\n", "line 1: invalid quoted string \"x, y"},
+		{"flow mapping", "a: {b: 1}\n", "line 1: flow mappings are not supported, use one key per line"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseYAML(test.text)
+			if err == nil || err.Error() != test.error {
+				t.Errorf("got error %v, expected %q", err, test.error)
+			}
+		})
+	}
+}